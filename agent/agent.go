@@ -3,28 +3,33 @@ package agent
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // 通用函数调用
 type FunctionCall struct {
-	ID   string         `json:"id,omitempty"`   //函数调用ID
-	Args map[string]any `json:"args,omitempty"` //函数调用参数
-	Name string         `json:"name,omitempty"` //函数调用名称
+	ID      string         `json:"id,omitempty"`       //函数调用ID
+	Args    map[string]any `json:"args,omitempty"`     //函数调用参数
+	Name    string         `json:"name,omitempty"`     //函数调用名称
+	RawArgs string         `json:"raw_args,omitempty"` //参数不是合法JSON时模型返回的原始参数，此时Args为空
 }
 
 // 方法响应
 type FunctionResponse struct {
-	ID     string         `json:"id,omitempty"`     //函数响应ID
-	Name   string         `json:"name,omitempty"`   //函数名称
-	Result map[string]any `json:"result,omitempty"` //函数响应
+	ID         string         `json:"id,omitempty"`          //函数响应ID
+	Name       string         `json:"name,omitempty"`        //函数名称
+	Result     map[string]any `json:"result,omitempty"`      //函数响应
+	FullOutput string         `json:"full_output,omitempty"` //结果超出长度限制时的完整输出，只用于日志，不发送给模型
 }
 
 // 通用消息 不存储也不转换工具消息 只记录对话
 type ChatMessage struct {
 	Role              string             `json:"role"`                         //预设 标准open格式 其他库进行适配
 	Content           string             `json:"content"`                      //输出
+	Parts             []ContentPart      `json:"parts,omitempty"`              //多模态内容片段，Content不为空时排在其后
 	ToolCalls         []FunctionCall     `json:"tool_calls,omitempty"`         //工具调用
 	FunctionResponses []FunctionResponse `json:"function_responses,omitempty"` //函数响应
 }
@@ -39,10 +44,32 @@ type FunctionDefinitionParam struct {
 // ToolFunction 定义一个可以被执行的工具函数
 type ToolFunction func(args map[string]interface{}) (string, error)
 
+// ContextToolFunction 定义一个带上下文的工具函数，可感知对话取消、超时和请求级的值
+type ContextToolFunction func(ctx context.Context, args map[string]interface{}) (string, error)
+
+// ToolOptions 工具的可选配置
+type ToolOptions struct {
+	Timeout         time.Duration // 单次执行超时时间，0表示不限制，超时后以错误结果返回给模型
+	RequireApproval bool          // 执行前需要人工审批，模型调用时对话暂停并返回ApprovalRequiredError
+
+	Middlewares []ToolMiddleware // 只作用于该工具的中间件，在AgentConfig.ToolMiddlewares内层
+	ResultLimit *ResultLimit     // 结果长度限制，优先于AgentConfig.ToolResultLimit
+}
+
 // Tool 定义工具及其处理函数
 type Tool struct {
-	Function FunctionDefinitionParam // 函数定义
-	Handler  ToolFunction            // 处理函数
+	Function       FunctionDefinitionParam // 函数定义
+	Handler        ToolFunction            // 处理函数
+	ContextHandler ContextToolFunction     // 带上下文的处理函数，设置后优先于Handler
+	Options        ToolOptions             // 工具配置
+}
+
+// call 调用工具处理函数
+func (t Tool) call(ctx context.Context, args map[string]interface{}) (string, error) {
+	if t.ContextHandler != nil {
+		return t.ContextHandler(ctx, args)
+	}
+	return t.Handler(args)
 }
 
 // StreamHandler 流式消息回调函数定义
@@ -50,10 +77,40 @@ type StreamHandler func(text string)
 
 // TokenUsage token使用统计
 type TokenUsage struct {
-	TotalTokens      int `json:"total_tokens"`      // 总消耗
-	PromptTokens     int `json:"prompt_tokens"`     // 提示词消耗
-	CompletionTokens int `json:"completion_tokens"` // 完成消耗(响应消耗)
-	CacheTokens      int `json:"cache_tokens"`      // 缓存命中
+	TotalTokens      int `json:"total_tokens"`       // 总消耗
+	PromptTokens     int `json:"prompt_tokens"`      // 提示词消耗
+	CompletionTokens int `json:"completion_tokens"`  // 完成消耗(响应消耗)
+	CacheTokens      int `json:"cache_tokens"`       // 缓存命中
+	CacheWriteTokens int `json:"cache_write_tokens"` // 写入缓存的提示词，已包含在PromptTokens中(Anthropic)
+	ReasoningTokens  int `json:"reasoning_tokens"`   // 思考/推理消耗，已包含在CompletionTokens中
+}
+
+// Add 累加另一份token统计
+func (u *TokenUsage) Add(other *TokenUsage) {
+	if other == nil {
+		return
+	}
+	u.TotalTokens += other.TotalTokens
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.CacheTokens += other.CacheTokens
+	u.CacheWriteTokens += other.CacheWriteTokens
+	u.ReasoningTokens += other.ReasoningTokens
+}
+
+// ResponseSchema 结构化输出配置，模型的最终回答为符合Schema的JSON
+type ResponseSchema struct {
+	Name        string                 // 名称，只能包含字母、数字、下划线和中划线，默认为response
+	Description string                 // 描述，帮助模型理解输出用途
+	Schema      map[string]interface{} // JSON Schema，可通过toolgen.ResponseSchemaFor从结构体生成
+	Strict      bool                   // OpenAI严格模式，要求所有字段必填且对象声明additionalProperties为false
+}
+
+// ThinkingConfig 思考/推理配置
+type ThinkingConfig struct {
+	Enabled         bool   // 返回思考内容，通过EventThinkingDelta事件单独推送，不计入回答(Gemini、Anthropic)
+	BudgetTokens    int    // 思考token预算，0为模型默认(Gemini)；Anthropic最小为1024
+	ReasoningEffort string // 推理强度low、medium、high，为空时使用模型默认(OpenAI o系列模型)
 }
 
 type FunctionCallingConfig struct {
@@ -66,7 +123,10 @@ type AgentConfig struct {
 	APIKey   string // API密钥
 	BaseURL  string // 基础URL
 	ProxyURL string // 代理URL
-	Debug    bool   // 调试模式
+	Debug    bool   // 调试模式，未设置Logger时以Debug级别输出到标准输出
+
+	// 结构化日志，为空时只在调试模式下输出；启用LevelTrace时记录脱敏的请求体和响应体
+	Logger *slog.Logger
 
 	ModelName string
 
@@ -76,18 +136,46 @@ type AgentConfig struct {
 	TopP        float64 // 采样阈值，控制输出多样性，默认为1.0
 
 	// 安全控制
-	MaxLoops int // 最大对话循环次数，防止AI递归，默认为5
+	MaxLoops int          // 最大对话循环次数，防止AI递归，默认为5
+	Budget   *BudgetLimit // 单次对话的token和费用预算，为空时不限制
 	//函数调用模式
 	FunctionCallingConfig *FunctionCallingConfig
 
 	//第一次必须使用函数
 	OnecFunctionCallingConfigModeAny bool
 
+	// 思考/推理配置
+	Thinking *ThinkingConfig
+
+	// 结构化输出，Gemini部分模型不支持同时使用工具
+	ResponseSchema *ResponseSchema
+
+	// 上下文预算，对话开始时按策略裁剪传入的历史，不裁剪对话循环中产生的消息
+	ContextPolicy *ContextPolicy
+
+	// 工具并发
+	MaxParallelToolCalls int // 同一轮多个工具调用的最大并发数，小于等于1时顺序执行
+
+	// 调用未注册的工具或参数不合法时的处理策略，默认self_correct
+	ToolCallErrorPolicy ToolCallErrorPolicy
+
+	// 作用于所有工具的中间件，第一个在最外层
+	ToolMiddlewares []ToolMiddleware
+
+	// 工具结果长度限制，超出时按策略截断或总结后发送给模型
+	ToolResultLimit *ResultLimit
+
 	// 频率限制配置
 	EnableRateLimit bool  // 是否启用频率限制
 	RateLimitDelay  int64 // 多轮对话间的延迟时间(毫秒)
 
-	Client *http.Client
+	// 重试配置
+	RetryPolicy *RetryPolicy // 供应商临时错误(429、5xx、连接重置等)的重试策略，为空时不重试
+
+	// OpenTelemetry追踪和指标，为空时不启用
+	Telemetry *Telemetry
+
+	Client *http.Client // 自定义HTTP客户端，设置后忽略ProxyURL
 }
 
 // agent接口
@@ -98,21 +186,42 @@ type Agent interface {
 		history []ChatMessage, //如果要保存系统指令和user提示词直接在history中添加
 		handler StreamHandler, //流式消息回调
 	) (*TokenUsage, []ChatMessage, error) //返回token使用统计和对话历史
-	RegisterTool(function FunctionDefinitionParam, handler ToolFunction) error //注册工具
-	SetDebug(debug bool)                                                       //设置调试模式
+	StreamRunConversationEvents(
+		ctx context.Context, //上下文
+		modelName string, //模型名称
+		history []ChatMessage, //如果要保存系统指令和user提示词直接在history中添加
+		handler StreamEventHandler, //结构化流式事件回调
+	) (*TokenUsage, []ChatMessage, error) //返回token使用统计和对话历史
+	ResumeConversation(
+		ctx context.Context, //上下文
+		pending *PendingApproval, //审批暂停时返回的对话状态
+		decisions []ApprovalDecision, //对待审批工具调用的决定
+		handler StreamEventHandler, //结构化流式事件回调
+	) (*TokenUsage, []ChatMessage, error) //返回token使用统计和恢复后产生的对话历史
+	RegisterTool(function FunctionDefinitionParam, handler ToolFunction) error                                    //注册工具
+	RegisterContextTool(function FunctionDefinitionParam, handler ContextToolFunction, options ToolOptions) error //注册带上下文的工具
+	UnregisterTool(name string) error                                                                             //注销工具
+	ListTools() []FunctionDefinitionParam                                                                         //列出已注册工具
+	SetDebug(debug bool)                                                                                          //设置调试模式
 }
 
 type AgentName string
 
 const (
-	OpenAI AgentName = "openAI"
-	Gemini AgentName = "gemini"
+	OpenAI    AgentName = "openAI"
+	Gemini    AgentName = "gemini"
+	Anthropic AgentName = "anthropic"
 )
 
 type AgentService struct {
 	AgentMap map[AgentName]Agent
 	ctx      context.Context
 	lock     sync.RWMutex
+	fallback *FallbackPolicy // 降级策略
+	store    HistoryStore    // 对话历史存储
+	pricing  *PricingTable   // 价格表
+
+	usageTotals map[usageKey]*UsageTotal // 按agent和模型累计的使用统计
 }
 
 func NewAgentService(ctx context.Context) *AgentService {
@@ -147,9 +256,36 @@ func (s *AgentService) StreamRunConversation(
 	history []ChatMessage, //如果要保存系统指令和user提示词直接在history中添加
 	handler StreamHandler, //流式消息回调
 ) (*TokenUsage, []ChatMessage, error) { //返回token使用统计和对话历史
+	return s.StreamRunConversationEvents(ctx, agentName, modelName, history, handler.EventHandler())
+}
+
+func (s *AgentService) StreamRunConversationEvents(
+	ctx context.Context, //上下文
+	agentName AgentName, //agent名称
+	modelName string, //模型名称
+	history []ChatMessage, //如果要保存系统指令和user提示词直接在history中添加
+	handler StreamEventHandler, //结构化流式事件回调
+) (*TokenUsage, []ChatMessage, error) { //返回token使用统计和对话历史
+	// 未配置降级策略时只尝试主agent，统一在其中记录使用统计
+	result, err := s.StreamRunConversationWithFallback(ctx, agentName, modelName, history, handler)
+	return result.Usage, result.History, err
+}
+
+// ResumeConversation 审批后恢复暂停的对话，需要使用暂停时的agent
+func (s *AgentService) ResumeConversation(
+	ctx context.Context, //上下文
+	agentName AgentName, //agent名称
+	pending *PendingApproval, //审批暂停时返回的对话状态
+	decisions []ApprovalDecision, //对待审批工具调用的决定
+	handler StreamEventHandler, //结构化流式事件回调
+) (*TokenUsage, []ChatMessage, error) { //返回token使用统计和恢复后产生的对话历史
 	agent, err := s.GetAgent(agentName)
 	if err != nil {
 		return nil, nil, err
 	}
-	return agent.StreamRunConversation(ctx, modelName, history, handler)
+	usage, history, err := agent.ResumeConversation(ctx, pending, decisions, handler)
+	if pending != nil {
+		s.recordUsage(agentName, resolveModelName(agent, pending.ModelName), usage)
+	}
+	return usage, history, err
 }
@@ -0,0 +1,1091 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	anthropicBaseURL           = "https://api.anthropic.com" // 默认API地址
+	anthropicVersion           = "2023-06-01"                // anthropic-version请求头
+	anthropicDefaultMaxTokens  = 4096                        // Messages API必须指定max_tokens，未配置时使用
+	anthropicMinThinkingBudget = 1024                        // 思考token预算下限
+)
+
+// AnthropicAgent 通过HTTP和SSE调用Anthropic Messages API的代理
+type AnthropicAgent struct {
+	client  *http.Client
+	baseURL string
+	config  AgentConfig
+	tools   map[string]Tool
+	toolsMu sync.RWMutex // 保护tools，对话开始时复制本次可用的工具
+
+	telemetry *telemetry   // OpenTelemetry追踪和指标，未配置时为空
+	logger    *slog.Logger // 结构化日志
+}
+
+// NewAnthropicAgent 创建一个新的Anthropic代理
+func NewAnthropicAgent(config AgentConfig) (*AnthropicAgent, error) {
+	logger := newLogger(config, "anthropic")
+
+	// 优先使用自定义HTTP客户端，其次使用代理
+	httpClient := config.Client
+	if httpClient == nil {
+		httpClient = &http.Client{}
+		if config.ProxyURL != "" {
+			proxyURL, err := url.Parse(config.ProxyURL)
+			if err != nil {
+				return nil, fmt.Errorf("解析代理URL错误: %v", err)
+			}
+			httpClient.Transport = &http.Transport{
+				Proxy: http.ProxyURL(proxyURL),
+			}
+		}
+	}
+	// 设置了Logger时记录HTTP流量，是否输出由LevelTrace决定
+	if config.Logger != nil {
+		httpClient = loggingClient(httpClient, logger)
+	}
+
+	baseURL := anthropicBaseURL
+	if config.BaseURL != "" {
+		baseURL = strings.TrimSuffix(config.BaseURL, "/")
+	}
+
+	// 设置默认值
+	if config.MaxLoops <= 0 {
+		config.MaxLoops = 5
+	}
+	if config.Temperature < 0 {
+		config.Temperature = 0.7
+	}
+	if config.TopP < 0 {
+		config.TopP = 1.0
+	}
+	if config.RateLimitDelay < 0 {
+		config.RateLimitDelay = 0
+	}
+
+	return &AnthropicAgent{
+		client:  httpClient,
+		baseURL: baseURL,
+		config:  config,
+		tools:   make(map[string]Tool),
+
+		telemetry: newTelemetry(config.Telemetry, "anthropic"),
+		logger:    logger,
+	}, nil
+}
+
+// StreamRunConversation 实现Agent接口的流式对话方法
+func (aa *AnthropicAgent) StreamRunConversation(
+	ctx context.Context,
+	modelName string,
+	history []ChatMessage,
+	handler StreamHandler,
+) (*TokenUsage, []ChatMessage, error) {
+	return aa.StreamRunConversationEvents(ctx, modelName, history, handler.EventHandler())
+}
+
+// StreamRunConversationEvents 实现Agent接口的结构化事件流式对话方法
+func (aa *AnthropicAgent) StreamRunConversationEvents(
+	ctx context.Context,
+	modelName string,
+	history []ChatMessage,
+	handler StreamEventHandler,
+) (*TokenUsage, []ChatMessage, error) {
+	return aa.runConversation(ctx, modelName, history, handler, nil, nil)
+}
+
+// ResumeConversation 实现Agent接口的审批恢复方法，先按决定执行暂停时的工具调用，再继续对话循环
+func (aa *AnthropicAgent) ResumeConversation(
+	ctx context.Context,
+	pending *PendingApproval,
+	decisions []ApprovalDecision,
+	handler StreamEventHandler,
+) (*TokenUsage, []ChatMessage, error) {
+	if pending == nil {
+		return nil, nil, fmt.Errorf("待审批状态为空")
+	}
+	return aa.runConversation(ctx, pending.ModelName, pending.History, handler, pending, approvalDecisionMap(decisions))
+}
+
+// runConversation 对话循环，resume不为空时从审批暂停处继续
+func (aa *AnthropicAgent) runConversation(
+	ctx context.Context,
+	modelName string,
+	history []ChatMessage,
+	handler StreamEventHandler,
+	resume *PendingApproval,
+	decisions map[string]ApprovalDecision,
+) (_ *TokenUsage, _ []ChatMessage, err error) {
+	// 本次请求可用的工具，打包工具参数
+	aa.toolsMu.RLock()
+	tools := selectTools(ctx, aa.tools)
+	aa.toolsMu.RUnlock()
+	toolParams := aa.buildToolParams(tools)
+
+	// 按上下文预算裁剪历史，工具调用和对应的响应不会被拆开
+	// 暂停时保存的是裁剪后的历史，恢复时不再裁剪，避免重复总结
+	if resume == nil {
+		trimmed, err := aa.config.ContextPolicy.apply(ctx, history)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(trimmed) != len(history) {
+			aa.logger.DebugContext(ctx, "历史超出上下文预算，已裁剪", "messages", len(trimmed), "original", len(history))
+		}
+		history = trimmed
+	}
+
+	// 初始化token统计
+	tokenUsage := &TokenUsage{}
+
+	// 初始化对话历史，只记录本次对话
+	var conversationHistory []ChatMessage
+
+	// 添加最后一条用户消息到对话历史（本次问题）
+	if len(history) > 0 {
+		lastMsg := history[len(history)-1]
+		if lastMsg.Role == "user" {
+			conversationHistory = append(conversationHistory, lastMsg)
+		}
+	}
+
+	// 如果没有提供模型名称，使用默认值
+	if modelName == "" {
+		modelName = aa.DefaultModelName()
+	}
+
+	// 对话span，结束时记录累计token和错误
+	ctx, conv := aa.telemetry.startConversation(ctx, modelName)
+	defer func() { conv.end(tokenUsage, err) }()
+
+	// 系统消息合并为system参数，其他消息转换为Anthropic格式
+	var systems []string
+	var messages []anthropicMessage
+	for _, msg := range history {
+		if msg.Role == "system" {
+			systems = append(systems, msg.textContent())
+			continue
+		}
+		messages = append(messages, aa.convertMessage(msg))
+	}
+
+	// 从审批暂停处恢复时，最后一条助手消息使用暂停时保存的原始内容块
+	if resume != nil && len(resume.ProviderContent) > 0 && len(messages) > 0 && messages[len(messages)-1].Role == "assistant" {
+		var content []anthropicContentBlock
+		if err := json.Unmarshal(resume.ProviderContent, &content); err != nil {
+			aa.logger.WarnContext(ctx, "解析暂停时保存的助手消息错误，使用对话历史", "error", err)
+		} else {
+			messages[len(messages)-1].Content = content
+		}
+	}
+
+	// 对话循环计数器
+	loopCount := 0
+
+	// 执行一轮工具调用，把结果加入消息列表和对话历史
+	// 有工具需要审批时不执行任何工具，返回ApprovalRequiredError
+	runTools := func(calls []FunctionCall, decisions map[string]ApprovalDecision) error {
+		stage := &toolStage{
+			tools:       tools,
+			maxParallel: aa.config.MaxParallelToolCalls,
+			handler:     handler,
+			loop:        loopCount,
+			logger:      aa.logger,
+			errorPolicy: aa.config.ToolCallErrorPolicy,
+			middlewares: aa.config.ToolMiddlewares,
+			resultLimit: aa.config.ToolResultLimit,
+			telemetry:   aa.telemetry,
+		}
+		responses, pending, err := stage.run(ctx, calls, decisions)
+		if len(pending) > 0 {
+			approvalErr := newApprovalRequiredError(modelName, loopCount, history, conversationHistory, calls, pending, spentUsage(resume, tokenUsage))
+			// 保存带签名的思考块，恢复时原样发回
+			if last := messages[len(messages)-1]; last.Role == "assistant" {
+				approvalErr.Pending.ProviderContent, _ = json.Marshal(last.Content)
+			}
+			aa.logger.InfoContext(ctx, "工具需要审批，对话暂停", "loop", loopCount, "pending", len(approvalErr.Pending.Requests))
+			handler.emit(StreamEvent{Type: EventApprovalRequired, Loop: loopCount, Approval: approvalErr.Pending})
+			return approvalErr
+		}
+
+		if len(responses) > 0 {
+			// Anthropic要求本轮所有tool_result放在同一条user消息中
+			toolResponseMsg := ChatMessage{
+				Role:              "tool", // 使用tool角色而不是user
+				FunctionResponses: responses,
+			}
+			conversationHistory = append(conversationHistory, toolResponseMsg)
+			messages = append(messages, aa.convertMessage(toolResponseMsg))
+		}
+
+		// abort策略中止时每个调用都有错误响应，加入历史后再返回错误
+		if err != nil {
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return err
+		}
+		return nil
+	}
+
+	// 从审批暂停处恢复，先执行暂停时的工具调用
+	if resume != nil {
+		loopCount = resume.Loop
+		if err := runTools(resume.ToolCalls, decisions); err != nil {
+			return tokenUsage, conversationHistory, err
+		}
+
+		// 暂停前的token计入预算，超出时不再把工具结果发送给模型
+		if err := aa.config.Budget.check(modelName, loopCount, spentUsage(resume, tokenUsage), conversationHistory); err != nil {
+			aa.logger.WarnContext(ctx, "超出预算，对话停止", "loop", loopCount, "error", err)
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+	}
+
+	// 对话循环
+	for {
+		// 检查循环次数是否超过限制
+		loopCount++
+		if loopCount > aa.config.MaxLoops {
+			err := &MaxLoopsError{MaxLoops: aa.config.MaxLoops}
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+
+		// 频率限制：如果不是第一轮对话且启用了频率限制，则添加延迟
+		if loopCount > 1 && aa.config.EnableRateLimit && aa.config.RateLimitDelay > 0 {
+			aa.logger.DebugContext(ctx, "频率限制等待", "delay_ms", aa.config.RateLimitDelay)
+			select {
+			case <-ctx.Done():
+				return tokenUsage, conversationHistory, ctx.Err()
+			case <-time.After(time.Duration(aa.config.RateLimitDelay) * time.Millisecond):
+				// 等待指定时间后继续
+			}
+		}
+
+		ctx = conv.startLoop(ctx, loopCount)
+		handler.emit(StreamEvent{Type: EventLoopIteration, Loop: loopCount})
+		aa.logger.DebugContext(ctx, "开始流式请求", "model", modelName, "loop", loopCount, "max_loops", aa.config.MaxLoops, "messages", len(messages))
+
+		// 创建请求参数
+		params := aa.buildRequest(ctx, modelName, strings.Join(systems, "\n\n"), messages, toolParams, loopCount)
+
+		// 发起流式请求，临时错误按重试策略重新发起本轮请求
+		request := conv.startRequest(ctx, modelName, aa.config)
+		var turn *anthropicTurn
+		err := retryDo(ctx, aa.config.RetryPolicy, func() error {
+			var err error
+			turn, err = aa.streamTurn(ctx, params, handler, loopCount)
+			return err
+		}, func(attempt int, wait time.Duration, err error) {
+			aa.logger.WarnContext(ctx, "请求失败，等待重试", "loop", loopCount, "attempt", attempt, "wait", wait, "error", err)
+			request.retry(attempt, err)
+			handler.emit(StreamEvent{Type: EventRetry, Loop: loopCount, Attempt: attempt, Err: err})
+		})
+
+		// 检查流是否发生错误
+		if err != nil {
+			err = classifyError(fmt.Errorf("流处理错误: %w", err), loopCount)
+			request.end(nil, "", err)
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+
+		if turn.stopReason != "" {
+			handler.emit(StreamEvent{Type: EventFinishReason, Loop: loopCount, FinishReason: turn.stopReason})
+		}
+
+		// 更新Token使用情况
+		turnUsage := turn.usage.tokenUsage()
+
+		if turnUsage.TotalTokens > 0 {
+			tokenUsage.Add(turnUsage)
+			aa.logger.DebugContext(ctx, "Token使用情况", "loop", loopCount,
+				"total_tokens", tokenUsage.TotalTokens, "prompt_tokens", tokenUsage.PromptTokens, "completion_tokens", tokenUsage.CompletionTokens)
+
+			usageCopy := *tokenUsage
+			handler.emit(StreamEvent{Type: EventUsage, Loop: loopCount, Usage: &usageCopy})
+		}
+		request.end(turnUsage, turn.stopReason, nil)
+
+		// 创建通用格式的助手消息
+		assistantChatMsg := ChatMessage{
+			Role:    "assistant",
+			Content: turn.text(),
+		}
+		aa.logger.Log(ctx, LevelTrace, "收到助手消息", "loop", loopCount, "content", assistantChatMsg.Content)
+
+		// 回答被安全策略拦截
+		if err := contentBlocked(turn.stopReason, "", loopCount, false); err != nil {
+			if assistantChatMsg.Content != "" {
+				conversationHistory = append(conversationHistory, assistantChatMsg)
+			}
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+
+		// 将助手消息原样加入消息列表，保留思考块和签名
+		messages = append(messages, anthropicMessage{Role: "assistant", Content: turn.assistantContent()})
+
+		// 处理工具调用
+		toolCalls := turn.toolCalls()
+		if turn.stopReason == "tool_use" && len(toolCalls) > 0 {
+			aa.logger.DebugContext(ctx, "收到工具调用", "loop", loopCount, "tool_calls", len(toolCalls))
+
+			assistantChatMsg.ToolCalls = toolCalls
+
+			// 超出预算时不再执行本轮的工具
+			if stopped, err := aa.config.Budget.checkBeforeTools(modelName, loopCount, spentUsage(resume, tokenUsage), conversationHistory, assistantChatMsg); err != nil {
+				aa.logger.WarnContext(ctx, "超出预算，对话停止", "loop", loopCount, "error", err)
+				handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+				return tokenUsage, stopped, err
+			}
+
+			// 添加助手消息到对话历史
+			conversationHistory = append(conversationHistory, assistantChatMsg)
+
+			if err := runTools(assistantChatMsg.ToolCalls, nil); err != nil {
+				return tokenUsage, conversationHistory, err
+			}
+
+			// 继续对话
+			continue
+		}
+
+		// 没有工具调用，添加普通助手消息到对话历史
+		conversationHistory = append(conversationHistory, assistantChatMsg)
+
+		// 返回响应内容
+		aa.logger.DebugContext(ctx, "对话结束", "loops", loopCount, "total_tokens", tokenUsage.TotalTokens)
+		return tokenUsage, conversationHistory, nil
+	}
+}
+
+// buildRequest 创建一轮请求的参数
+func (aa *AnthropicAgent) buildRequest(
+	ctx context.Context,
+	modelName string,
+	system string,
+	messages []anthropicMessage,
+	toolParams []anthropicTool,
+	loopCount int,
+) *anthropicRequest {
+	params := &anthropicRequest{
+		Model:     modelName,
+		MaxTokens: aa.config.MaxTokens,
+		System:    system,
+		Messages:  messages,
+		Tools:     toolParams,
+		Stream:    true,
+	}
+	if params.MaxTokens <= 0 {
+		params.MaxTokens = anthropicDefaultMaxTokens
+	}
+
+	// 工具选择，没有工具时不能设置tool_choice
+	if len(toolParams) > 0 {
+		mode := ""
+		var allowed []string
+		if aa.config.FunctionCallingConfig != nil {
+			mode = aa.config.FunctionCallingConfig.Mode
+			allowed = aa.config.FunctionCallingConfig.AllowedFunctionNames
+		}
+		//必须先调用工具
+		if loopCount == 1 && aa.config.OnecFunctionCallingConfigModeAny {
+			mode = "any"
+		}
+		params.ToolChoice = anthropicToolChoiceFor(mode, allowed)
+		if len(allowed) > 1 {
+			aa.logger.WarnContext(ctx, "Anthropic只支持指定一个工具，已忽略AllowedFunctionNames", "functions", allowed)
+		}
+	}
+
+	// 扩展思考，开启后不能设置temperature和top_p
+	thinking := aa.config.Thinking
+	if thinking != nil && thinking.Enabled && !anthropicThinkingAllowed(messages) {
+		aa.logger.WarnContext(ctx, "进行中的工具调用没有思考块，本轮关闭思考", "loop", loopCount)
+		thinking = nil
+	}
+	if thinking != nil && thinking.Enabled {
+		budget := int64(thinking.BudgetTokens)
+		if budget < anthropicMinThinkingBudget {
+			budget = anthropicMinThinkingBudget
+		}
+		// max_tokens必须大于思考预算
+		if params.MaxTokens <= budget {
+			params.MaxTokens = budget + anthropicDefaultMaxTokens
+		}
+		params.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+	} else {
+		//设置温度
+		if aa.config.Temperature > 0 {
+			temperature := aa.config.Temperature
+			params.Temperature = &temperature
+		}
+		//设置topp
+		if aa.config.TopP > 0 {
+			topP := aa.config.TopP
+			params.TopP = &topP
+		}
+	}
+
+	// 结构化输出
+	if rs := aa.config.ResponseSchema; rs != nil && len(rs.Schema) > 0 && loopCount == 1 {
+		aa.logger.WarnContext(ctx, "Anthropic不支持ResponseSchema，已忽略")
+	}
+	return params
+}
+
+// anthropicThinkingAllowed 开启思考时，进行中的工具调用的助手消息必须以思考块开头
+// 历史来自其他供应商或不带签名的对话历史时没有思考块，只能关闭思考
+func anthropicThinkingAllowed(messages []anthropicMessage) bool {
+	if len(messages) == 0 || !messages[len(messages)-1].hasToolResult() {
+		return true
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "assistant" {
+			continue
+		}
+		content := messages[i].Content
+		return len(content) > 0 && (content[0].Type == "thinking" || content[0].Type == "redacted_thinking")
+	}
+	return true
+}
+
+// anthropicToolChoiceFor 把函数调用模式转换为tool_choice，模式兼容OpenAI和Gemini的写法
+func anthropicToolChoiceFor(mode string, allowed []string) *anthropicToolChoice {
+	switch strings.ToLower(mode) {
+	case "none":
+		return &anthropicToolChoice{Type: "none"}
+	case "any", "required":
+		// 只允许一个工具时强制调用该工具
+		if len(allowed) == 1 {
+			return &anthropicToolChoice{Type: "tool", Name: allowed[0]}
+		}
+		return &anthropicToolChoice{Type: "any"}
+	default:
+		return &anthropicToolChoice{Type: "auto"}
+	}
+}
+
+// anthropicTurn 一轮流式请求的结果
+type anthropicTurn struct {
+	blocks     []*anthropicContentBlock // 按index排列的完整内容块
+	stopReason string                   // 结束原因
+	usage      anthropicUsage           // token消耗
+	stopped    bool                     // 是否收到message_stop
+}
+
+// block 返回index对应的内容块，不存在时返回nil
+func (t *anthropicTurn) block(index int) *anthropicContentBlock {
+	if index < 0 || index >= len(t.blocks) {
+		return nil
+	}
+	return t.blocks[index]
+}
+
+// text 返回所有文本块拼接的回答
+func (t *anthropicTurn) text() string {
+	var sb strings.Builder
+	for _, block := range t.blocks {
+		if block != nil && block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	return sb.String()
+}
+
+// toolCalls 把tool_use块转换为通用格式，参数不是合法JSON时保留原始参数
+func (t *anthropicTurn) toolCalls() []FunctionCall {
+	var calls []FunctionCall
+	for _, block := range t.blocks {
+		if block == nil || block.Type != "tool_use" {
+			continue
+		}
+		call := FunctionCall{ID: block.ID, Name: block.Name, RawArgs: block.rawInput}
+		if block.rawInput == "" {
+			if err := json.Unmarshal(block.Input, &call.Args); err != nil {
+				call.RawArgs = string(block.Input)
+			}
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// assistantContent 返回发回模型的助手内容块，去掉空文本块
+func (t *anthropicTurn) assistantContent() []anthropicContentBlock {
+	content := make([]anthropicContentBlock, 0, len(t.blocks))
+	for _, block := range t.blocks {
+		if block == nil || (block.Type == "text" && block.Text == "") {
+			continue
+		}
+		content = append(content, *block)
+	}
+	return content
+}
+
+// streamTurn 发起一轮流式请求并处理响应
+func (aa *AnthropicAgent) streamTurn(
+	ctx context.Context,
+	params *anthropicRequest,
+	handler StreamEventHandler,
+	loopCount int,
+) (*anthropicTurn, error) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求错误: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, aa.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-Api-Key", aa.config.APIKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := aa.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAnthropicError(resp)
+	}
+
+	turn := &anthropicTurn{}
+	// 按index记录流式工具调用，用于推送开始和参数增量事件
+	streamingToolCalls := map[int]*FunctionCall{}
+	partialJSON := map[int]*strings.Builder{}
+
+	err = readSSE(resp.Body, func(data []byte) error {
+		var event anthropicStreamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("解析流式事件错误: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				turn.usage = event.Message.Usage
+			}
+
+		case "content_block_start":
+			if event.ContentBlock == nil {
+				return nil
+			}
+			for len(turn.blocks) <= event.Index {
+				turn.blocks = append(turn.blocks, nil)
+			}
+			block := *event.ContentBlock
+			turn.blocks[event.Index] = &block
+			if block.Type == "text" && block.Text != "" {
+				handler.emit(StreamEvent{Type: EventTextDelta, Loop: loopCount, Text: block.Text})
+			}
+			if block.Type == "tool_use" {
+				toolCall := &FunctionCall{ID: block.ID, Name: block.Name}
+				streamingToolCalls[event.Index] = toolCall
+				partialJSON[event.Index] = &strings.Builder{}
+				handler.emit(StreamEvent{Type: EventToolCallStarted, Loop: loopCount, ToolCall: toolCall})
+			}
+
+		case "content_block_delta":
+			block := turn.block(event.Index)
+			if block == nil {
+				return nil
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				block.Text += event.Delta.Text
+				handler.emit(StreamEvent{Type: EventTextDelta, Loop: loopCount, Text: event.Delta.Text})
+			case "thinking_delta":
+				block.Thinking += event.Delta.Thinking
+				handler.emit(StreamEvent{Type: EventThinkingDelta, Loop: loopCount, Text: event.Delta.Thinking})
+			case "signature_delta":
+				block.Signature += event.Delta.Signature
+			case "input_json_delta":
+				if sb, ok := partialJSON[event.Index]; ok && event.Delta.PartialJSON != "" {
+					sb.WriteString(event.Delta.PartialJSON)
+					handler.emit(StreamEvent{Type: EventToolCallArgsDelta, Loop: loopCount, ToolCall: streamingToolCalls[event.Index], ArgsDelta: event.Delta.PartialJSON})
+				}
+			}
+
+		case "content_block_stop":
+			// 工具参数在块结束时才完整，不是合法JSON时发回空对象并保留原始参数
+			if sb, ok := partialJSON[event.Index]; ok {
+				block := turn.block(event.Index)
+				input := strings.TrimSpace(sb.String())
+				switch {
+				case input == "":
+					block.Input = json.RawMessage("{}")
+				case json.Valid([]byte(input)):
+					block.Input = json.RawMessage(input)
+				default:
+					block.Input = json.RawMessage("{}")
+					block.rawInput = input
+				}
+				aa.logger.DebugContext(ctx, "检测到完整工具调用", "loop", loopCount, "index", event.Index, "tool", block.Name)
+			}
+
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				turn.stopReason = event.Delta.StopReason
+			}
+			// message_delta中的usage为累计值
+			if u := event.Usage; u != nil {
+				if u.OutputTokens > 0 {
+					turn.usage.OutputTokens = u.OutputTokens
+				}
+				if u.InputTokens > 0 {
+					turn.usage.InputTokens = u.InputTokens
+				}
+				if u.CacheCreationInputTokens > 0 {
+					turn.usage.CacheCreationInputTokens = u.CacheCreationInputTokens
+				}
+				if u.CacheReadInputTokens > 0 {
+					turn.usage.CacheReadInputTokens = u.CacheReadInputTokens
+				}
+			}
+
+		case "message_stop":
+			turn.stopped = true
+
+		case "error":
+			// 流中返回的错误没有HTTP状态码，按错误类型推断
+			apiErr := &AnthropicError{}
+			if event.Error != nil {
+				apiErr.Type = event.Error.Type
+				apiErr.Message = event.Error.Message
+			}
+			apiErr.StatusCode = anthropicErrorStatus(apiErr.Type)
+			return apiErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !turn.stopped {
+		return nil, fmt.Errorf("流在message_stop之前结束: %w", io.ErrUnexpectedEOF)
+	}
+	return turn, nil
+}
+
+// readSSE 逐个读取SSE事件，把data字段交给fn处理，多行data按换行拼接
+func readSSE(r io.Reader, fn func(data []byte) error) error {
+	reader := bufio.NewReader(r)
+	var data []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0:
+			// 空行表示一个事件结束
+			if len(data) > 0 {
+				if err := fn(data); err != nil {
+					return err
+				}
+				data = nil
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(line[len("data:"):], []byte(" "))...)
+		}
+
+		if err == io.EOF {
+			if len(data) > 0 {
+				return fn(data)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// AnthropicError Anthropic Messages API返回的错误
+type AnthropicError struct {
+	StatusCode int    // HTTP状态码，流中返回的错误按错误类型推断
+	Type       string // 错误类型，如rate_limit_error、overloaded_error
+	Message    string // 错误信息
+}
+
+func (e *AnthropicError) Error() string {
+	return fmt.Sprintf("anthropic %s(%d): %s", e.Type, e.StatusCode, e.Message)
+}
+
+// newAnthropicError 从非200响应中解析错误
+func newAnthropicError(resp *http.Response) error {
+	apiErr := &AnthropicError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(resp.Body)
+	var body struct {
+		Error *anthropicErrorBody `json:"error"`
+	}
+	if err := json.Unmarshal(data, &body); err == nil && body.Error != nil {
+		apiErr.Type = body.Error.Type
+		apiErr.Message = body.Error.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// anthropicErrorStatus 按错误类型返回对应的HTTP状态码
+func anthropicErrorStatus(errType string) int {
+	switch errType {
+	case "invalid_request_error":
+		return http.StatusBadRequest
+	case "authentication_error":
+		return http.StatusUnauthorized
+	case "permission_error":
+		return http.StatusForbidden
+	case "not_found_error":
+		return http.StatusNotFound
+	case "request_too_large":
+		return http.StatusRequestEntityTooLarge
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "overloaded_error":
+		return 529
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// DefaultModelName 未指定模型时使用的模型名称
+func (aa *AnthropicAgent) DefaultModelName() string {
+	if aa.config.ModelName != "" {
+		return aa.config.ModelName
+	}
+	return "claude-sonnet-4-5" // 默认模型
+}
+
+// RegisterTool 注册一个工具
+func (aa *AnthropicAgent) RegisterTool(function FunctionDefinitionParam, handler ToolFunction) error {
+	if function.Name == "" {
+		return fmt.Errorf("工具名称不能为空")
+	}
+
+	if handler == nil {
+		return fmt.Errorf("工具处理函数不能为空")
+	}
+
+	// 保存工具
+	aa.toolsMu.Lock()
+	defer aa.toolsMu.Unlock()
+	aa.tools[function.Name] = Tool{
+		Function: function,
+		Handler:  handler,
+	}
+
+	return nil
+}
+
+// RegisterContextTool 注册一个带上下文的工具
+func (aa *AnthropicAgent) RegisterContextTool(function FunctionDefinitionParam, handler ContextToolFunction, options ToolOptions) error {
+	if function.Name == "" {
+		return fmt.Errorf("工具名称不能为空")
+	}
+
+	if handler == nil {
+		return fmt.Errorf("工具处理函数不能为空")
+	}
+
+	// 保存工具
+	aa.toolsMu.Lock()
+	defer aa.toolsMu.Unlock()
+	aa.tools[function.Name] = Tool{
+		Function:       function,
+		ContextHandler: handler,
+		Options:        options,
+	}
+
+	return nil
+}
+
+// SetDebug 设置调试模式
+func (aa *AnthropicAgent) SetDebug(debug bool) {
+	aa.config.Debug = debug
+	aa.logger = newLogger(aa.config, "anthropic")
+}
+
+// UnregisterTool 注销工具，不影响进行中的对话
+func (aa *AnthropicAgent) UnregisterTool(name string) error {
+	aa.toolsMu.Lock()
+	defer aa.toolsMu.Unlock()
+	if _, ok := aa.tools[name]; !ok {
+		return &ToolNotFoundError{Name: name}
+	}
+	delete(aa.tools, name)
+	return nil
+}
+
+// ListTools 列出已注册工具的定义，按名称排序
+func (aa *AnthropicAgent) ListTools() []FunctionDefinitionParam {
+	aa.toolsMu.RLock()
+	defer aa.toolsMu.RUnlock()
+	return toolDefinitions(aa.tools)
+}
+
+// 构建工具参数
+func (aa *AnthropicAgent) buildToolParams(tools map[string]Tool) []anthropicTool {
+	toolParams := []anthropicTool{}
+
+	for _, tool := range sortedTools(tools) {
+		// input_schema必须是object类型的JSON Schema
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		toolParams = append(toolParams, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	if aa.logger.Enabled(context.Background(), slog.LevelDebug) {
+		names := make([]string, 0, len(toolParams))
+		for _, param := range toolParams {
+			names = append(names, param.Name)
+		}
+		aa.logger.Debug("工具参数构建完成", "tools", names)
+	}
+	return toolParams
+}
+
+// convertMessage 转换消息角色和内容，工具消息转换为带tool_result的user消息
+func (aa *AnthropicAgent) convertMessage(msg ChatMessage) anthropicMessage {
+	switch msg.Role {
+	case "assistant":
+		var content []anthropicContentBlock
+		if text := msg.textContent(); text != "" {
+			content = append(content, anthropicContentBlock{Type: "text", Text: text})
+		}
+		for _, toolCall := range msg.ToolCalls {
+			// 参数不是合法JSON时无法原样发回，使用空对象
+			input := json.RawMessage("{}")
+			if toolCall.RawArgs == "" && toolCall.Args != nil {
+				if argsBytes, err := json.Marshal(toolCall.Args); err == nil {
+					input = argsBytes
+				} else {
+					aa.logger.Warn("转换工具调用参数错误", "tool", toolCall.Name, "error", err)
+				}
+			}
+			content = append(content, anthropicContentBlock{Type: "tool_use", ID: toolCall.ID, Name: toolCall.Name, Input: input})
+		}
+		return anthropicMessage{Role: "assistant", Content: content}
+
+	case "tool":
+		var content []anthropicContentBlock
+		for _, funcResp := range msg.FunctionResponses {
+			block := anthropicContentBlock{Type: "tool_result", ToolUseID: funcResp.ID}
+			if output, ok := funcResp.Result["output"]; ok && funcResp.Result["error"] != true {
+				block.Content = fmt.Sprintf("%v", output)
+			} else {
+				outputJSON, _ := json.Marshal(funcResp.Result)
+				block.Content = string(outputJSON)
+				block.IsError = funcResp.Result["error"] == true
+			}
+			content = append(content, block)
+		}
+		// 没有函数响应的工具消息，这种情况不太常见
+		if len(content) == 0 {
+			content = append(content, anthropicContentBlock{Type: "text", Text: msg.Content})
+		}
+		return anthropicMessage{Role: "user", Content: content}
+
+	default:
+		// 用户消息和其他角色按用户消息处理
+		return anthropicMessage{Role: "user", Content: aa.convertContentParts(msg)}
+	}
+}
+
+// convertContentParts 转换用户消息的文本和多模态内容片段
+func (aa *AnthropicAgent) convertContentParts(msg ChatMessage) []anthropicContentBlock {
+	var content []anthropicContentBlock
+	for _, part := range msg.contentParts() {
+		switch part.Type {
+		case PartText:
+			if part.Text != "" {
+				content = append(content, anthropicContentBlock{Type: "text", Text: part.Text})
+			}
+
+		case PartImage:
+			content = append(content, anthropicContentBlock{Type: "image", Source: anthropicSourceOf(part)})
+
+		case PartFile:
+			content = append(content, anthropicContentBlock{Type: "document", Source: anthropicSourceOf(part), Title: part.FileName})
+
+		case PartAudio:
+			aa.logger.Warn("Anthropic不支持音频输入，忽略音频片段", "mime_type", part.MIMEType)
+
+		default:
+			aa.logger.Warn("忽略未知的内容片段类型", "type", part.Type)
+		}
+	}
+	// 内容不能为空
+	if len(content) == 0 {
+		content = append(content, anthropicContentBlock{Type: "text", Text: msg.Content})
+	}
+	return content
+}
+
+// anthropicSourceOf 内联数据使用base64，否则使用URL
+func anthropicSourceOf(part ContentPart) *anthropicSource {
+	if len(part.Data) > 0 {
+		return &anthropicSource{
+			Type:      "base64",
+			MediaType: part.MIMEType,
+			Data:      base64.StdEncoding.EncodeToString(part.Data),
+		}
+	}
+	return &anthropicSource{Type: "url", URL: part.URL}
+}
+
+// anthropicRequest Messages API请求体
+type anthropicRequest struct {
+	Model       string               `json:"model"`
+	MaxTokens   int64                `json:"max_tokens"`
+	System      string               `json:"system,omitempty"`
+	Messages    []anthropicMessage   `json:"messages"`
+	Tools       []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Temperature *float64             `json:"temperature,omitempty"`
+	TopP        *float64             `json:"top_p,omitempty"`
+	Thinking    *anthropicThinking   `json:"thinking,omitempty"`
+	Stream      bool                 `json:"stream"`
+}
+
+// anthropicMessage 一条user或assistant消息
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+// hasToolResult 是否为带工具结果的user消息
+func (m anthropicMessage) hasToolResult() bool {
+	for _, block := range m.Content {
+		if block.Type == "tool_result" {
+			return true
+		}
+	}
+	return false
+}
+
+// anthropicContentBlock 内容块，根据Type只填充对应字段
+type anthropicContentBlock struct {
+	Type string `json:"type"` // text、image、document、tool_use、tool_result、thinking、redacted_thinking
+
+	Text string `json:"text,omitempty"` // text
+
+	ID    string          `json:"id,omitempty"`    // tool_use
+	Name  string          `json:"name,omitempty"`  // tool_use
+	Input json.RawMessage `json:"input,omitempty"` // tool_use
+
+	ToolUseID string `json:"tool_use_id,omitempty"` // tool_result
+	Content   string `json:"content,omitempty"`     // tool_result
+	IsError   bool   `json:"is_error,omitempty"`    // tool_result
+
+	Thinking  string `json:"thinking,omitempty"`  // thinking
+	Signature string `json:"signature,omitempty"` // thinking
+	Data      string `json:"data,omitempty"`      // redacted_thinking
+
+	Source *anthropicSource `json:"source,omitempty"` // image、document
+	Title  string           `json:"title,omitempty"`  // document
+
+	rawInput string // 流式参数不是合法JSON时的原始参数
+}
+
+// anthropicSource 图片和文档的来源
+type anthropicSource struct {
+	Type      string `json:"type"` // base64或url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// anthropicTool 工具定义
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+// anthropicToolChoice 工具选择
+type anthropicToolChoice struct {
+	Type string `json:"type"`           // auto、any、tool、none
+	Name string `json:"name,omitempty"` // type为tool时指定的工具
+}
+
+// anthropicThinking 扩展思考配置
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int64  `json:"budget_tokens"`
+}
+
+// anthropicUsage token消耗
+type anthropicUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+// tokenUsage 转换为通用token统计，与OpenAI一致提示词token包含缓存命中和写入缓存的token
+func (u anthropicUsage) tokenUsage() *TokenUsage {
+	promptTokens := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return &TokenUsage{
+		TotalTokens:      int(promptTokens + u.OutputTokens),
+		PromptTokens:     int(promptTokens),
+		CompletionTokens: int(u.OutputTokens),
+		CacheTokens:      int(u.CacheReadInputTokens),
+		CacheWriteTokens: int(u.CacheCreationInputTokens),
+	}
+}
+
+// anthropicErrorBody 错误响应和流中error事件的错误内容
+type anthropicErrorBody struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// anthropicStreamEvent 流式事件，根据Type只填充对应字段
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock *anthropicContentBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage     `json:"usage"`
+	Error *anthropicErrorBody `json:"error"`
+}
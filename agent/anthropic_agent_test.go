@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 测试SSE解析：多行data、CRLF换行和没有结尾空行的最后一个事件
+func TestReadSSE(t *testing.T) {
+	input := "event: a\r\ndata: {\"x\":\r\ndata: 1}\r\n\r\n: 注释\n\nevent: b\ndata: {\"y\":2}"
+	var got []string
+	if err := readSSE(strings.NewReader(input), func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "{\"x\":\n1}" || got[1] != "{\"y\":2}" {
+		t.Errorf("解析结果错误: %q", got)
+	}
+}
+
+// 测试流中的error事件和提前结束的流
+func TestAnthropicStreamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		check  func(error) bool
+	}{
+		{"过载", `data: {"type":"message_start","message":{"usage":{"input_tokens":1}}}` + "\n\n" +
+			`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}` + "\n\n",
+			func(err error) bool {
+				var apiErr *AnthropicError
+				return errors.As(err, &apiErr) && apiErr.StatusCode == 529 && (&RetryPolicy{MaxAttempts: 2}).retryable(err)
+			}},
+		{"断流", `data: {"type":"message_start","message":{"usage":{"input_tokens":1}}}` + "\n\n",
+			func(err error) bool { return errors.Is(err, io.ErrUnexpectedEOF) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tt.stream)
+			}))
+			defer srv.Close()
+			aa, err := NewAnthropicAgent(AgentConfig{BaseURL: srv.URL, Client: srv.Client()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = aa.streamTurn(context.Background(), &anthropicRequest{}, nil, 1)
+			if !tt.check(err) {
+				t.Errorf("错误不符合预期: %v", err)
+			}
+		})
+	}
+}
+
+// 测试历史消息转换为Anthropic格式
+func TestAnthropicConvertMessage(t *testing.T) {
+	aa, err := NewAnthropicAgent(AgentConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assistant := aa.convertMessage(ChatMessage{Role: "assistant", Content: "查询中", ToolCalls: []FunctionCall{
+		{ID: "t1", Name: "search", Args: map[string]interface{}{"q": "go"}},
+		{ID: "t2", Name: "search", RawArgs: "{bad"},
+	}})
+	if len(assistant.Content) != 3 || assistant.Content[1].Type != "tool_use" || string(assistant.Content[1].Input) != `{"q":"go"}` || string(assistant.Content[2].Input) != "{}" {
+		t.Errorf("助手消息转换错误: %+v", assistant)
+	}
+
+	tool := aa.convertMessage(ChatMessage{Role: "tool", FunctionResponses: []FunctionResponse{
+		{ID: "t1", Name: "search", Result: map[string]interface{}{"output": "结果"}},
+		{ID: "t2", Name: "search", Result: map[string]interface{}{"error": true, "message": "参数错误"}},
+	}})
+	if tool.Role != "user" || len(tool.Content) != 2 || tool.Content[0].Content != "结果" || tool.Content[0].IsError || !tool.Content[1].IsError {
+		t.Errorf("工具结果转换错误: %+v", tool)
+	}
+
+	user := aa.convertMessage(ChatMessage{Role: "user", Parts: []ContentPart{NewTextPart("看图"), NewImageDataPart([]byte("png"), "image/png")}})
+	data, _ := json.Marshal(user)
+	if !strings.Contains(string(data), `"source":{"type":"base64","media_type":"image/png","data":"cG5n"}`) {
+		t.Errorf("图片转换错误: %s", data)
+	}
+}
+
+// 测试工具选择和思考参数
+func TestAnthropicBuildRequest(t *testing.T) {
+	aa, err := NewAnthropicAgent(AgentConfig{
+		MaxTokens:             1000,
+		Temperature:           0.5,
+		Thinking:              &ThinkingConfig{Enabled: true, BudgetTokens: 2000},
+		FunctionCallingConfig: &FunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{"search"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := aa.buildRequest(context.Background(), "m", "", nil, []anthropicTool{{Name: "search"}}, 1)
+	if params.ToolChoice == nil || params.ToolChoice.Type != "tool" || params.ToolChoice.Name != "search" {
+		t.Errorf("工具选择错误: %+v", params.ToolChoice)
+	}
+	if params.Thinking == nil || params.Thinking.BudgetTokens != 2000 || params.MaxTokens <= 2000 || params.Temperature != nil {
+		t.Errorf("思考参数错误: %+v", params)
+	}
+
+	params = aa.buildRequest(context.Background(), "m", "", nil, nil, 1)
+	if params.ToolChoice != nil {
+		t.Errorf("没有工具时不应设置tool_choice: %+v", params.ToolChoice)
+	}
+}
+
+// 测试token统计转换：提示词包含缓存命中和写入缓存的token，写入缓存按单独的价格计费
+func TestAnthropicTokenUsage(t *testing.T) {
+	usage := anthropicUsage{InputTokens: 100_000, CacheCreationInputTokens: 200_000, CacheReadInputTokens: 700_000, OutputTokens: 50_000}.tokenUsage()
+	want := TokenUsage{TotalTokens: 1_050_000, PromptTokens: 1_000_000, CompletionTokens: 50_000, CacheTokens: 700_000, CacheWriteTokens: 200_000}
+	if *usage != want {
+		t.Fatalf("token统计错误: %+v", usage)
+	}
+
+	table := &PricingTable{Models: map[string]ModelPrice{
+		"claude-sonnet": {Input: 3, Output: 15, CachedInput: 0.3, CacheWrite: 3.75},
+	}}
+	cost, _ := table.Cost("claude-sonnet-4", usage)
+	// 输入10万*3 + 写入缓存20万*3.75 + 缓存命中70万*0.3 + 输出5万*15
+	if !almostEqual(cost.InputCost, 0.3) || !almostEqual(cost.CacheWriteCost, 0.75) || !almostEqual(cost.CachedInputCost, 0.21) ||
+		!almostEqual(cost.OutputCost, 0.75) || !almostEqual(cost.TotalCost, 2.01) {
+		t.Errorf("费用计算错误: %+v", cost)
+	}
+
+	// 未设置写入缓存价格时按输入价格计算
+	table.Models["claude-sonnet"] = ModelPrice{Input: 3, Output: 15}
+	if cost, _ := table.Cost("claude-sonnet-4", usage); !almostEqual(cost.CacheWriteCost, 0.6) || !almostEqual(cost.InputCost, 0.3) {
+		t.Errorf("费用计算错误: %+v", cost)
+	}
+}
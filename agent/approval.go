@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ApprovalAction 对待审批工具调用的处理方式
+type ApprovalAction string
+
+const (
+	ApprovalApprove ApprovalAction = "approve" // 批准，按模型给出的参数执行
+	ApprovalDeny    ApprovalAction = "deny"    // 拒绝，不执行并把拒绝原因返回给模型
+	ApprovalEdit    ApprovalAction = "edit"    // 修改参数后执行
+)
+
+// ApprovalDecision 对一个待审批工具调用的决定
+type ApprovalDecision struct {
+	CallID string                 `json:"call_id"`          // 工具调用ID
+	Action ApprovalAction         `json:"action"`           // 处理方式
+	Args   map[string]interface{} `json:"args,omitempty"`   // 修改后的参数，Action为edit时使用
+	Reason string                 `json:"reason,omitempty"` // 拒绝原因，Action为deny时返回给模型
+}
+
+// PendingApproval 因工具需要审批而暂停的对话状态，可序列化保存，审批后通过ResumeConversation恢复
+type PendingApproval struct {
+	ModelName string         `json:"model_name"` // 暂停时使用的模型
+	Loop      int            `json:"loop"`       // 暂停时的循环次数
+	History   []ChatMessage  `json:"history"`    // 恢复时发送给模型的完整历史，最后一条为带工具调用的助手消息
+	ToolCalls []FunctionCall `json:"tool_calls"` // 本轮所有工具调用，恢复时按原顺序执行
+	Requests  []FunctionCall `json:"requests"`   // 需要审批的工具调用(调用ID和参数)
+	Usage     TokenUsage     `json:"usage"`      // 暂停前本次对话累计的token，恢复后计入预算
+
+	// 最后一条助手消息的供应商原始内容，ChatMessage无法表示时保存，恢复时原样发回
+	// 如Anthropic开启思考时带签名的思考块，缺少时接口会拒绝恢复的请求
+	ProviderContent json.RawMessage `json:"provider_content,omitempty"`
+}
+
+// ApprovalRequiredError 对话因工具需要人工审批而暂停
+// 本轮的工具都没有执行，返回的对话历史以带工具调用的助手消息结尾
+type ApprovalRequiredError struct {
+	Pending *PendingApproval // 恢复对话所需的状态
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	names := make([]string, 0, len(e.Pending.Requests))
+	for _, call := range e.Pending.Requests {
+		names = append(names, call.Name)
+	}
+	return fmt.Sprintf("工具调用需要审批: %s", strings.Join(names, ", "))
+}
+
+// newApprovalRequiredError 生成审批暂停错误
+// history为本次请求的历史，conversationHistory为本次对话已产生的历史，两者合并为恢复时的完整历史
+func newApprovalRequiredError(modelName string, loop int, history, conversationHistory []ChatMessage, toolCalls, requests []FunctionCall, usage *TokenUsage) *ApprovalRequiredError {
+	progressed := conversationHistory
+	// 本次问题已经在请求历史中，不再重复添加
+	if len(progressed) > 0 && progressed[0].Role == "user" && len(history) > 0 && history[len(history)-1].Role == "user" {
+		progressed = progressed[1:]
+	}
+
+	full := make([]ChatMessage, 0, len(history)+len(progressed))
+	full = append(full, history...)
+	full = append(full, progressed...)
+
+	return &ApprovalRequiredError{Pending: &PendingApproval{
+		ModelName: modelName,
+		Loop:      loop,
+		History:   full,
+		ToolCalls: toolCalls,
+		Requests:  requests,
+		Usage:     *usage,
+	}}
+}
+
+// approvalDecisionMap 按工具调用ID索引审批决定
+func approvalDecisionMap(decisions []ApprovalDecision) map[string]ApprovalDecision {
+	m := make(map[string]ApprovalDecision, len(decisions))
+	for _, d := range decisions {
+		m[d.CallID] = d
+	}
+	return m
+}
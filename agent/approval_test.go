@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// newApprovalTestStage 创建包含普通工具和需要审批工具的执行流程，记录实际执行的调用
+func newApprovalTestStage(executed *[]string) *toolStage {
+	record := func(name string) ContextToolFunction {
+		return func(ctx context.Context, args map[string]interface{}) (string, error) {
+			*executed = append(*executed, fmt.Sprintf("%s:%v", name, args["id"]))
+			return "ok", nil
+		}
+	}
+	return &toolStage{
+		tools: map[string]Tool{
+			"get_user": {
+				Function:       FunctionDefinitionParam{Name: "get_user"},
+				ContextHandler: record("get_user"),
+			},
+			"delete_user": {
+				Function:       FunctionDefinitionParam{Name: "delete_user"},
+				ContextHandler: record("delete_user"),
+				Options:        ToolOptions{RequireApproval: true},
+			},
+		},
+		logger: discardLogger,
+	}
+}
+
+var approvalTestCalls = []FunctionCall{
+	{ID: "call_1", Name: "get_user", Args: map[string]interface{}{"id": 1}},
+	{ID: "call_2", Name: "delete_user", Args: map[string]interface{}{"id": 1}},
+}
+
+// 测试存在未审批的调用时本轮工具都不执行
+func TestToolStageRequiresApproval(t *testing.T) {
+	var executed []string
+	stage := newApprovalTestStage(&executed)
+
+	responses, pending, err := stage.run(context.Background(), approvalTestCalls, nil)
+	if err != nil {
+		t.Fatalf("执行返回错误: %v", err)
+	}
+	if len(responses) != 0 || len(executed) != 0 {
+		t.Fatalf("审批前不应执行工具: responses=%v, executed=%v", responses, executed)
+	}
+	if len(pending) != 1 || pending[0].ID != "call_2" {
+		t.Fatalf("待审批调用错误: %+v", pending)
+	}
+}
+
+// 测试批准、修改参数和拒绝三种决定
+func TestToolStageDecisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision ApprovalDecision
+		executed []string
+		errType  interface{}
+	}{
+		{"批准", ApprovalDecision{CallID: "call_2", Action: ApprovalApprove}, []string{"get_user:1", "delete_user:1"}, nil},
+		{"修改参数", ApprovalDecision{CallID: "call_2", Action: ApprovalEdit, Args: map[string]interface{}{"id": 2}}, []string{"get_user:1", "delete_user:2"}, nil},
+		{"拒绝", ApprovalDecision{CallID: "call_2", Action: ApprovalDeny, Reason: "不允许删除"}, []string{"get_user:1"}, toolErrorDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed []string
+			stage := newApprovalTestStage(&executed)
+
+			decisions := approvalDecisionMap([]ApprovalDecision{tt.decision})
+			responses, pending, err := stage.run(context.Background(), approvalTestCalls, decisions)
+			if err != nil || len(pending) != 0 {
+				t.Fatalf("执行失败: pending=%v, err=%v", pending, err)
+			}
+			if fmt.Sprint(executed) != fmt.Sprint(tt.executed) {
+				t.Errorf("执行的工具错误: got %v, want %v", executed, tt.executed)
+			}
+			if len(responses) != 2 || responses[0].ID != "call_1" || responses[1].ID != "call_2" {
+				t.Fatalf("函数响应顺序错误: %+v", responses)
+			}
+			if got := responses[1].Result["error_type"]; got != tt.errType {
+				t.Errorf("error_type错误: got %v, want %v", got, tt.errType)
+			}
+		})
+	}
+}
+
+// 测试暂停状态的历史由请求历史和本次对话合并，本次问题不重复
+func TestNewApprovalRequiredError(t *testing.T) {
+	history := []ChatMessage{
+		{Role: "system", Content: "你是助手"},
+		{Role: "user", Content: "删除用户1"},
+	}
+	assistant := ChatMessage{Role: "assistant", ToolCalls: approvalTestCalls}
+	conversation := []ChatMessage{history[1], assistant}
+
+	err := newApprovalRequiredError("model", 2, history, conversation, approvalTestCalls, approvalTestCalls[1:], &TokenUsage{})
+	pending := err.Pending
+	if len(pending.History) != 3 || pending.History[2].Role != "assistant" {
+		t.Fatalf("恢复历史错误: %+v", pending.History)
+	}
+	if pending.Loop != 2 || pending.ModelName != "model" || len(pending.Requests) != 1 {
+		t.Errorf("暂停状态错误: %+v", pending)
+	}
+}
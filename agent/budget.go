@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBudgetExceeded 对话超出预算，可通过errors.Is判断，详细信息见BudgetExceededError
+var ErrBudgetExceeded = errors.New("对话超出预算")
+
+// BudgetLimit 单次对话的预算，模型返回工具调用后、执行工具前检查，超出时不再执行工具也不再请求模型
+// 审批恢复时暂停前的token计入累计，执行完暂停的工具后再检查一次
+type BudgetLimit struct {
+	MaxTotalTokens int           // 累计token上限，小于等于0时不限制
+	MaxCost        float64       // 累计费用上限，小于等于0时不限制
+	Pricing        *PricingTable // 计算费用使用的价格表，MaxCost大于0时必须设置，模型不在价格表中时不检查费用
+}
+
+// BudgetExceededError 对话超出预算
+// 执行工具前超出时本轮的工具不会执行，返回的对话历史中不包含未执行的工具调用
+type BudgetExceededError struct {
+	ModelName string        // 使用的模型
+	Loop      int           // 超出预算时的循环次数
+	Usage     TokenUsage    // 本次对话累计的token
+	Cost      float64       // 本次对话累计的费用，未检查费用时为0
+	Limit     *BudgetLimit  // 超出的预算
+	History   []ChatMessage // 本次对话已产生的历史
+}
+
+func (e *BudgetExceededError) Error() string {
+	if e.Limit.MaxTotalTokens > 0 && e.Usage.TotalTokens > e.Limit.MaxTotalTokens {
+		return fmt.Sprintf("%v: 累计token %d 超过上限 %d", ErrBudgetExceeded, e.Usage.TotalTokens, e.Limit.MaxTotalTokens)
+	}
+	return fmt.Sprintf("%v: 累计费用 %.6f 超过上限 %.6f", ErrBudgetExceeded, e.Cost, e.Limit.MaxCost)
+}
+
+func (e *BudgetExceededError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// check 检查累计使用是否超出预算，超出时返回BudgetExceededError
+func (b *BudgetLimit) check(modelName string, loop int, usage *TokenUsage, history []ChatMessage) error {
+	if b == nil {
+		return nil
+	}
+
+	exceeded := b.MaxTotalTokens > 0 && usage.TotalTokens > b.MaxTotalTokens
+	cost := 0.0
+	if b.MaxCost > 0 {
+		if c, ok := b.Pricing.Cost(modelName, usage); ok {
+			cost = c.TotalCost
+			exceeded = exceeded || cost > b.MaxCost
+		}
+	}
+	if !exceeded {
+		return nil
+	}
+
+	return &BudgetExceededError{
+		ModelName: modelName,
+		Loop:      loop,
+		Usage:     *usage,
+		Cost:      cost,
+		Limit:     b,
+		History:   append([]ChatMessage{}, history...),
+	}
+}
+
+// checkBeforeTools 执行工具前检查预算
+// 超出时本轮的工具不再执行，助手消息去掉工具调用后加入返回的历史，没有文本时不保留
+func (b *BudgetLimit) checkBeforeTools(modelName string, loop int, usage *TokenUsage, history []ChatMessage, assistant ChatMessage) ([]ChatMessage, error) {
+	if b == nil {
+		return history, nil
+	}
+	stopped := history
+	if assistant.Content != "" || len(assistant.Parts) > 0 {
+		assistant.ToolCalls = nil
+		stopped = append(append([]ChatMessage{}, history...), assistant)
+	}
+	if err := b.check(modelName, loop, usage, stopped); err != nil {
+		return stopped, err
+	}
+	return history, nil
+}
+
+// spentUsage 预算检查使用的累计token，审批恢复时包含暂停前的token
+func spentUsage(resume *PendingApproval, usage *TokenUsage) *TokenUsage {
+	if resume == nil {
+		return usage
+	}
+	spent := resume.Usage
+	spent.Add(usage)
+	return &spent
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBudgetLimitCheck(t *testing.T) {
+	pricing := &PricingTable{Models: map[string]ModelPrice{"gpt-4o": {Input: 2.5, Output: 10}}}
+	usage := &TokenUsage{PromptTokens: 800_000, CompletionTokens: 100_000, TotalTokens: 900_000}
+	history := []ChatMessage{{Role: "user", Content: "你好"}}
+
+	tests := []struct {
+		name     string
+		limit    *BudgetLimit
+		model    string
+		exceeded bool
+	}{
+		{"未设置", nil, "gpt-4o", false},
+		{"token未超出", &BudgetLimit{MaxTotalTokens: 900_000}, "gpt-4o", false},
+		{"token超出", &BudgetLimit{MaxTotalTokens: 899_999}, "gpt-4o", true},
+		// 80万*2.5 + 10万*10 = 3
+		{"费用未超出", &BudgetLimit{MaxCost: 3, Pricing: pricing}, "gpt-4o", false},
+		{"费用超出", &BudgetLimit{MaxCost: 2.99, Pricing: pricing}, "gpt-4o", true},
+		{"模型不在价格表中", &BudgetLimit{MaxCost: 0.01, Pricing: pricing}, "claude-3", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limit.check(tt.model, 2, usage, history)
+			if (err != nil) != tt.exceeded {
+				t.Fatalf("检查结果错误: %v", err)
+			}
+			if err == nil {
+				return
+			}
+			var budgetErr *BudgetExceededError
+			if !errors.Is(err, ErrBudgetExceeded) || !errors.As(err, &budgetErr) {
+				t.Fatalf("错误类型错误: %T", err)
+			}
+			if budgetErr.Loop != 2 || budgetErr.Usage.TotalTokens != 900_000 || len(budgetErr.History) != 1 {
+				t.Errorf("错误内容错误: %+v", budgetErr)
+			}
+		})
+	}
+}
+
+func TestBudgetExceededNoFallback(t *testing.T) {
+	policy := &FallbackPolicy{ErrorKinds: []FallbackErrorKind{FallbackOnAnyError}}
+	err := (&BudgetLimit{MaxTotalTokens: 1}).check("m", 1, &TokenUsage{TotalTokens: 2}, nil)
+	if policy.shouldFallback(err) {
+		t.Error("超出预算不应触发降级")
+	}
+}
@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"testing"
+)
+
+// 测试Gemini生成配置包含思考配置
+func TestGeminiThinkingConfig(t *testing.T) {
+	ga, err := NewGeminiAgent(AgentConfig{
+		APIKey:   "test",
+		Thinking: &ThinkingConfig{Enabled: true, BudgetTokens: 2048},
+	})
+	if err != nil {
+		t.Fatalf("创建agent失败: %v", err)
+	}
+
+	config := ga.createGenerateContentConfig(nil)
+	if config.ThinkingConfig == nil || !config.ThinkingConfig.IncludeThoughts {
+		t.Fatalf("没有开启思考: %+v", config.ThinkingConfig)
+	}
+	if budget := config.ThinkingConfig.ThinkingBudget; budget == nil || *budget != 2048 {
+		t.Errorf("思考预算错误: %v", budget)
+	}
+}
+
+// 测试token统计累加包含推理token
+func TestTokenUsageAdd(t *testing.T) {
+	usage := &TokenUsage{TotalTokens: 10, CompletionTokens: 6, ReasoningTokens: 4}
+	usage.Add(&TokenUsage{TotalTokens: 5, PromptTokens: 2, CompletionTokens: 3, CacheTokens: 1, CacheWriteTokens: 1, ReasoningTokens: 2})
+	usage.Add(nil)
+
+	want := TokenUsage{TotalTokens: 15, PromptTokens: 2, CompletionTokens: 9, CacheTokens: 1, CacheWriteTokens: 1, ReasoningTokens: 6}
+	if *usage != want {
+		t.Errorf("累加结果错误: got %+v, want %+v", *usage, want)
+	}
+}
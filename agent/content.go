@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// ContentPartType 消息内容片段类型
+type ContentPartType string
+
+const (
+	PartText  ContentPartType = "text"  // 文本
+	PartImage ContentPartType = "image" // 图片，URL或内联数据
+	PartAudio ContentPartType = "audio" // 音频，内联数据
+	PartFile  ContentPartType = "file"  // 文件，文件引用或内联数据(如PDF)
+)
+
+// ContentPart 多模态消息内容片段
+type ContentPart struct {
+	Type     ContentPartType `json:"type"`                //片段类型
+	Text     string          `json:"text,omitempty"`      //文本内容
+	URL      string          `json:"url,omitempty"`       //图片URL或文件引用(Gemini为文件URI，OpenAI为file_id)
+	Data     []byte          `json:"data,omitempty"`      //内联数据，JSON序列化为base64
+	MIMEType string          `json:"mime_type,omitempty"` //MIME类型，如image/png、audio/wav、application/pdf
+	FileName string          `json:"file_name,omitempty"` //文件名，OpenAI内联文件需要
+}
+
+// NewTextPart 创建文本片段
+func NewTextPart(text string) ContentPart {
+	return ContentPart{Type: PartText, Text: text}
+}
+
+// NewImageURLPart 创建图片URL片段
+func NewImageURLPart(url string, mimeType string) ContentPart {
+	return ContentPart{Type: PartImage, URL: url, MIMEType: mimeType}
+}
+
+// NewImageDataPart 创建内联图片片段
+func NewImageDataPart(data []byte, mimeType string) ContentPart {
+	return ContentPart{Type: PartImage, Data: data, MIMEType: mimeType}
+}
+
+// NewAudioDataPart 创建内联音频片段
+func NewAudioDataPart(data []byte, mimeType string) ContentPart {
+	return ContentPart{Type: PartAudio, Data: data, MIMEType: mimeType}
+}
+
+// NewFileRefPart 创建文件引用片段，Gemini为上传后的文件URI，OpenAI为file_id
+func NewFileRefPart(ref string, mimeType string) ContentPart {
+	return ContentPart{Type: PartFile, URL: ref, MIMEType: mimeType}
+}
+
+// NewFileDataPart 创建内联文件片段
+func NewFileDataPart(data []byte, mimeType string, fileName string) ContentPart {
+	return ContentPart{Type: PartFile, Data: data, MIMEType: mimeType, FileName: fileName}
+}
+
+// contentParts 返回消息的全部内容片段，Content不为空时作为第一个文本片段，兼容只使用Content的消息
+func (m ChatMessage) contentParts() []ContentPart {
+	if m.Content == "" {
+		return m.Parts
+	}
+	parts := make([]ContentPart, 0, len(m.Parts)+1)
+	parts = append(parts, NewTextPart(m.Content))
+	return append(parts, m.Parts...)
+}
+
+// textContent 返回消息的全部文本，用于只支持文本的场景
+func (m ChatMessage) textContent() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	var texts []string
+	for _, part := range m.contentParts() {
+		if part.Type == PartText && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// dataURL 把内联数据编码为data URL
+func (p ContentPart) dataURL() string {
+	return "data:" + p.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+}
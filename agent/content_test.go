@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var multimodalTestMessage = ChatMessage{
+	Role:    "user",
+	Content: "描述这些内容",
+	Parts: []ContentPart{
+		NewImageURLPart("https://example.com/a.png", "image/png"),
+		NewImageDataPart([]byte("png"), "image/png"),
+		NewAudioDataPart([]byte("mp3"), "audio/mpeg"),
+		NewFileRefPart("file-123", "application/pdf"),
+		NewFileDataPart([]byte("pdf"), "application/pdf", "a.pdf"),
+	},
+}
+
+// 测试OpenAI多模态用户消息转换为内容片段数组
+func TestOpenAIConvertMultimodalMessage(t *testing.T) {
+	oa, err := NewOpenAIAgent(AgentConfig{APIKey: "test"})
+	if err != nil {
+		t.Fatalf("创建agent失败: %v", err)
+	}
+
+	data, _ := json.Marshal(oa.convertMessage(multimodalTestMessage))
+	got := string(data)
+	for _, want := range []string{
+		`"text":"描述这些内容"`,
+		`"url":"https://example.com/a.png"`,
+		`"url":"data:image/png;base64,cG5n"`,
+		`"input_audio":{"data":"bXAz","format":"mp3"}`,
+		`"file_id":"file-123"`,
+		`"file_data":"data:application/pdf;base64,cGRm"`,
+		`"filename":"a.pdf"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("转换结果缺少 %s: %s", want, got)
+		}
+	}
+
+	// 只有Content的消息保持字符串格式
+	data, _ = json.Marshal(oa.convertMessage(ChatMessage{Role: "user", Content: "你好"}))
+	if !strings.Contains(string(data), `"content":"你好"`) {
+		t.Errorf("纯文本消息格式错误: %s", data)
+	}
+}
+
+// 测试Gemini多模态消息转换为Parts
+func TestGeminiConvertMultimodalMessage(t *testing.T) {
+	ga, err := NewGeminiAgent(AgentConfig{APIKey: "test"})
+	if err != nil {
+		t.Fatalf("创建agent失败: %v", err)
+	}
+
+	content := ga.convertMessage(multimodalTestMessage)
+	if len(content.Parts) != 6 {
+		t.Fatalf("Parts数量错误: %d", len(content.Parts))
+	}
+	if content.Parts[0].Text != "描述这些内容" {
+		t.Errorf("第一个片段应为Content文本: %+v", content.Parts[0])
+	}
+	if fd := content.Parts[1].FileData; fd == nil || fd.FileURI != "https://example.com/a.png" {
+		t.Errorf("图片URL转换错误: %+v", content.Parts[1])
+	}
+	if blob := content.Parts[3].InlineData; blob == nil || blob.MIMEType != "audio/mpeg" || string(blob.Data) != "mp3" {
+		t.Errorf("内联音频转换错误: %+v", content.Parts[3])
+	}
+	if fd := content.Parts[4].FileData; fd == nil || fd.FileURI != "file-123" || fd.MIMEType != "application/pdf" {
+		t.Errorf("文件引用转换错误: %+v", content.Parts[4])
+	}
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ContextStrategy 历史超出上下文预算时的裁剪策略
+type ContextStrategy string
+
+const (
+	ContextDropOldest ContextStrategy = "drop_oldest" // 从最早的消息开始丢弃，直到不超过预算
+	ContextKeepLastN  ContextStrategy = "keep_last_n" // 只保留系统提示和最近KeepLastN条消息
+	ContextSummarize  ContextStrategy = "summarize"   // 把较早的对话总结为摘要并入系统提示，保留最近KeepLastN条消息
+)
+
+// Summarizer 把一段对话总结为摘要文本
+type Summarizer func(ctx context.Context, messages []ChatMessage) (string, error)
+
+// ContextPolicy 上下文预算策略，在每次对话开始时对传入的历史生效，审批后恢复时沿用暂停前裁剪的结果
+// 系统提示和最后一组消息(本次问题)总是保留，带工具调用的助手消息和对应的工具响应作为整体保留或丢弃
+// 对话循环中产生的工具调用和结果不参与裁剪，单次对话内工具结果较多时使用ToolResultLimit和MaxLoops控制请求大小
+type ContextPolicy struct {
+	MaxPromptTokens int             // 历史的最大估算token数，小于等于0时不裁剪
+	Strategy        ContextStrategy // 裁剪策略，默认drop_oldest
+	KeepLastN       int             // keep_last_n和summarize保留的最近消息数，默认10
+	Summarizer      Summarizer      // summarize策略的总结函数，可使用NewAgentSummarizer
+	Estimator       TokenEstimator  // token估算函数，默认EstimateTokens
+}
+
+// messageGroup 不可拆分的一组消息
+type messageGroup []ChatMessage
+
+// apply 按策略裁剪历史，没有超出预算时原样返回
+func (p *ContextPolicy) apply(ctx context.Context, history []ChatMessage) ([]ChatMessage, error) {
+	if p == nil || p.MaxPromptTokens <= 0 {
+		return history, nil
+	}
+	estimate := p.Estimator
+	if estimate == nil {
+		estimate = EstimateTokens
+	}
+	if estimate(history) <= p.MaxPromptTokens {
+		return history, nil
+	}
+
+	system, groups := groupMessages(history)
+	keepLastN := p.KeepLastN
+	if keepLastN <= 0 {
+		keepLastN = 10
+	}
+
+	switch p.Strategy {
+	case ContextKeepLastN:
+		groups = lastGroups(groups, keepLastN)
+
+	case ContextSummarize:
+		recent := lastGroups(groups, keepLastN)
+		// 保留的消息从用户消息开始，之前的助手消息和工具结果一并总结
+		for len(recent) > 1 && recent[0][0].Role != "user" {
+			recent = recent[1:]
+		}
+		older := flattenGroups(groups[:len(groups)-len(recent)])
+		if len(older) > 0 {
+			if p.Summarizer == nil {
+				return nil, fmt.Errorf("summarize策略未设置Summarizer")
+			}
+			summary, err := p.Summarizer(ctx, older)
+			if err != nil {
+				return nil, fmt.Errorf("总结历史对话错误: %w", err)
+			}
+			// 摘要并入系统提示，避免出现连续两条用户消息
+			system = withSummary(system, summary)
+			groups = recent
+		}
+	}
+
+	// 仍超出预算时从最早的一组开始丢弃，最后一组总是保留
+	for len(groups) > 1 && estimate(append(append([]ChatMessage{}, system...), flattenGroups(groups)...)) > p.MaxPromptTokens {
+		groups = groups[1:]
+	}
+
+	return append(system, flattenGroups(groups)...), nil
+}
+
+// withSummary 把摘要追加到最后一条系统消息，没有系统消息时新增一条
+// Gemini只使用最后一条系统消息，因此不单独新增
+func withSummary(system []ChatMessage, summary string) []ChatMessage {
+	text := "之前对话的摘要：\n" + summary
+	if len(system) == 0 {
+		return []ChatMessage{{Role: "system", Content: text}}
+	}
+	last := &system[len(system)-1]
+	if last.Content != "" {
+		text = last.Content + "\n\n" + text
+	}
+	last.Content = text
+	return system
+}
+
+// groupMessages 分离系统消息，其余消息按不可拆分的组划分
+// 带工具调用的助手消息和紧随其后的工具响应为一组，其他消息各自一组
+func groupMessages(history []ChatMessage) ([]ChatMessage, []messageGroup) {
+	var system []ChatMessage
+	var groups []messageGroup
+	for _, msg := range history {
+		switch {
+		case msg.Role == "system":
+			system = append(system, msg)
+		case msg.Role == "tool" && len(groups) > 0 && groups[len(groups)-1].hasToolCalls():
+			groups[len(groups)-1] = append(groups[len(groups)-1], msg)
+		default:
+			groups = append(groups, messageGroup{msg})
+		}
+	}
+	return system, groups
+}
+
+// hasToolCalls 判断该组是否以带工具调用的助手消息开头
+func (g messageGroup) hasToolCalls() bool {
+	return len(g) > 0 && len(g[0].ToolCalls) > 0
+}
+
+// lastGroups 返回最近不超过n条消息的组，跨越边界的组整组丢弃，至少保留最后一组
+func lastGroups(groups []messageGroup, n int) []messageGroup {
+	count := 0
+	start := len(groups)
+	for start > 0 {
+		size := len(groups[start-1])
+		if count+size > n && start < len(groups) {
+			break
+		}
+		count += size
+		start--
+	}
+	return groups[start:]
+}
+
+// flattenGroups 展开为消息列表
+func flattenGroups(groups []messageGroup) []ChatMessage {
+	var messages []ChatMessage
+	for _, g := range groups {
+		messages = append(messages, g...)
+	}
+	return messages
+}
+
+// NewAgentSummarizer 使用agent总结历史对话，建议使用没有注册工具的agent和便宜的模型
+func NewAgentSummarizer(summaryAgent Agent, modelName string) Summarizer {
+	return func(ctx context.Context, messages []ChatMessage) (string, error) {
+		prompt := []ChatMessage{
+			{Role: "system", Content: "请用简洁的语言总结以下对话，保留用户的目标、已确认的事实、工具调用得到的关键结果和未完成的事项。"},
+			{Role: "user", Content: transcript(messages)},
+		}
+		_, history, err := summaryAgent.StreamRunConversationEvents(ctx, modelName, prompt, nil)
+		if err != nil {
+			return "", err
+		}
+		summary := lastAssistantContent(history)
+		if summary == "" {
+			return "", fmt.Errorf("总结结果为空")
+		}
+		return summary, nil
+	}
+}
+
+// transcript 把对话转换为纯文本记录
+func transcript(messages []ChatMessage) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case "user":
+			fmt.Fprintf(&sb, "用户: %s\n", msg.textContent())
+		case "assistant":
+			if text := msg.textContent(); text != "" {
+				fmt.Fprintf(&sb, "助手: %s\n", text)
+			}
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(&sb, "助手调用工具 %s: %v\n", call.Name, call.Args)
+			}
+		case "tool":
+			for _, resp := range msg.FunctionResponses {
+				fmt.Fprintf(&sb, "工具 %s 返回: %v\n", resp.Name, resp.Result["output"])
+			}
+		}
+	}
+	return sb.String()
+}
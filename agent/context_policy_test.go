@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// contextTestHistory 系统提示 + 普通问答 + 一组工具调用 + 本次问题
+func contextTestHistory() []ChatMessage {
+	return []ChatMessage{
+		{Role: "system", Content: "你是助手"},
+		{Role: "user", Content: "问题1"},
+		{Role: "assistant", Content: "回答1"},
+		{Role: "user", Content: "问题2"},
+		{Role: "assistant", ToolCalls: []FunctionCall{{ID: "c1", Name: "a"}, {ID: "c2", Name: "b"}}},
+		{Role: "tool", FunctionResponses: []FunctionResponse{{ID: "c1", Name: "a"}}},
+		{Role: "tool", FunctionResponses: []FunctionResponse{{ID: "c2", Name: "b"}}},
+		{Role: "assistant", Content: "回答2"},
+		{Role: "user", Content: "问题3"},
+	}
+}
+
+// countEstimator 每条消息计1个token，便于断言
+func countEstimator(messages []ChatMessage) int {
+	return len(messages)
+}
+
+func roles(messages []ChatMessage) string {
+	var rs []string
+	for _, msg := range messages {
+		rs = append(rs, msg.Role)
+	}
+	return strings.Join(rs, ",")
+}
+
+func TestContextPolicyApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *ContextPolicy
+		want   string
+	}{
+		{"未设置", nil, "system,user,assistant,user,assistant,tool,tool,assistant,user"},
+		{"未超出预算", &ContextPolicy{MaxPromptTokens: 9, Estimator: countEstimator}, "system,user,assistant,user,assistant,tool,tool,assistant,user"},
+		// 丢弃到只剩4条时会拆开工具调用组，因此整组丢弃
+		{"丢弃最早", &ContextPolicy{MaxPromptTokens: 4, Estimator: countEstimator}, "system,assistant,user"},
+		{"保留最后一组", &ContextPolicy{MaxPromptTokens: 1, Estimator: countEstimator}, "system,user"},
+		// 最近4条落在工具调用组中间，整组丢弃
+		{"保留最近N条", &ContextPolicy{MaxPromptTokens: 8, Strategy: ContextKeepLastN, KeepLastN: 4, Estimator: countEstimator}, "system,assistant,user"},
+		{"保留最近N条包含整组", &ContextPolicy{MaxPromptTokens: 8, Strategy: ContextKeepLastN, KeepLastN: 5, Estimator: countEstimator}, "system,assistant,tool,tool,assistant,user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.apply(context.Background(), contextTestHistory())
+			if err != nil {
+				t.Fatalf("裁剪失败: %v", err)
+			}
+			if roles(got) != tt.want {
+				t.Errorf("裁剪结果错误: got %s, want %s", roles(got), tt.want)
+			}
+		})
+	}
+}
+
+// 测试总结策略把较早的对话总结后并入系统提示
+func TestContextPolicySummarize(t *testing.T) {
+	var summarized []ChatMessage
+	policy := &ContextPolicy{
+		MaxPromptTokens: 8,
+		Strategy:        ContextSummarize,
+		KeepLastN:       6,
+		Estimator:       countEstimator,
+		Summarizer: func(ctx context.Context, messages []ChatMessage) (string, error) {
+			summarized = messages
+			return "用户问了两个问题", nil
+		},
+	}
+
+	history := contextTestHistory()
+	got, err := policy.apply(context.Background(), history)
+	if err != nil {
+		t.Fatalf("裁剪失败: %v", err)
+	}
+	// 保留的消息以用户消息开始，不会出现连续两条用户消息
+	if roles(got) != "system,user,assistant,tool,tool,assistant,user" {
+		t.Fatalf("裁剪结果错误: %s", roles(got))
+	}
+	if !strings.HasPrefix(got[0].Content, "你是助手") || !strings.Contains(got[0].Content, "用户问了两个问题") || len(summarized) != 2 {
+		t.Errorf("摘要错误: %+v, 被总结的消息数 %d", got[0], len(summarized))
+	}
+	if history[0].Content != "你是助手" {
+		t.Errorf("不应修改传入的历史: %+v", history[0])
+	}
+
+	// 最近的消息以助手消息开始时一并总结
+	policy.KeepLastN = 2
+	if got, _ = policy.apply(context.Background(), history); roles(got) != "system,user" || len(summarized) != 7 {
+		t.Errorf("裁剪结果错误: %s, 被总结的消息数 %d", roles(got), len(summarized))
+	}
+
+	// 没有系统提示时新增一条
+	policy.MaxPromptTokens = 4
+	if got, _ = policy.apply(context.Background(), history[1:]); roles(got) != "system,user" || !strings.HasPrefix(got[0].Content, "之前对话的摘要") {
+		t.Errorf("裁剪结果错误: %+v", got)
+	}
+
+	policy.Summarizer = nil
+	if _, err := policy.apply(context.Background(), contextTestHistory()); err == nil {
+		t.Error("未设置Summarizer应返回错误")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if got := EstimateTextTokens("hello world!"); got != 3 {
+		t.Errorf("英文估算错误: %d", got)
+	}
+	if got := EstimateTextTokens("你好世界"); got != 4 {
+		t.Errorf("中文估算错误: %d", got)
+	}
+
+	messages := []ChatMessage{{Role: "user", Content: "你好", Parts: []ContentPart{NewImageURLPart("https://example.com/a.png", "image/png")}}}
+	if got, want := EstimateTokens(messages), messageOverheadTokens+2+mediaPartTokens; got != want {
+		t.Errorf("消息估算错误: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// agent返回的错误类型，可通过errors.Is判断，需要详细信息时通过errors.As取对应的结构体
+var (
+	ErrMaxLoopsExceeded      = errors.New("对话循环次数超过最大限制") // MaxLoopsError
+	ErrRateLimited           = errors.New("供应商频率限制")      // ProviderError
+	ErrAuthFailed            = errors.New("供应商认证失败")      // ProviderError
+	ErrContentBlocked        = errors.New("内容被安全策略拦截")    // ContentBlockedError或ProviderError
+	ErrContextLengthExceeded = errors.New("超出模型上下文长度")    // ProviderError
+	ErrToolNotFound          = errors.New("未找到工具")        // ToolNotFoundError
+	ErrInvalidToolArgs       = errors.New("工具参数不合法")      // InvalidToolArgsError
+	ErrStreamInterrupted     = errors.New("流式响应中断")       // StreamInterruptedError
+	errNoResponse            = errors.New("没有收到回复")
+)
+
+// MaxLoopsError 对话循环次数超过AgentConfig.MaxLoops
+type MaxLoopsError struct {
+	MaxLoops int // 最大循环次数
+}
+
+func (e *MaxLoopsError) Error() string {
+	return fmt.Sprintf("%v(%d)，可能存在递归", ErrMaxLoopsExceeded, e.MaxLoops)
+}
+
+func (e *MaxLoopsError) Is(target error) bool {
+	return target == ErrMaxLoopsExceeded
+}
+
+// ProviderError 供应商API返回的错误，Kind为上面的错误类型之一，无法归类时为空
+// 原始的SDK错误(*openai.Error、genai.APIError、*AnthropicError)可通过errors.As获取
+type ProviderError struct {
+	Kind       error  // 错误类型
+	StatusCode int    // HTTP状态码
+	Code       string // 供应商的错误码，如context_length_exceeded、RESOURCE_EXHAUSTED
+	Message    string // 供应商的错误信息
+	Err        error  // 原始错误
+}
+
+func (e *ProviderError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("%v(%d): %v", e.Kind, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("供应商请求错误(%d): %v", e.StatusCode, e.Err)
+}
+
+func (e *ProviderError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ContentBlockedError 模型的提示词或回答被供应商的安全策略拦截
+type ContentBlockedError struct {
+	Reason  string // 拦截原因，如content_filter、SAFETY、PROHIBITED_CONTENT
+	Message string // 供应商给出的说明，可能为空
+	Loop    int    // 被拦截时的循环次数
+}
+
+func (e *ContentBlockedError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%v: %s，%s", ErrContentBlocked, e.Reason, e.Message)
+	}
+	return fmt.Sprintf("%v: %s", ErrContentBlocked, e.Reason)
+}
+
+func (e *ContentBlockedError) Is(target error) bool {
+	return target == ErrContentBlocked
+}
+
+// ToolNotFoundError 模型调用了未注册的工具
+type ToolNotFoundError struct {
+	Name   string // 工具名称
+	CallID string // 工具调用ID
+}
+
+func (e *ToolNotFoundError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrToolNotFound, e.Name)
+}
+
+func (e *ToolNotFoundError) Is(target error) bool {
+	return target == ErrToolNotFound
+}
+
+// InvalidToolArgsError 模型给出的工具参数不是合法JSON或不符合参数定义
+type InvalidToolArgsError struct {
+	Name   string // 工具名称
+	CallID string // 工具调用ID
+	Err    error  // 具体原因
+}
+
+func (e *InvalidToolArgsError) Error() string {
+	return fmt.Sprintf("%v(%s): %v", ErrInvalidToolArgs, e.Name, e.Err)
+}
+
+func (e *InvalidToolArgsError) Is(target error) bool {
+	return target == ErrInvalidToolArgs
+}
+
+func (e *InvalidToolArgsError) Unwrap() error {
+	return e.Err
+}
+
+// StreamInterruptedError 流式响应在完成前中断，如连接重置、意外断流或没有收到任何回复
+type StreamInterruptedError struct {
+	Loop int   // 中断时的循环次数
+	Err  error // 原始错误
+}
+
+func (e *StreamInterruptedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrStreamInterrupted, e.Err)
+}
+
+func (e *StreamInterruptedError) Is(target error) bool {
+	return target == ErrStreamInterrupted
+}
+
+func (e *StreamInterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError 把一轮请求的错误转换为上面的错误类型，无法归类时原样返回
+func classifyError(err error, loop int) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if providerErr := newProviderError(err); providerErr != nil {
+		return providerErr
+	}
+	if isNetworkError(err) || errors.Is(err, errNoResponse) {
+		return &StreamInterruptedError{Loop: loop, Err: err}
+	}
+	return err
+}
+
+// newProviderError 从openai-go和genai的错误生成ProviderError，不是供应商错误时返回nil
+func newProviderError(err error) *ProviderError {
+	providerErr := &ProviderError{Err: err}
+
+	var openaiErr *openai.Error
+	var geminiErr genai.APIError
+	var geminiErrPtr *genai.APIError
+	var anthropicErr *AnthropicError
+	switch {
+	case errors.As(err, &openaiErr):
+		providerErr.StatusCode = openaiErr.StatusCode
+		providerErr.Code = openaiErr.Code
+		if providerErr.Code == "" {
+			providerErr.Code = openaiErr.Type
+		}
+		providerErr.Message = openaiErr.Message
+	case errors.As(err, &geminiErr):
+		providerErr.StatusCode = geminiErr.Code
+		providerErr.Code = geminiErr.Status
+		providerErr.Message = geminiErr.Message
+	case errors.As(err, &geminiErrPtr) && geminiErrPtr != nil:
+		providerErr.StatusCode = geminiErrPtr.Code
+		providerErr.Code = geminiErrPtr.Status
+		providerErr.Message = geminiErrPtr.Message
+	case errors.As(err, &anthropicErr):
+		providerErr.StatusCode = anthropicErr.StatusCode
+		providerErr.Code = anthropicErr.Type
+		providerErr.Message = anthropicErr.Message
+	default:
+		return nil
+	}
+
+	providerErr.Kind = providerErrorKind(providerErr.StatusCode, providerErr.Code, providerErr.Message)
+	return providerErr
+}
+
+// providerErrorKind 按状态码、错误码和错误信息归类供应商错误
+func providerErrorKind(statusCode int, code, message string) error {
+	code = strings.ToLower(code)
+	message = strings.ToLower(message)
+	switch {
+	case statusCode == 429 || code == "resource_exhausted" || code == "rate_limit_exceeded" || code == "rate_limit_error":
+		return ErrRateLimited
+	case statusCode == 401 || statusCode == 403 || code == "unauthenticated" || code == "permission_denied" || code == "invalid_api_key" ||
+		code == "authentication_error" || code == "permission_error":
+		return ErrAuthFailed
+	case code == "context_length_exceeded" || code == "string_above_max_length" ||
+		strings.Contains(message, "context length") || strings.Contains(message, "context window") ||
+		strings.Contains(message, "maximum number of tokens") || strings.Contains(message, "too many tokens") || strings.Contains(message, "prompt is too long"):
+		return ErrContextLengthExceeded
+	case code == "content_filter" || code == "content_policy_violation" ||
+		strings.Contains(message, "content management policy"):
+		return ErrContentBlocked
+	}
+	return nil
+}
+
+// 表示回答被安全策略拦截的结束原因
+var blockedFinishReasons = map[string]bool{
+	"content_filter":                            true, // OpenAI
+	"refusal":                                   true, // Anthropic
+	string(genai.FinishReasonSafety):            true,
+	string(genai.FinishReasonRecitation):        true,
+	string(genai.FinishReasonBlocklist):         true,
+	string(genai.FinishReasonProhibitedContent): true,
+	string(genai.FinishReasonSPII):              true,
+	string(genai.FinishReasonImageSafety):       true,
+}
+
+// contentBlocked 结束原因或拦截原因表示内容被拦截时返回ContentBlockedError
+func contentBlocked(reason, message string, loop int, promptBlocked bool) error {
+	if reason == "" || (!promptBlocked && !blockedFinishReasons[reason]) {
+		return nil
+	}
+	return &ContentBlockedError{Reason: reason, Message: message, Loop: loop}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"openai 429", &openai.Error{StatusCode: 429}, ErrRateLimited},
+		{"openai 401", &openai.Error{StatusCode: 401, Code: "invalid_api_key"}, ErrAuthFailed},
+		{"openai 上下文", &openai.Error{StatusCode: 400, Code: "context_length_exceeded"}, ErrContextLengthExceeded},
+		{"openai 内容过滤", &openai.Error{StatusCode: 400, Code: "content_filter"}, ErrContentBlocked},
+		{"gemini 频率限制", genai.APIError{Code: 429, Status: "RESOURCE_EXHAUSTED"}, ErrRateLimited},
+		{"gemini 认证", &genai.APIError{Code: 403, Status: "PERMISSION_DENIED"}, ErrAuthFailed},
+		{"gemini 上下文", genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "The input token count exceeds the maximum number of tokens allowed"}, ErrContextLengthExceeded},
+		{"anthropic 频率限制", &AnthropicError{StatusCode: 429, Type: "rate_limit_error"}, ErrRateLimited},
+		{"anthropic 上下文", &AnthropicError{StatusCode: 400, Type: "invalid_request_error", Message: "prompt is too long: 210000 tokens > 200000 maximum"}, ErrContextLengthExceeded},
+		{"断流", io.ErrUnexpectedEOF, ErrStreamInterrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(fmt.Errorf("流处理错误: %w", tt.err), 1)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("归类错误: %v", err)
+			}
+			// 仍可取到原始错误和状态码
+			if !errors.Is(err, tt.err) && !errors.As(err, new(*openai.Error)) && !errors.As(err, new(genai.APIError)) && !errors.As(err, new(*genai.APIError)) {
+				t.Errorf("原始错误丢失: %v", err)
+			}
+		})
+	}
+
+	// 无法归类的供应商错误保留状态码
+	var providerErr *ProviderError
+	err := classifyError(&openai.Error{StatusCode: 500}, 1)
+	if !errors.As(err, &providerErr) || providerErr.Kind != nil || statusCodeOf(err) != 500 {
+		t.Errorf("未归类的供应商错误处理错误: %v", err)
+	}
+
+	// 取消和普通错误原样返回
+	if err := classifyError(context.Canceled, 1); err != context.Canceled {
+		t.Errorf("取消不应被转换: %v", err)
+	}
+	plain := errors.New("其他错误")
+	if err := classifyError(plain, 1); err != plain {
+		t.Errorf("普通错误不应被转换: %v", err)
+	}
+}
+
+func TestContentBlocked(t *testing.T) {
+	if err := contentBlocked("stop", "", 1, false); err != nil {
+		t.Errorf("正常结束不应拦截: %v", err)
+	}
+	var blockedErr *ContentBlockedError
+	if err := contentBlocked("content_filter", "", 2, false); !errors.As(err, &blockedErr) || blockedErr.Loop != 2 {
+		t.Errorf("内容过滤应拦截: %v", err)
+	}
+	if err := contentBlocked("refusal", "", 1, false); !errors.Is(err, ErrContentBlocked) {
+		t.Errorf("Anthropic拒绝回答应拦截: %v", err)
+	}
+	if err := contentBlocked("OTHER", "不支持", 1, true); !errors.Is(err, ErrContentBlocked) {
+		t.Errorf("提示词被拦截应返回错误: %v", err)
+	}
+	if err := (&MaxLoopsError{MaxLoops: 5}); !errors.Is(err, ErrMaxLoopsExceeded) {
+		t.Error("循环次数错误类型错误")
+	}
+}
@@ -0,0 +1,73 @@
+package agent
+
+import "strings"
+
+// StreamEventType 结构化流式事件类型
+type StreamEventType string
+
+const (
+	EventTextDelta         StreamEventType = "text_delta"           // 回答文本增量
+	EventThinkingDelta     StreamEventType = "thinking_delta"       // 思考内容增量
+	EventToolCallStarted   StreamEventType = "tool_call_started"    // 模型开始调用工具
+	EventToolCallArgsDelta StreamEventType = "tool_call_args_delta" // 工具调用参数增量(JSON片段)
+	EventToolResult        StreamEventType = "tool_result"          // 工具执行完成
+	EventLoopIteration     StreamEventType = "loop_iteration"       // 新一轮对话循环开始
+	EventUsage             StreamEventType = "usage"                // token使用统计
+	EventFinishReason      StreamEventType = "finish_reason"        // 本轮模型输出结束原因
+	EventRetry             StreamEventType = "retry"                // 本轮请求失败即将重试，之前推送的本轮增量应丢弃
+	EventFallback          StreamEventType = "fallback"             // 当前供应商失败，切换到备用供应商
+	EventApprovalRequired  StreamEventType = "approval_required"    // 工具调用需要审批，对话暂停
+	EventError             StreamEventType = "error"                // 对话出错
+)
+
+// StreamEvent 结构化流式事件，根据Type只填充对应字段
+type StreamEvent struct {
+	Type         StreamEventType   `json:"type"`                    //事件类型
+	Loop         int               `json:"loop"`                    //当前循环次数，从1开始
+	Text         string            `json:"text,omitempty"`          //文本或思考增量
+	ToolCall     *FunctionCall     `json:"tool_call,omitempty"`     //工具调用(开始事件和参数增量事件)
+	ArgsDelta    string            `json:"args_delta,omitempty"`    //工具参数增量
+	ToolResult   *FunctionResponse `json:"tool_result,omitempty"`   //工具执行结果
+	Usage        *TokenUsage       `json:"usage,omitempty"`         //截止当前的累计token统计
+	FinishReason string            `json:"finish_reason,omitempty"` //结束原因，保留供应商原始值
+	Attempt      int               `json:"attempt,omitempty"`       //重试事件的重试次数，从1开始
+	Fallback     *FallbackTarget   `json:"fallback,omitempty"`      //降级事件切换到的目标
+	Approval     *PendingApproval  `json:"approval,omitempty"`      //审批事件的待审批状态
+	Err          error             `json:"-"`                       //错误
+}
+
+// StreamEventHandler 结构化流式事件回调函数定义
+type StreamEventHandler func(event StreamEvent)
+
+// emit 发送事件，handler为空时忽略
+func (h StreamEventHandler) emit(event StreamEvent) {
+	if h != nil {
+		h(event)
+	}
+}
+
+// EventHandler 把纯文本回调适配为结构化事件回调，只转发回答文本增量
+// 纯文本回调无法撤回已输出的内容，本轮重试时跳过已输出过的部分，只转发超出的文本
+func (h StreamHandler) EventHandler() StreamEventHandler {
+	if h == nil {
+		return nil
+	}
+	var sent, attempt strings.Builder // 本轮已输出的文本和当前尝试收到的文本
+	return func(event StreamEvent) {
+		switch event.Type {
+		case EventLoopIteration:
+			sent.Reset()
+			attempt.Reset()
+		case EventRetry:
+			attempt.Reset()
+		case EventTextDelta:
+			attempt.WriteString(event.Text)
+			if attempt.Len() <= sent.Len() {
+				return
+			}
+			text := attempt.String()[sent.Len():]
+			sent.WriteString(text)
+			h(text)
+		}
+	}
+}
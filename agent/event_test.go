@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+// 测试纯文本回调在重试时不重复输出已输出的文本
+func TestStreamHandlerRetry(t *testing.T) {
+	var out strings.Builder
+	handler := StreamHandler(func(text string) { out.WriteString(text) }).EventHandler()
+
+	events := []StreamEvent{
+		{Type: EventLoopIteration, Loop: 1},
+		{Type: EventThinkingDelta, Text: "思考"},
+		{Type: EventTextDelta, Text: "你好，"},
+		{Type: EventTextDelta, Text: "世"},
+		{Type: EventRetry, Attempt: 1},
+		{Type: EventTextDelta, Text: "你好"},
+		{Type: EventTextDelta, Text: "，世界"},
+		{Type: EventRetry, Attempt: 2},
+		{Type: EventTextDelta, Text: "你好，世界！"},
+		{Type: EventLoopIteration, Loop: 2},
+		{Type: EventTextDelta, Text: "你好"},
+	}
+	for _, event := range events {
+		handler(event)
+	}
+	if got := out.String(); got != "你好，世界！你好" {
+		t.Errorf("输出错误: %q", got)
+	}
+
+	if StreamHandler(nil).EventHandler() != nil {
+		t.Error("空回调应返回nil")
+	}
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"context"
+	"errors"
+)
+
+// FallbackErrorKind 触发降级的错误类型
+type FallbackErrorKind string
+
+const (
+	FallbackOnRateLimit    FallbackErrorKind = "rate_limit"    // 频率限制(429)
+	FallbackOnServerError  FallbackErrorKind = "server_error"  // 服务端错误(5xx)
+	FallbackOnNetworkError FallbackErrorKind = "network_error" // 连接失败、连接重置、意外断流
+	FallbackOnAnyError     FallbackErrorKind = "any"           // 除取消外的任意错误
+)
+
+// 默认触发降级的错误类型
+var defaultFallbackErrorKinds = []FallbackErrorKind{FallbackOnRateLimit, FallbackOnServerError, FallbackOnNetworkError}
+
+// FallbackTarget 降级目标
+type FallbackTarget struct {
+	AgentName AgentName `json:"agent_name"`           // agent名称
+	ModelName string    `json:"model_name,omitempty"` // 模型名称，为空时使用该agent的默认模型
+}
+
+// FallbackPolicy 供应商降级策略，主agent失败后按顺序尝试备用目标
+type FallbackPolicy struct {
+	Targets    []FallbackTarget    // 按顺序尝试的备用agent和模型
+	ErrorKinds []FallbackErrorKind // 触发降级的错误类型，为空时为频率限制、服务端错误和网络错误
+}
+
+// FallbackAttempt 一次失败的尝试
+type FallbackAttempt struct {
+	AgentName AgentName // agent名称
+	ModelName string    // 模型名称
+	Err       error     // 失败原因
+}
+
+// ConversationResult 对话结果，记录实际回答的agent和模型
+type ConversationResult struct {
+	Usage     *TokenUsage       // 所有尝试累计的token使用统计
+	Cost      *CostBreakdown    // 所有尝试累计的费用，未设置价格表时为空
+	History   []ChatMessage     // 本次对话历史
+	AgentName AgentName         // 实际回答的agent
+	ModelName string            // 实际回答使用的模型
+	Failures  []FallbackAttempt // 降级前失败的尝试
+}
+
+// shouldFallback 判断错误是否触发降级
+func (p *FallbackPolicy) shouldFallback(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// 审批暂停不是失败，需要在原agent上恢复
+	var approvalErr *ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		return false
+	}
+	// 超出预算换供应商只会继续消耗
+	if errors.Is(err, ErrBudgetExceeded) {
+		return false
+	}
+
+	kinds := p.ErrorKinds
+	if len(kinds) == 0 {
+		kinds = defaultFallbackErrorKinds
+	}
+	code := statusCodeOf(err)
+	for _, kind := range kinds {
+		switch kind {
+		case FallbackOnAnyError:
+			return true
+		case FallbackOnRateLimit:
+			if code == 429 {
+				return true
+			}
+		case FallbackOnServerError:
+			if code >= 500 {
+				return true
+			}
+		case FallbackOnNetworkError:
+			if code == 0 && isNetworkError(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetFallbackPolicy 设置降级策略，为空时关闭降级
+func (s *AgentService) SetFallbackPolicy(policy *FallbackPolicy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fallback = policy
+}
+
+// StreamRunConversationWithFallback 按降级策略执行对话，返回实际回答的agent和模型
+// 失败前已完成的轮次(包括已执行的工具结果)会接到备用agent的请求历史中，工具不会重复执行
+func (s *AgentService) StreamRunConversationWithFallback(
+	ctx context.Context, //上下文
+	agentName AgentName, //主agent名称
+	modelName string, //主模型名称
+	history []ChatMessage, //如果要保存系统指令和user提示词直接在history中添加
+	handler StreamEventHandler, //结构化流式事件回调
+) (*ConversationResult, error) {
+	s.lock.RLock()
+	policy := s.fallback
+	s.lock.RUnlock()
+
+	targets := []FallbackTarget{{AgentName: agentName, ModelName: modelName}}
+	if policy != nil {
+		targets = append(targets, policy.Targets...)
+	}
+
+	result := &ConversationResult{Usage: &TokenUsage{}}
+	messages := history
+	var completed []ChatMessage // 失败前已完成的对话
+
+	for i, target := range targets {
+		agent, err := s.GetAgent(target.AgentName)
+		if err != nil {
+			return result, err
+		}
+
+		usage, partial, err := agent.StreamRunConversationEvents(ctx, target.ModelName, messages, handler)
+		result.Usage.Add(usage)
+		// 未指定模型时记录agent实际使用的默认模型
+		modelName := resolveModelName(agent, target.ModelName)
+		if cost := s.recordUsage(target.AgentName, modelName, usage); cost != nil {
+			if result.Cost == nil {
+				result.Cost = &CostBreakdown{}
+			}
+			result.Cost.Add(cost)
+		}
+		result.AgentName = target.AgentName
+		result.ModelName = modelName
+		result.History = append(append([]ChatMessage{}, completed...), partial...)
+		if err == nil {
+			return result, nil
+		}
+
+		result.Failures = append(result.Failures, FallbackAttempt{AgentName: target.AgentName, ModelName: modelName, Err: err})
+		if i == len(targets)-1 || !policy.shouldFallback(err) {
+			return result, err
+		}
+
+		// 把已完成的轮次接到请求历史后面，备用agent从失败的那一轮继续
+		progressed := partial
+		if len(partial) > 0 && partial[0].Role == "user" {
+			progressed = partial[1:]
+		}
+		if len(progressed) > 0 {
+			if len(completed) == 0 && len(partial) > len(progressed) {
+				completed = append(completed, partial[0])
+			}
+			completed = append(completed, progressed...)
+			messages = append(append([]ChatMessage{}, messages...), progressed...)
+		}
+
+		next := targets[i+1]
+		handler.emit(StreamEvent{Type: EventFallback, Fallback: &next, Err: err})
+	}
+	return result, nil
+}
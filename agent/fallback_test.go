@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+// fallbackTestAgent 测试用agent，按顺序返回预设结果并记录收到的模型和历史
+type fallbackTestAgent struct {
+	sessionTestAgent
+	defaultModel string
+	results      []fallbackTestResult
+	models       []string
+	histories    [][]ChatMessage
+}
+
+// fallbackTestResult 预设的一次对话结果，partial为本次问题之后产生的消息
+type fallbackTestResult struct {
+	partial []ChatMessage
+	err     error
+}
+
+func (a *fallbackTestAgent) StreamRunConversationEvents(ctx context.Context, modelName string, history []ChatMessage, handler StreamEventHandler) (*TokenUsage, []ChatMessage, error) {
+	a.models = append(a.models, modelName)
+	a.histories = append(a.histories, history)
+	result := a.results[0]
+	a.results = a.results[1:]
+	// 与真实agent一致，最后一条为用户消息时才放入返回的历史
+	var conversation []ChatMessage
+	if last := history[len(history)-1]; last.Role == "user" {
+		conversation = append(conversation, last)
+	}
+	return &TokenUsage{TotalTokens: 1}, append(conversation, result.partial...), result.err
+}
+
+func (a *fallbackTestAgent) DefaultModelName() string { return a.defaultModel }
+
+// 测试按顺序降级，备用agent从失败的那一轮继续，结果记录实际回答的agent和默认模型
+func TestStreamRunConversationWithFallback(t *testing.T) {
+	ctx := context.Background()
+	toolCall := ChatMessage{Role: "assistant", ToolCalls: []FunctionCall{{ID: "call_1", Name: "search"}}}
+	toolResult := ChatMessage{Role: "tool", FunctionResponses: []FunctionResponse{{ID: "call_1", Name: "search"}}}
+	primary := &fallbackTestAgent{results: []fallbackTestResult{
+		{partial: []ChatMessage{toolCall, toolResult}, err: &AnthropicError{StatusCode: 429}},
+	}}
+	second := &fallbackTestAgent{results: []fallbackTestResult{{err: &AnthropicError{StatusCode: 503}}}}
+	third := &fallbackTestAgent{defaultModel: "claude-default", results: []fallbackTestResult{
+		{partial: []ChatMessage{{Role: "assistant", Content: "搜索完成"}}},
+	}}
+
+	service := NewAgentService(ctx)
+	service.RegisterAgent(OpenAI, primary)
+	service.RegisterAgent(Gemini, second)
+	service.RegisterAgent(Anthropic, third)
+	service.SetFallbackPolicy(&FallbackPolicy{Targets: []FallbackTarget{
+		{AgentName: Gemini, ModelName: "gemini-pro"},
+		{AgentName: Anthropic},
+	}})
+
+	var fallbacks []FallbackTarget
+	history := []ChatMessage{{Role: "system", Content: "你是助手"}, {Role: "user", Content: "搜索"}}
+	result, err := service.StreamRunConversationWithFallback(ctx, OpenAI, "gpt", history, func(event StreamEvent) {
+		if event.Type == EventFallback {
+			fallbacks = append(fallbacks, *event.Fallback)
+		}
+	})
+	if err != nil {
+		t.Fatalf("降级后应成功: %v", err)
+	}
+
+	if len(fallbacks) != 2 || fallbacks[0].AgentName != Gemini || fallbacks[1].AgentName != Anthropic {
+		t.Errorf("降级事件顺序错误: %+v", fallbacks)
+	}
+	if result.AgentName != Anthropic || result.ModelName != "claude-default" {
+		t.Errorf("实际回答的agent和模型错误: %s, %q", result.AgentName, result.ModelName)
+	}
+	if result.Usage.TotalTokens != 3 {
+		t.Errorf("应累计所有尝试的token: %+v", result.Usage)
+	}
+	if len(result.Failures) != 2 ||
+		result.Failures[0].AgentName != OpenAI || result.Failures[0].ModelName != "gpt" || statusCodeOf(result.Failures[0].Err) != 429 ||
+		result.Failures[1].AgentName != Gemini || result.Failures[1].ModelName != "gemini-pro" || statusCodeOf(result.Failures[1].Err) != 503 {
+		t.Errorf("失败记录错误: %+v", result.Failures)
+	}
+
+	// 备用agent收到已完成的工具调用和结果，不重复执行
+	if second.models[0] != "gemini-pro" || len(second.histories[0]) != 4 || third.models[0] != "" || len(third.histories[0]) != 4 {
+		t.Errorf("备用agent收到的请求错误: %v %+v, %v %+v", second.models, second.histories, third.models, third.histories)
+	}
+	if len(result.History) != 4 || result.History[0].Role != "user" || len(result.History[1].ToolCalls) != 1 || result.History[3].Content != "搜索完成" {
+		t.Errorf("对话历史错误: %+v", result.History)
+	}
+	if total := service.UsageTotalFor(Anthropic, "claude-default"); total.Requests != 1 {
+		t.Errorf("使用统计应按默认模型记录: %+v", total)
+	}
+}
+
+// 测试不触发降级的错误直接返回，不尝试备用agent
+func TestStreamRunConversationWithFallbackIneligible(t *testing.T) {
+	ctx := context.Background()
+	primary := &fallbackTestAgent{defaultModel: "gpt-default", results: []fallbackTestResult{{err: &AnthropicError{StatusCode: 400}}}}
+	backup := &fallbackTestAgent{}
+
+	service := NewAgentService(ctx)
+	service.RegisterAgent(OpenAI, primary)
+	service.RegisterAgent(Gemini, backup)
+	service.SetFallbackPolicy(&FallbackPolicy{Targets: []FallbackTarget{{AgentName: Gemini}}})
+
+	result, err := service.StreamRunConversationWithFallback(ctx, OpenAI, "", []ChatMessage{{Role: "user", Content: "你好"}}, nil)
+	if statusCodeOf(err) != 400 {
+		t.Fatalf("应返回原始错误: %v", err)
+	}
+	if len(backup.models) != 0 {
+		t.Error("请求参数错误不应降级")
+	}
+	if result.AgentName != OpenAI || result.ModelName != "gpt-default" || len(result.Failures) != 1 || result.Failures[0].ModelName != "gpt-default" {
+		t.Errorf("结果错误: %+v", result)
+	}
+}
+
+func TestShouldFallback(t *testing.T) {
+	tests := []struct {
+		name  string
+		kinds []FallbackErrorKind
+		err   error
+		want  bool
+	}{
+		{"频率限制", nil, &AnthropicError{StatusCode: 429}, true},
+		{"服务端错误", nil, &AnthropicError{StatusCode: 529}, true},
+		{"连接重置", nil, fmt.Errorf("读取响应: %w", syscall.ECONNRESET), true},
+		{"请求参数错误", nil, &AnthropicError{StatusCode: 400}, false},
+		{"未知错误", nil, errors.New("解析失败"), false},
+		{"取消", nil, context.Canceled, false},
+		{"超时", []FallbackErrorKind{FallbackOnAnyError}, context.DeadlineExceeded, false},
+		{"审批暂停", []FallbackErrorKind{FallbackOnAnyError}, &ApprovalRequiredError{Pending: &PendingApproval{}}, false},
+		{"任意错误", []FallbackErrorKind{FallbackOnAnyError}, &AnthropicError{StatusCode: 400}, true},
+		{"只降级频率限制", []FallbackErrorKind{FallbackOnRateLimit}, &AnthropicError{StatusCode: 500}, false},
+		{"只降级网络错误", []FallbackErrorKind{FallbackOnNetworkError}, syscall.ECONNREFUSED, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &FallbackPolicy{ErrorKinds: tt.kinds}
+			if got := policy.shouldFallback(tt.err); got != tt.want {
+				t.Errorf("shouldFallback(%v) = %v, 期望 %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
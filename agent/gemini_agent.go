@@ -4,22 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"google.golang.org/genai"
 )
 
 // GeminiAgent 实现Agent接口的Gemini代理
 type GeminiAgent struct {
-	client     *genai.Client
-	config     AgentConfig
-	tools      map[string]Tool
-	toolParams []*genai.Tool
+	client  *genai.Client
+	config  AgentConfig
+	tools   map[string]Tool
+	toolsMu sync.RWMutex // 保护tools，对话开始时复制本次可用的工具
+
+	telemetry *telemetry   // OpenTelemetry追踪和指标，未配置时为空
+	logger    *slog.Logger // 结构化日志
 }
 
 // NewGeminiAgent 创建一个新的Gemini代理
 func NewGeminiAgent(config AgentConfig) (*GeminiAgent, error) {
+	logger := newLogger(config, "gemini")
+
 	// 创建HTTP客户端
 	httpClient := &http.Client{}
 
@@ -39,12 +47,22 @@ func NewGeminiAgent(config AgentConfig) (*GeminiAgent, error) {
 		}
 	}
 
+	// 设置了Logger时记录HTTP流量，是否输出由LevelTrace决定
+	if config.Logger != nil {
+		httpClient = loggingClient(httpClient, logger)
+	}
+
 	// 创建Gemini客户端配置
 	clientConfig := &genai.ClientConfig{
 		APIKey:     config.APIKey,
 		HTTPClient: httpClient,
 	}
 
+	// 如果设置了自定义URL
+	if config.BaseURL != "" {
+		clientConfig.HTTPOptions = genai.HTTPOptions{BaseURL: config.BaseURL}
+	}
+
 	// 创建Gemini客户端
 	client, err := genai.NewClient(context.Background(), clientConfig)
 	if err != nil {
@@ -63,10 +81,12 @@ func NewGeminiAgent(config AgentConfig) (*GeminiAgent, error) {
 	}
 
 	return &GeminiAgent{
-		client:     client,
-		config:     config,
-		tools:      make(map[string]Tool),
-		toolParams: []*genai.Tool{},
+		client: client,
+		config: config,
+		tools:  make(map[string]Tool),
+
+		telemetry: newTelemetry(config.Telemetry, "gemini"),
+		logger:    logger,
 	}, nil
 }
 
@@ -77,22 +97,74 @@ func (ga *GeminiAgent) StreamRunConversation(
 	history []ChatMessage,
 	handler StreamHandler,
 ) (*TokenUsage, []ChatMessage, error) {
+	return ga.StreamRunConversationEvents(ctx, modelName, history, handler.EventHandler())
+}
+
+// StreamRunConversationEvents 实现Agent接口的结构化事件流式对话方法
+func (ga *GeminiAgent) StreamRunConversationEvents(
+	ctx context.Context,
+	modelName string,
+	history []ChatMessage,
+	handler StreamEventHandler,
+) (*TokenUsage, []ChatMessage, error) {
+	return ga.runConversation(ctx, modelName, history, handler, nil, nil)
+}
+
+// ResumeConversation 实现Agent接口的审批恢复方法，先按决定执行暂停时的工具调用，再继续对话循环
+func (ga *GeminiAgent) ResumeConversation(
+	ctx context.Context,
+	pending *PendingApproval,
+	decisions []ApprovalDecision,
+	handler StreamEventHandler,
+) (*TokenUsage, []ChatMessage, error) {
+	if pending == nil {
+		return nil, nil, fmt.Errorf("待审批状态为空")
+	}
+	return ga.runConversation(ctx, pending.ModelName, pending.History, handler, pending, approvalDecisionMap(decisions))
+}
+
+// runConversation 对话循环，resume不为空时从审批暂停处继续
+func (ga *GeminiAgent) runConversation(
+	ctx context.Context,
+	modelName string,
+	history []ChatMessage,
+	handler StreamEventHandler,
+	resume *PendingApproval,
+	decisions map[string]ApprovalDecision,
+) (_ *TokenUsage, _ []ChatMessage, err error) {
 
 	if modelName == "" {
-		modelName = ga.config.ModelName
-		if modelName == "" {
-			modelName = "gemini-2.0-flash"
-		}
+		modelName = ga.DefaultModelName()
 	}
 
-	// 打包工具参数
-	ga.rebuildToolParams()
+	// 本次请求可用的工具，打包工具参数
+	ga.toolsMu.RLock()
+	tools := selectTools(ctx, ga.tools)
+	ga.toolsMu.RUnlock()
+	toolParams := ga.buildToolParams(tools)
+
+	// 按上下文预算裁剪历史，工具调用和对应的响应不会被拆开
+	// 暂停时保存的是裁剪后的历史，恢复时不再裁剪，避免重复总结
+	if resume == nil {
+		trimmed, err := ga.config.ContextPolicy.apply(ctx, history)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(trimmed) != len(history) {
+			ga.logger.DebugContext(ctx, "历史超出上下文预算，已裁剪", "messages", len(trimmed), "original", len(history))
+		}
+		history = trimmed
+	}
 
 	// 对话循环计数器
 	loopCount := 0
 	// 初始化token统计
 	tokenUsage := &TokenUsage{}
 
+	// 对话span，结束时记录累计token和错误
+	ctx, conv := ga.telemetry.startConversation(ctx, modelName)
+	defer func() { conv.end(tokenUsage, err) }()
+
 	// 初始化对话历史，只记录本次对话
 	var conversationHistory []ChatMessage
 
@@ -120,20 +192,74 @@ func (ga *GeminiAgent) StreamRunConversation(
 		}
 	}
 
+	// 执行一轮工具调用，把结果加入消息列表和对话历史
+	// 有工具需要审批时不执行任何工具，返回ApprovalRequiredError
+	runTools := func(calls []FunctionCall, decisions map[string]ApprovalDecision) error {
+		stage := &toolStage{
+			tools:       tools,
+			maxParallel: ga.config.MaxParallelToolCalls,
+			handler:     handler,
+			loop:        loopCount,
+			logger:      ga.logger,
+			errorPolicy: ga.config.ToolCallErrorPolicy,
+			middlewares: ga.config.ToolMiddlewares,
+			resultLimit: ga.config.ToolResultLimit,
+			telemetry:   ga.telemetry,
+		}
+		responses, pending, err := stage.run(ctx, calls, decisions)
+		if len(pending) > 0 {
+			approvalErr := newApprovalRequiredError(modelName, loopCount, history, conversationHistory, calls, pending, spentUsage(resume, tokenUsage))
+			ga.logger.InfoContext(ctx, "工具需要审批，对话暂停", "loop", loopCount, "pending", len(approvalErr.Pending.Requests))
+			handler.emit(StreamEvent{Type: EventApprovalRequired, Loop: loopCount, Approval: approvalErr.Pending})
+			return approvalErr
+		}
+
+		if len(responses) > 0 {
+			// 创建通用格式的工具响应消息
+			toolResponseMsg := ChatMessage{
+				Role:              "tool", // 使用tool角色而不是user
+				FunctionResponses: responses,
+			}
+			conversationHistory = append(conversationHistory, toolResponseMsg)
+			messages = append(messages, ga.convertMessage(toolResponseMsg))
+		}
+
+		// abort策略中止时每个调用都有错误响应，加入历史后再返回错误
+		if err != nil {
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return err
+		}
+		return nil
+	}
+
+	// 从审批暂停处恢复，先执行暂停时的工具调用
+	if resume != nil {
+		loopCount = resume.Loop
+		if err := runTools(resume.ToolCalls, decisions); err != nil {
+			return tokenUsage, conversationHistory, err
+		}
+
+		// 暂停前的token计入预算，超出时不再把工具结果发送给模型
+		if err := ga.config.Budget.check(modelName, loopCount, spentUsage(resume, tokenUsage), conversationHistory); err != nil {
+			ga.logger.WarnContext(ctx, "超出预算，对话停止", "loop", loopCount, "error", err)
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+	}
+
 	// 对话循环
 	for {
 		// 检查循环次数是否超过限制
 		loopCount++
 		if loopCount > ga.config.MaxLoops {
-			return tokenUsage, conversationHistory, fmt.Errorf("对话循环次数超过最大限制(%d)，可能存在递归", ga.config.MaxLoops)
+			err := &MaxLoopsError{MaxLoops: ga.config.MaxLoops}
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
 		}
 
-		ga.debugf("当前循环 %d", loopCount)
-		if loopCount > 1 {
-			if ga.config.Debug {
-				PrintJSON("messages", messages)
-			}
-		}
+		ctx = conv.startLoop(ctx, loopCount)
+		handler.emit(StreamEvent{Type: EventLoopIteration, Loop: loopCount})
+		ga.logger.DebugContext(ctx, "开始流式请求", "model", modelName, "loop", loopCount, "max_loops", ga.config.MaxLoops, "messages", len(messages))
 
 		//为了避免gemini爱不调用函数
 		if loopCount == 1 && ga.config.OnecFunctionCallingConfigModeAny {
@@ -156,7 +282,7 @@ func (ga *GeminiAgent) StreamRunConversation(
 		}
 
 		// 每次循环创建新的genConfig
-		genConfig := ga.createGenerateContentConfig()
+		genConfig := ga.createGenerateContentConfig(toolParams)
 
 		// 配置工具设置
 		genConfig.ToolConfig = &toolConfig
@@ -170,70 +296,35 @@ func (ga *GeminiAgent) StreamRunConversation(
 			}
 		}
 
-		if loopCount == 1 && ga.config.Debug {
-			PrintJSON("gemini genConfig", genConfig)
-		}
-
-		// 获取流式迭代器
-		iter := ga.client.Models.GenerateContentStream(ctx, modelName, messages, genConfig)
-
-		hasToolCalls := false
-		var functionCalls []*genai.FunctionCall
-		var partsList []*genai.Part
-		var currentResp *genai.GenerateContentResponse
-		var streamErr error
-		var textContent string // 用于累积文本内容
-
-		// 处理流式响应
-		iter(func(resp *genai.GenerateContentResponse, err error) bool {
-			if err != nil {
-				ga.debugf("流处理错误: %v", err)
-				streamErr = err
-				return false
-			}
-
-			// 保存最新的响应，用于获取token使用信息
-			currentResp = resp
-
-			//IncludeThoughts 开启思考
-			//Thought 思考
-
-			// 处理响应内容
-			if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
-				content := resp.Candidates[0].Content
-				for i, part := range content.Parts {
-					// 检查是否是文本内容
-					if part.Text != "" {
-						// 累积文本而不是添加新的部分
-						textContent += part.Text
-
-						// 调用回调函数处理流消息
-						if handler != nil {
-							handler(part.Text)
-						}
-					}
-
-					// 检测是否有函数调用
-					if part.FunctionCall != nil {
-						hasToolCalls = true
-						//自己维护callID
-						if part.FunctionCall.ID == "" {
-							part.FunctionCall.ID = fmt.Sprintf("auto_id_%d", i+1)
-							ga.debugf("工具调用ID为空，自动生成ID: %s", part.FunctionCall.ID)
-						}
-						functionCalls = append(functionCalls, part.FunctionCall)
-						callPart := &genai.Part{FunctionCall: part.FunctionCall}
-						partsList = append(partsList, callPart)
-						ga.debugf("检测到函数调用: %s", part.FunctionCall.Name)
-					}
-				}
-			}
-			return true
+		// 发起流式请求，临时错误按重试策略重新发起本轮请求
+		request := conv.startRequest(ctx, modelName, ga.config)
+		var turn *geminiTurn
+		err := retryDo(ctx, ga.config.RetryPolicy, func() error {
+			var err error
+			turn, err = ga.streamTurn(ctx, modelName, messages, genConfig, handler, loopCount)
+			return err
+		}, func(attempt int, wait time.Duration, err error) {
+			ga.logger.WarnContext(ctx, "请求失败，等待重试", "loop", loopCount, "attempt", attempt, "wait", wait, "error", err)
+			request.retry(attempt, err)
+			handler.emit(StreamEvent{Type: EventRetry, Loop: loopCount, Attempt: attempt, Err: err})
 		})
 
 		// 如果流处理中出现错误，返回错误
-		if streamErr != nil {
-			return tokenUsage, conversationHistory, fmt.Errorf("流处理错误: %w", streamErr)
+		if err != nil {
+			err = classifyError(fmt.Errorf("流处理错误: %w", err), loopCount)
+			request.end(nil, "", err)
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+
+		functionCalls := turn.functionCalls
+		partsList := turn.partsList
+		currentResp := turn.lastResp
+		textContent := turn.textContent
+		hasToolCalls := len(functionCalls) > 0
+
+		if turn.finishReason != "" {
+			handler.emit(StreamEvent{Type: EventFinishReason, Loop: loopCount, FinishReason: turn.finishReason})
 		}
 
 		// 添加累积的文本内容（如果有）
@@ -266,109 +357,160 @@ func (ga *GeminiAgent) StreamRunConversation(
 		conversationHistory = append(conversationHistory, assistantChatMsg)
 
 		// 更新token统计（如果有）
+		var turnUsage *TokenUsage
 		if currentResp != nil && currentResp.UsageMetadata != nil {
-			// 总消耗token
-			tokenUsage.TotalTokens += int(currentResp.UsageMetadata.TotalTokenCount)
-
-			// 提示词token
-			tokenUsage.PromptTokens += int(currentResp.UsageMetadata.PromptTokenCount)
+			metadata := currentResp.UsageMetadata
+			turnUsage = &TokenUsage{
+				TotalTokens:      int(metadata.TotalTokenCount),                                    // 总消耗token
+				PromptTokens:     int(metadata.PromptTokenCount),                                   // 提示词token
+				CompletionTokens: int(metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount), // 完成/响应token，与OpenAI一致包含思考token
+				ReasoningTokens:  int(metadata.ThoughtsTokenCount),                                 // 思考token
+				CacheTokens:      int(metadata.CachedContentTokenCount),                            // 缓存token
+			}
+			tokenUsage.Add(turnUsage)
 
-			// 完成/响应token
-			tokenUsage.CompletionTokens += int(currentResp.UsageMetadata.CandidatesTokenCount)
+			usage := *tokenUsage
+			handler.emit(StreamEvent{Type: EventUsage, Loop: loopCount, Usage: &usage})
+		}
+		request.end(turnUsage, turn.finishReason, nil)
 
-			// 缓存token
-			tokenUsage.CacheTokens += int(currentResp.UsageMetadata.CachedContentTokenCount)
+		// 提示词或回答被安全策略拦截
+		blockErr := contentBlocked(turn.blockReason, turn.blockMessage, loopCount, true)
+		if blockErr == nil {
+			blockErr = contentBlocked(turn.finishReason, "", loopCount, false)
+		}
+		if blockErr != nil {
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: blockErr})
+			return tokenUsage, conversationHistory, blockErr
 		}
 
 		// 如果有工具调用
 		if hasToolCalls && len(functionCalls) > 0 {
-			// 创建用户响应
-			userResponse := &genai.Content{
-				Role:  "user",
-				Parts: []*genai.Part{},
+			// 超出预算时不再执行本轮的工具
+			if stopped, err := ga.config.Budget.checkBeforeTools(modelName, loopCount, spentUsage(resume, tokenUsage), conversationHistory[:len(conversationHistory)-1], assistantChatMsg); err != nil {
+				ga.logger.WarnContext(ctx, "超出预算，对话停止", "loop", loopCount, "error", err)
+				handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+				return tokenUsage, stopped, err
 			}
 
-			// 创建通用格式的工具响应消息
-			toolResponseMsg := ChatMessage{
-				Role: "tool", // 使用tool角色而不是user
+			if err := runTools(assistantChatMsg.ToolCalls, nil); err != nil {
+				return tokenUsage, conversationHistory, err
 			}
 
-			// 处理所有工具调用
-			for _, functionCall := range functionCalls {
-				toolName := functionCall.Name
+			// 继续对话，将工具结果发送给模型
+			continue
+		} else {
+			// 没有工具调用，结束对话并返回token统计和对话历史
+			ga.logger.DebugContext(ctx, "对话结束", "loops", loopCount, "total_tokens", tokenUsage.TotalTokens)
+			return tokenUsage, conversationHistory, nil
+		}
+	}
+}
 
-				// 查找工具
-				tool, exists := ga.tools[toolName]
-				if !exists {
-					ga.debugf("未找到工具: %s", toolName)
-					continue
-				}
+// geminiTurn 一轮流式请求的结果
+type geminiTurn struct {
+	functionCalls []*genai.FunctionCall          // 函数调用
+	partsList     []*genai.Part                  // 函数调用对应的消息片段
+	lastResp      *genai.GenerateContentResponse // 最新的响应，用于获取token使用信息
+	textContent   string                         // 累积的文本内容
+	finishReason  string                         // 结束原因
+	blockReason   string                         // 提示词被拦截的原因
+	blockMessage  string                         // 提示词被拦截的说明
+}
 
-				// 将args转换为map[string]interface{}
-				var args map[string]interface{}
-				argsJSON, err := json.Marshal(functionCall.Args)
-				if err != nil {
-					ga.debugf("参数序列化错误: %v", err)
-					continue
-				}
+// streamTurn 发起一轮流式请求并处理响应
+func (ga *GeminiAgent) streamTurn(
+	ctx context.Context,
+	modelName string,
+	messages []*genai.Content,
+	genConfig *genai.GenerateContentConfig,
+	handler StreamEventHandler,
+	loopCount int,
+) (*geminiTurn, error) {
+	// 获取流式迭代器
+	iter := ga.client.Models.GenerateContentStream(ctx, modelName, messages, genConfig)
+
+	turn := &geminiTurn{}
+	var streamErr error
+
+	// 处理流式响应
+	iter(func(resp *genai.GenerateContentResponse, err error) bool {
+		if err != nil {
+			ga.logger.WarnContext(ctx, "流处理错误", "loop", loopCount, "error", err)
+			streamErr = err
+			return false
+		}
+
+		// 保存最新的响应，用于获取token使用信息
+		turn.lastResp = resp
 
-				if err := json.Unmarshal(argsJSON, &args); err != nil {
-					ga.debugf("参数解析错误: %v", err)
+		// 提示词被拦截时没有候选回答
+		if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+			turn.blockReason = string(resp.PromptFeedback.BlockReason)
+			turn.blockMessage = resp.PromptFeedback.BlockReasonMessage
+		}
+
+		if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason != "" {
+			turn.finishReason = string(resp.Candidates[0].FinishReason)
+		}
+
+		// 处理响应内容
+		if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
+			content := resp.Candidates[0].Content
+			for _, part := range content.Parts {
+				// 思考内容单独推送，不计入回答
+				if part.Thought {
+					if part.Text != "" {
+						handler.emit(StreamEvent{Type: EventThinkingDelta, Loop: loopCount, Text: part.Text})
+					}
 					continue
 				}
-				var responseMap map[string]any
-
-				// 打印要执行的方法和参数
-				argsJSON, _ = json.Marshal(args)
-				ga.debugf("执行工具: %s, 参数: %s", toolName, string(argsJSON))
-
-				// 执行工具
-				result, err := tool.Handler(args)
-				if err != nil {
-					ga.debugf("工具执行错误: %v", err)
-					// 将错误信息作为结果返回给模型
-					errResult := fmt.Sprintf("执行错误: %v", err)
-					responseMap = map[string]any{"output": errResult, "error": true}
-				} else {
-					ga.debugf("工具执行成功: %v", result)
-					// 添加函数响应到用户消息
-					responseMap = map[string]any{"output": result}
-				}
 
-				// 添加函数响应到Gemini消息
-				funcPart := genai.NewPartFromFunctionResponse(toolName, responseMap)
-				//自己维护callID
-				if funcPart.FunctionResponse != nil {
-					funcPart.FunctionResponse.ID = functionCall.ID
-				} else {
-					ga.debugf("警告: funcPart.FunctionResponse为空，无法设置ID")
+				// 检查是否是文本内容
+				if part.Text != "" {
+					// 累积文本而不是添加新的部分
+					turn.textContent += part.Text
+
+					// 调用回调函数处理流消息
+					handler.emit(StreamEvent{Type: EventTextDelta, Loop: loopCount, Text: part.Text})
 				}
-				userResponse.Parts = append(userResponse.Parts, funcPart)
 
-				// 创建通用格式的函数响应消息
-				funcResp := FunctionResponse{
-					ID:     functionCall.ID,
-					Name:   toolName,
-					Result: responseMap,
+				// 检测是否有函数调用
+				if part.FunctionCall != nil {
+					//自己维护callID
+					if part.FunctionCall.ID == "" {
+						part.FunctionCall.ID = fmt.Sprintf("auto_id_%d", len(turn.functionCalls)+1)
+						ga.logger.DebugContext(ctx, "工具调用ID为空，自动生成ID", "tool", part.FunctionCall.Name, "id", part.FunctionCall.ID)
+					}
+					turn.functionCalls = append(turn.functionCalls, part.FunctionCall)
+					callPart := &genai.Part{FunctionCall: part.FunctionCall}
+					turn.partsList = append(turn.partsList, callPart)
+					ga.logger.DebugContext(ctx, "检测到工具调用", "loop", loopCount, "tool", part.FunctionCall.Name, "id", part.FunctionCall.ID)
+
+					// Gemini一次性返回完整参数，参数增量事件直接携带完整JSON
+					toolCall := &FunctionCall{ID: part.FunctionCall.ID, Name: part.FunctionCall.Name, Args: part.FunctionCall.Args}
+					handler.emit(StreamEvent{Type: EventToolCallStarted, Loop: loopCount, ToolCall: toolCall})
+					if argsJSON, err := json.Marshal(part.FunctionCall.Args); err == nil {
+						handler.emit(StreamEvent{Type: EventToolCallArgsDelta, Loop: loopCount, ToolCall: toolCall, ArgsDelta: string(argsJSON)})
+					}
 				}
-				toolResponseMsg.FunctionResponses = append(toolResponseMsg.FunctionResponses, funcResp)
 			}
+		}
+		return true
+	})
 
-			// 添加工具响应到对话历史
-			conversationHistory = append(conversationHistory, toolResponseMsg)
-
-			// 添加用户响应到消息列表
-			messages = append(messages, userResponse)
+	if streamErr != nil {
+		return nil, streamErr
+	}
+	return turn, nil
+}
 
-			// 继续对话，将工具结果发送给模型
-			continue
-		} else {
-			fmt.Println()
-			// 没有工具调用，结束对话并返回token统计和对话历史
-			ga.debugf("对话结束，返回Token统计: %+v", tokenUsage)
-			return tokenUsage, conversationHistory, nil
-		}
+// DefaultModelName 未指定模型时使用的模型名称
+func (ga *GeminiAgent) DefaultModelName() string {
+	if ga.config.ModelName != "" {
+		return ga.config.ModelName
 	}
+	return "gemini-2.0-flash"
 }
 
 // RegisterTool 注册工具
@@ -382,6 +524,8 @@ func (ga *GeminiAgent) RegisterTool(function FunctionDefinitionParam, handler To
 	}
 
 	// 保存工具
+	ga.toolsMu.Lock()
+	defer ga.toolsMu.Unlock()
 	ga.tools[function.Name] = Tool{
 		Function: function,
 		Handler:  handler,
@@ -389,11 +533,50 @@ func (ga *GeminiAgent) RegisterTool(function FunctionDefinitionParam, handler To
 	return nil
 }
 
-// 重建工具参数
-func (ga *GeminiAgent) rebuildToolParams() {
-	ga.toolParams = []*genai.Tool{}
+// RegisterContextTool 注册带上下文的工具
+func (ga *GeminiAgent) RegisterContextTool(function FunctionDefinitionParam, handler ContextToolFunction, options ToolOptions) error {
+	if function.Name == "" {
+		return fmt.Errorf("工具名称不能为空")
+	}
+
+	if handler == nil {
+		return fmt.Errorf("工具处理函数不能为空")
+	}
+
+	// 保存工具
+	ga.toolsMu.Lock()
+	defer ga.toolsMu.Unlock()
+	ga.tools[function.Name] = Tool{
+		Function:       function,
+		ContextHandler: handler,
+		Options:        options,
+	}
+	return nil
+}
+
+// UnregisterTool 注销工具，不影响进行中的对话
+func (ga *GeminiAgent) UnregisterTool(name string) error {
+	ga.toolsMu.Lock()
+	defer ga.toolsMu.Unlock()
+	if _, ok := ga.tools[name]; !ok {
+		return &ToolNotFoundError{Name: name}
+	}
+	delete(ga.tools, name)
+	return nil
+}
+
+// ListTools 列出已注册工具的定义，按名称排序
+func (ga *GeminiAgent) ListTools() []FunctionDefinitionParam {
+	ga.toolsMu.RLock()
+	defer ga.toolsMu.RUnlock()
+	return toolDefinitions(ga.tools)
+}
+
+// 构建工具参数
+func (ga *GeminiAgent) buildToolParams(tools map[string]Tool) []*genai.Tool {
+	toolParams := []*genai.Tool{}
 
-	for _, tool := range ga.tools {
+	for _, tool := range sortedTools(tools) {
 		// 创建函数声明
 		functionDec := &genai.FunctionDeclaration{
 			Name:        tool.Function.Name,
@@ -402,19 +585,9 @@ func (ga *GeminiAgent) rebuildToolParams() {
 
 		// 如果有参数，设置参数
 		if len(tool.Function.Parameters) > 0 {
-			// 创建Schema对象
-			schema := &genai.Schema{}
-
-			// 将参数转换为JSON字符串
-			paramsJSON, err := json.Marshal(tool.Function.Parameters)
+			schema, err := toGenaiSchema(tool.Function.Parameters)
 			if err != nil {
-				ga.debugf("参数序列化错误: %v", err)
-				continue
-			}
-
-			// 将JSON数据应用到schema
-			if err := json.Unmarshal(paramsJSON, schema); err != nil {
-				ga.debugf("参数解析到Schema错误: %v", err)
+				ga.logger.Warn("工具参数转换为Schema错误，已忽略该工具", "tool", tool.Function.Name, "error", err)
 				continue
 			}
 
@@ -428,12 +601,13 @@ func (ga *GeminiAgent) rebuildToolParams() {
 		}
 
 		// 添加工具参数
-		ga.toolParams = append(ga.toolParams, toolParam)
+		toolParams = append(toolParams, toolParam)
 	}
+	return toolParams
 }
 
 // 创建内容生成配置
-func (ga *GeminiAgent) createGenerateContentConfig() *genai.GenerateContentConfig {
+func (ga *GeminiAgent) createGenerateContentConfig(toolParams []*genai.Tool) *genai.GenerateContentConfig {
 	config := &genai.GenerateContentConfig{}
 
 	// 设置生成参数
@@ -453,11 +627,47 @@ func (ga *GeminiAgent) createGenerateContentConfig() *genai.GenerateContentConfi
 	}
 
 	// 设置工具
-	config.Tools = ga.toolParams
+	config.Tools = toolParams
+
+	// 思考配置
+	if thinking := ga.config.Thinking; thinking != nil {
+		config.ThinkingConfig = &genai.ThinkingConfig{
+			IncludeThoughts: thinking.Enabled,
+		}
+		if thinking.BudgetTokens > 0 {
+			budget := int32(thinking.BudgetTokens)
+			config.ThinkingConfig.ThinkingBudget = &budget
+		}
+	}
+
+	// 结构化输出
+	if rs := ga.config.ResponseSchema; rs != nil && len(rs.Schema) > 0 {
+		schema, err := toGenaiSchema(rs.Schema)
+		if err != nil {
+			ga.logger.Warn("结构化输出Schema解析错误", "error", err)
+		} else {
+			config.ResponseMIMEType = "application/json"
+			config.ResponseSchema = schema
+		}
+	}
 
 	return config
 }
 
+// toGenaiSchema 把JSON Schema转换为Gemini的Schema，Gemini不支持的字段会被忽略
+func toGenaiSchema(params map[string]interface{}) (*genai.Schema, error) {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("序列化Schema错误: %w", err)
+	}
+
+	schema := &genai.Schema{}
+	if err := json.Unmarshal(paramsJSON, schema); err != nil {
+		return nil, fmt.Errorf("解析Schema错误: %w", err)
+	}
+	return schema, nil
+}
+
 // 提取系统消息
 func (ga *GeminiAgent) extractSystemMessage(messages []ChatMessage) (string, []ChatMessage) {
 	var systemMsg string
@@ -465,7 +675,7 @@ func (ga *GeminiAgent) extractSystemMessage(messages []ChatMessage) (string, []C
 
 	for _, msg := range messages {
 		if msg.Role == "system" {
-			systemMsg = msg.Content
+			systemMsg = msg.textContent()
 		} else {
 			otherMsgs = append(otherMsgs, msg)
 		}
@@ -482,10 +692,8 @@ func (ga *GeminiAgent) convertMessage(msg ChatMessage) *genai.Content {
 			Parts: []*genai.Part{},
 		}
 
-		// 添加文本内容
-		if msg.Content != "" {
-			content.Parts = append(content.Parts, genai.NewPartFromText(msg.Content))
-		}
+		// 添加文本和多模态内容
+		content.Parts = append(content.Parts, ga.convertContentParts(msg)...)
 
 		// 处理工具调用
 		for _, toolCall := range msg.ToolCalls {
@@ -517,7 +725,7 @@ func (ga *GeminiAgent) convertMessage(msg ChatMessage) *genai.Content {
 			if funcPart.FunctionResponse != nil {
 				funcPart.FunctionResponse.ID = funcResp.ID
 			} else {
-				ga.debugf("警告: funcPart.FunctionResponse为空，无法设置ID")
+				ga.logger.Warn("FunctionResponse为空，无法设置ID", "tool", funcResp.Name, "id", funcResp.ID)
 			}
 			content.Parts = append(content.Parts, funcPart)
 		}
@@ -529,21 +737,36 @@ func (ga *GeminiAgent) convertMessage(msg ChatMessage) *genai.Content {
 			Role:  "user",
 			Parts: []*genai.Part{},
 		}
-		content.Parts = append(content.Parts, genai.NewPartFromText(msg.Content))
+		content.Parts = append(content.Parts, ga.convertContentParts(msg)...)
+		if len(content.Parts) == 0 {
+			content.Parts = append(content.Parts, genai.NewPartFromText(msg.Content))
+		}
 		return content
 	}
 }
 
+// convertContentParts 转换消息的文本和多模态内容片段
+func (ga *GeminiAgent) convertContentParts(msg ChatMessage) []*genai.Part {
+	var parts []*genai.Part
+	for _, part := range msg.contentParts() {
+		switch {
+		case part.Type == PartText:
+			parts = append(parts, genai.NewPartFromText(part.Text))
+		case len(part.Data) > 0:
+			parts = append(parts, genai.NewPartFromBytes(part.Data, part.MIMEType))
+		case part.URL != "":
+			parts = append(parts, genai.NewPartFromURI(part.URL, part.MIMEType))
+		default:
+			ga.logger.Warn("忽略没有数据的内容片段", "type", part.Type)
+		}
+	}
+	return parts
+}
+
 // SetDebug 设置调试模式
 func (ga *GeminiAgent) SetDebug(debug bool) {
 	ga.config.Debug = debug
-}
-
-// debugf 调试输出，统一处理所有调试信息
-func (ga *GeminiAgent) debugf(format string, args ...interface{}) {
-	if ga.config.Debug {
-		fmt.Printf("【DEBUG】"+format+"\n", args...)
-	}
+	ga.logger = newLogger(ga.config, "gemini")
 }
 
 // 从Gemini响应转换到通用格式的函数
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// HistoryStore 对话历史存储，按对话ID保存通用格式的消息
+type HistoryStore interface {
+	Load(ctx context.Context, conversationID string) ([]ChatMessage, error)           // 读取对话历史，不存在时返回空
+	Append(ctx context.Context, conversationID string, messages ...ChatMessage) error // 追加消息
+	Save(ctx context.Context, conversationID string, messages []ChatMessage) error    // 覆盖保存全部消息
+	Delete(ctx context.Context, conversationID string) error                          // 删除对话，不存在时不报错
+}
+
+// MemoryHistoryStore 内存存储，进程退出后丢失，适合测试
+type MemoryHistoryStore struct {
+	mu            sync.RWMutex
+	conversations map[string][]ChatMessage
+}
+
+// NewMemoryHistoryStore 创建内存存储
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{conversations: make(map[string][]ChatMessage)}
+}
+
+// Load 读取对话历史
+func (m *MemoryHistoryStore) Load(ctx context.Context, conversationID string) ([]ChatMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]ChatMessage(nil), m.conversations[conversationID]...), nil
+}
+
+// Append 追加消息
+func (m *MemoryHistoryStore) Append(ctx context.Context, conversationID string, messages ...ChatMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conversations[conversationID] = append(m.conversations[conversationID], messages...)
+	return nil
+}
+
+// Save 覆盖保存全部消息
+func (m *MemoryHistoryStore) Save(ctx context.Context, conversationID string, messages []ChatMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conversations[conversationID] = append([]ChatMessage(nil), messages...)
+	return nil
+}
+
+// Delete 删除对话
+func (m *MemoryHistoryStore) Delete(ctx context.Context, conversationID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conversations, conversationID)
+	return nil
+}
+
+// SetHistoryStore 设置对话历史存储，供StreamRunStoredConversation使用
+func (s *AgentService) SetHistoryStore(store HistoryStore) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.store = store
+}
+
+// StreamRunStoredConversation 按对话ID执行对话
+// 读取存储的历史并追加messages(如系统提示和本次问题)后执行，成功后把messages和本次对话写回存储
+// 存储的历史已以系统提示开头时忽略messages中的系统消息，系统提示只在第一次对话时写入
+// 工具需要审批时同样写回暂停前的对话，返回的ApprovalRequiredError由调用方保存，审批后通过ResumeStoredConversation继续
+func (s *AgentService) StreamRunStoredConversation(
+	ctx context.Context, //上下文
+	agentName AgentName, //agent名称
+	modelName string, //模型名称
+	conversationID string, //对话ID
+	messages []ChatMessage, //本次新增的消息，最后一条为用户问题
+	handler StreamEventHandler, //结构化流式事件回调
+) (*TokenUsage, []ChatMessage, error) { //返回token使用统计和本次对话历史
+	store, err := s.historyStore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, err := store.Load(ctx, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 每次对话都传入系统提示时不重复写入
+	if len(history) > 0 && history[0].Role == "system" {
+		messages = withoutSystemMessages(messages)
+	}
+	history = append(history, messages...)
+
+	usage, conversation, err := s.StreamRunConversationEvents(ctx, agentName, modelName, history, handler)
+	var approvalErr *ApprovalRequiredError
+	if err != nil && !errors.As(err, &approvalErr) {
+		return usage, conversation, err
+	}
+
+	// 本次对话以用户问题开头，已包含在messages中
+	progressed := conversation
+	if len(progressed) > 0 && len(messages) > 0 && progressed[0].Role == "user" && messages[len(messages)-1].Role == "user" {
+		progressed = progressed[1:]
+	}
+	newMessages := append(append([]ChatMessage{}, messages...), progressed...)
+	if err := store.Append(ctx, conversationID, newMessages...); err != nil {
+		return usage, conversation, fmt.Errorf("保存对话历史错误: %w", err)
+	}
+	return usage, conversation, err
+}
+
+// ResumeStoredConversation 审批后恢复按对话ID执行时暂停的对话，把恢复后产生的对话追加到存储
+// 需要使用暂停时的agent，再次需要审批时同样追加暂停前的对话
+func (s *AgentService) ResumeStoredConversation(
+	ctx context.Context, //上下文
+	agentName AgentName, //agent名称
+	conversationID string, //对话ID
+	pending *PendingApproval, //审批暂停时返回的对话状态
+	decisions []ApprovalDecision, //对待审批工具调用的决定
+	handler StreamEventHandler, //结构化流式事件回调
+) (*TokenUsage, []ChatMessage, error) { //返回token使用统计和恢复后产生的对话历史
+	store, err := s.historyStore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usage, conversation, err := s.ResumeConversation(ctx, agentName, pending, decisions, handler)
+	var approvalErr *ApprovalRequiredError
+	if err != nil && !errors.As(err, &approvalErr) {
+		return usage, conversation, err
+	}
+	if err := store.Append(ctx, conversationID, conversation...); err != nil {
+		return usage, conversation, fmt.Errorf("保存对话历史错误: %w", err)
+	}
+	return usage, conversation, err
+}
+
+// withoutSystemMessages 去掉系统消息
+func withoutSystemMessages(messages []ChatMessage) []ChatMessage {
+	var filtered []ChatMessage
+	for _, msg := range messages {
+		if msg.Role != "system" {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
+
+// historyStore 返回设置的对话历史存储
+func (s *AgentService) historyStore() (HistoryStore, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.store == nil {
+		return nil, fmt.Errorf("未设置对话历史存储")
+	}
+	return s.store, nil
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileHistoryStore JSON文件存储，每个对话保存为目录下的一个JSON文件
+type FileHistoryStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileHistoryStore 创建JSON文件存储，目录不存在时自动创建
+func NewFileHistoryStore(dir string) (*FileHistoryStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建存储目录错误: %w", err)
+	}
+	return &FileHistoryStore{dir: dir}, nil
+}
+
+// Load 读取对话历史
+func (f *FileHistoryStore) Load(ctx context.Context, conversationID string) ([]ChatMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.load(conversationID)
+}
+
+// Append 追加消息
+func (f *FileHistoryStore) Append(ctx context.Context, conversationID string, messages ...ChatMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	history, err := f.load(conversationID)
+	if err != nil {
+		return err
+	}
+	return f.save(conversationID, append(history, messages...))
+}
+
+// Save 覆盖保存全部消息
+func (f *FileHistoryStore) Save(ctx context.Context, conversationID string, messages []ChatMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.save(conversationID, messages)
+}
+
+// Delete 删除对话
+func (f *FileHistoryStore) Delete(ctx context.Context, conversationID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path, err := f.path(conversationID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("删除对话历史错误: %w", err)
+	}
+	return nil
+}
+
+// path 返回对话的文件路径，对话ID转义后作为文件名，防止路径穿越
+func (f *FileHistoryStore) path(conversationID string) (string, error) {
+	name := url.PathEscape(conversationID)
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("无效的对话ID: %q", conversationID)
+	}
+	return filepath.Join(f.dir, name+".json"), nil
+}
+
+func (f *FileHistoryStore) load(conversationID string) ([]ChatMessage, error) {
+	path, err := f.path(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取对话历史错误: %w", err)
+	}
+
+	var history []ChatMessage
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("解析对话历史错误: %w", err)
+	}
+	return history, nil
+}
+
+// save 先写临时文件再重命名，避免写入中断时损坏原文件
+func (f *FileHistoryStore) save(conversationID string, messages []ChatMessage) error {
+	path, err := f.path(conversationID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("序列化对话历史错误: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("写入对话历史错误: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("写入对话历史错误: %w", err)
+	}
+	return nil
+}
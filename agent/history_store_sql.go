@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// SQLiteHistoryStore SQLite存储，每条消息一行，按对话ID和序号排序
+// 使用database/sql，由调用方导入SQLite驱动(如modernc.org/sqlite或github.com/mattn/go-sqlite3)并打开数据库
+type SQLiteHistoryStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteHistoryStore 创建SQLite存储，表不存在时自动创建
+func NewSQLiteHistoryStore(ctx context.Context, db *sql.DB) (*SQLiteHistoryStore, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS agent_chat_history (
+		conversation_id TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (conversation_id, seq)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("创建对话历史表错误: %w", err)
+	}
+	return &SQLiteHistoryStore{db: db}, nil
+}
+
+// Load 读取对话历史
+func (s *SQLiteHistoryStore) Load(ctx context.Context, conversationID string) ([]ChatMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT message FROM agent_chat_history WHERE conversation_id = ? ORDER BY seq`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("读取对话历史错误: %w", err)
+	}
+	defer rows.Close()
+
+	var history []ChatMessage
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("读取对话历史错误: %w", err)
+		}
+		var msg ChatMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, fmt.Errorf("解析对话历史错误: %w", err)
+		}
+		history = append(history, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取对话历史错误: %w", err)
+	}
+	return history, nil
+}
+
+// Append 追加消息
+func (s *SQLiteHistoryStore) Append(ctx context.Context, conversationID string, messages ...ChatMessage) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		var next int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE(MAX(seq), -1) + 1 FROM agent_chat_history WHERE conversation_id = ?`, conversationID).Scan(&next)
+		if err != nil {
+			return fmt.Errorf("读取对话序号错误: %w", err)
+		}
+		return s.insert(ctx, tx, conversationID, next, messages)
+	})
+}
+
+// Save 覆盖保存全部消息
+func (s *SQLiteHistoryStore) Save(ctx context.Context, conversationID string, messages []ChatMessage) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM agent_chat_history WHERE conversation_id = ?`, conversationID); err != nil {
+			return fmt.Errorf("清空对话历史错误: %w", err)
+		}
+		return s.insert(ctx, tx, conversationID, 0, messages)
+	})
+}
+
+// Delete 删除对话
+func (s *SQLiteHistoryStore) Delete(ctx context.Context, conversationID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM agent_chat_history WHERE conversation_id = ?`, conversationID); err != nil {
+		return fmt.Errorf("删除对话历史错误: %w", err)
+	}
+	return nil
+}
+
+// insert 从序号start开始写入消息
+func (s *SQLiteHistoryStore) insert(ctx context.Context, tx *sql.Tx, conversationID string, start int64, messages []ChatMessage) error {
+	for i, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("序列化消息错误: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO agent_chat_history (conversation_id, seq, message) VALUES (?, ?, ?)`,
+			conversationID, start+int64(i), string(data))
+		if err != nil {
+			return fmt.Errorf("写入对话历史错误: %w", err)
+		}
+	}
+	return nil
+}
+
+// withTx 在事务中执行fn，出错时回滚
+func (s *SQLiteHistoryStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("开启事务错误: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交事务错误: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build cgo
+
+// github.com/mattn/go-sqlite3需要cgo，CGO_ENABLED=0时跳过
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLiteHistoryStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+	// 内存数据库每个连接独立，只使用一个连接
+	db.SetMaxOpenConns(1)
+
+	store, err := NewSQLiteHistoryStore(context.Background(), db)
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	testHistoryStore(t, store)
+
+	// 表已存在时可以重复创建
+	if _, err := NewSQLiteHistoryStore(context.Background(), db); err != nil {
+		t.Errorf("重复创建存储失败: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// testHistoryStore 各存储实现共用的读写测试
+func testHistoryStore(t *testing.T, store HistoryStore) {
+	ctx := context.Background()
+
+	history, err := store.Load(ctx, "missing")
+	if err != nil || len(history) != 0 {
+		t.Fatalf("不存在的对话应返回空: %v, %v", history, err)
+	}
+
+	if err := store.Append(ctx, "conv/1", ChatMessage{Role: "user", Content: "你好"}); err != nil {
+		t.Fatalf("追加失败: %v", err)
+	}
+	err = store.Append(ctx, "conv/1", ChatMessage{
+		Role:      "assistant",
+		ToolCalls: []FunctionCall{{ID: "call_1", Name: "get_time", Args: map[string]any{"tz": "UTC"}}},
+	}, ChatMessage{Role: "assistant", Content: "现在是中午"})
+	if err != nil {
+		t.Fatalf("追加失败: %v", err)
+	}
+
+	history, err = store.Load(ctx, "conv/1")
+	if err != nil || len(history) != 3 {
+		t.Fatalf("读取结果错误: %+v, %v", history, err)
+	}
+	if history[1].ToolCalls[0].Args["tz"] != "UTC" || history[2].Content != "现在是中午" {
+		t.Errorf("消息内容错误: %+v", history)
+	}
+
+	if err := store.Save(ctx, "conv/1", history[2:]); err != nil {
+		t.Fatalf("覆盖保存失败: %v", err)
+	}
+	if history, _ = store.Load(ctx, "conv/1"); len(history) != 1 {
+		t.Errorf("覆盖保存后结果错误: %+v", history)
+	}
+
+	if err := store.Delete(ctx, "conv/1"); err != nil {
+		t.Fatalf("删除失败: %v", err)
+	}
+	if err := store.Delete(ctx, "conv/1"); err != nil {
+		t.Errorf("重复删除不应报错: %v", err)
+	}
+	if history, _ = store.Load(ctx, "conv/1"); len(history) != 0 {
+		t.Errorf("删除后仍能读取: %+v", history)
+	}
+}
+
+func TestMemoryHistoryStore(t *testing.T) {
+	testHistoryStore(t, NewMemoryHistoryStore())
+}
+
+func TestFileHistoryStore(t *testing.T) {
+	store, err := NewFileHistoryStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	testHistoryStore(t, store)
+
+	if err := store.Save(context.Background(), "..", nil); err == nil {
+		t.Error("非法对话ID应返回错误")
+	}
+}
+
+// 测试按对话ID执行对话时读取并写回历史
+func TestStreamRunStoredConversation(t *testing.T) {
+	ctx := context.Background()
+	service := NewAgentService(ctx)
+	service.RegisterAgent(Gemini, &sessionTestAgent{})
+	store := NewMemoryHistoryStore()
+	service.SetHistoryStore(store)
+
+	first := []ChatMessage{{Role: "system", Content: "你是助手"}, {Role: "user", Content: "你好"}}
+	if _, _, err := service.StreamRunStoredConversation(ctx, Gemini, "", "c1", first, nil); err != nil {
+		t.Fatalf("第一次对话失败: %v", err)
+	}
+	_, conversation, err := service.StreamRunStoredConversation(ctx, Gemini, "", "c1", []ChatMessage{{Role: "user", Content: "再见"}}, nil)
+	if err != nil {
+		t.Fatalf("第二次对话失败: %v", err)
+	}
+	if conversation[1].Content != "收到4条" {
+		t.Errorf("第二次对话没有带上存储的历史: %+v", conversation)
+	}
+
+	history, _ := store.Load(ctx, "c1")
+	if len(history) != 5 || history[0].Role != "system" || history[4].Content != "收到4条" {
+		t.Errorf("存储的历史错误: %+v", history)
+	}
+}
+
+// 测试每次对话都传入系统提示时只存储一条
+func TestStreamRunStoredConversationSystemPrompt(t *testing.T) {
+	ctx := context.Background()
+	service := NewAgentService(ctx)
+	service.RegisterAgent(Gemini, &sessionTestAgent{})
+	store := NewMemoryHistoryStore()
+	service.SetHistoryStore(store)
+
+	system := ChatMessage{Role: "system", Content: "你是助手"}
+	for _, question := range []string{"你好", "再见"} {
+		if _, _, err := service.StreamRunStoredConversation(ctx, Gemini, "", "c1", []ChatMessage{system, {Role: "user", Content: question}}, nil); err != nil {
+			t.Fatalf("对话失败: %v", err)
+		}
+	}
+
+	history, _ := store.Load(ctx, "c1")
+	systems := 0
+	for _, msg := range history {
+		if msg.Role == "system" {
+			systems++
+		}
+	}
+	if systems != 1 || len(history) != 5 || history[4].Content != "收到4条" {
+		t.Errorf("应只存储一条系统提示: %+v", history)
+	}
+}
+
+// 测试审批暂停时写回暂停前的对话，恢复后追加恢复产生的对话
+func TestStreamRunStoredConversationApproval(t *testing.T) {
+	ctx := context.Background()
+	service := NewAgentService(ctx)
+	service.RegisterAgent(Gemini, &sessionTestAgent{})
+	store := NewMemoryHistoryStore()
+	service.SetHistoryStore(store)
+
+	_, _, err := service.StreamRunStoredConversation(ctx, Gemini, "", "c1", []ChatMessage{{Role: "user", Content: "删除"}}, nil)
+	var approvalErr *ApprovalRequiredError
+	if !errors.As(err, &approvalErr) {
+		t.Fatalf("应返回审批错误: %v", err)
+	}
+	history, _ := store.Load(ctx, "c1")
+	if len(history) != 2 || history[0].Content != "删除" || len(history[1].ToolCalls) != 1 {
+		t.Fatalf("暂停时应保存暂停前的对话: %+v", history)
+	}
+
+	if _, _, err := service.ResumeStoredConversation(ctx, Gemini, "c1", approvalErr.Pending, []ApprovalDecision{{CallID: "call_1", Action: ApprovalApprove}}, nil); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	history, _ = store.Load(ctx, "c1")
+	if len(history) != 4 || len(history[2].FunctionResponses) != 1 || history[3].Content != "已删除" {
+		t.Errorf("恢复后应追加工具结果和回答: %+v", history)
+	}
+}
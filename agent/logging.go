@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/562589540/agent-go/pkg/logging"
+)
+
+// LevelTrace 比Debug更详细的日志级别，启用后记录脱敏的HTTP请求体和响应体
+const LevelTrace = logging.LevelTrace
+
+// logRedacted 脱敏后的占位值
+const logRedacted = "REDACTED"
+
+// logRedactHeaders 日志中脱敏的请求头和响应头
+var logRedactHeaders = []string{"Authorization", "X-Goog-Api-Key", "Api-Key", "X-Api-Key", "Cookie", "Set-Cookie"}
+
+// logRedactQuery 日志中脱敏的URL查询参数
+var logRedactQuery = []string{"key", "api_key"}
+
+// newLogger 创建代理使用的日志记录器
+// 未设置Logger时，Debug模式以Debug级别输出到标准输出，否则不输出
+func newLogger(config AgentConfig, provider string) *slog.Logger {
+	logger := config.Logger
+	if logger == nil {
+		if !config.Debug {
+			return discardLogger
+		}
+		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	return logger.With("provider", provider)
+}
+
+// discardLogger 丢弃所有日志的记录器
+var discardLogger = logging.Discard
+
+// loggingClient 返回记录HTTP流量的客户端，只有日志启用LevelTrace时才读取和记录请求体、响应体
+func loggingClient(client *http.Client, logger *slog.Logger) *http.Client {
+	var c http.Client
+	if client != nil {
+		c = *client
+	}
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.Transport = &loggingTransport{base: base, logger: logger}
+	return &c
+}
+
+// loggingTransport 在LevelTrace级别记录脱敏后的HTTP请求和响应
+type loggingTransport struct {
+	base   http.RoundTripper
+	logger *slog.Logger
+}
+
+// RoundTrip 实现http.RoundTripper
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !t.logger.Enabled(ctx, LevelTrace) {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	t.logger.Log(ctx, LevelTrace, "HTTP请求",
+		"method", req.Method,
+		"url", redactLogURL(req),
+		"header", redactLogHeader(req.Header),
+		"body", string(body))
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.logger.Log(ctx, LevelTrace, "HTTP请求失败", "url", redactLogURL(req), "error", err, "duration", time.Since(start))
+		return nil, err
+	}
+
+	// 流式响应读取完毕或关闭后才记录完整的响应体
+	status, header := resp.StatusCode, redactLogHeader(resp.Header)
+	resp.Body = &loggingBody{
+		ReadCloser: resp.Body,
+		done: func(data []byte) {
+			t.logger.Log(ctx, LevelTrace, "HTTP响应",
+				"url", redactLogURL(req),
+				"status", status,
+				"header", header,
+				"body", string(data),
+				"duration", time.Since(start))
+		},
+	}
+	return resp, nil
+}
+
+// redactLogHeader 复制请求头并脱敏
+func redactLogHeader(header http.Header) http.Header {
+	clone := header.Clone()
+	for _, name := range logRedactHeaders {
+		if _, ok := clone[http.CanonicalHeaderKey(name)]; ok {
+			clone.Set(name, logRedacted)
+		}
+	}
+	return clone
+}
+
+// redactLogURL 脱敏URL中的密钥参数
+func redactLogURL(req *http.Request) string {
+	u := *req.URL
+	query := u.Query()
+	changed := false
+	for _, name := range logRedactQuery {
+		if query.Has(name) {
+			query.Set(name, logRedacted)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// loggingBody 边读取边缓存响应体，流式响应可以正常逐块读取
+type loggingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func(data []byte)
+	once sync.Once
+}
+
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *loggingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *loggingBody) finish() {
+	b.once.Do(func() {
+		b.done(b.buf.Bytes())
+	})
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	ctx := context.Background()
+	if newLogger(AgentConfig{}, "openai").Enabled(ctx, slog.LevelError) {
+		t.Error("未设置Logger且未开启调试时不应输出")
+	}
+	if !newLogger(AgentConfig{Debug: true}, "openai").Enabled(ctx, slog.LevelDebug) {
+		t.Error("调试模式应输出Debug日志")
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	l := newLogger(AgentConfig{Logger: logger}, "gemini")
+	l.Debug("忽略")
+	l.Info("记录")
+	if out := buf.String(); strings.Contains(out, "忽略") || !strings.Contains(out, "provider=gemini") {
+		t.Errorf("应使用自定义Logger的级别并带provider字段: %s", out)
+	}
+}
+
+func TestLoggingTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte("echo:"), body...))
+	}))
+	defer srv.Close()
+
+	send := func(level slog.Level) (string, string) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level}))
+		client := loggingClient(nil, logger)
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat?key=secret-key", strings.NewReader("hello"))
+		req.Header.Set("Authorization", "Bearer secret-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(data), buf.String()
+	}
+
+	// Debug级别不读取请求体和响应体
+	if body, out := send(slog.LevelDebug); body != "echo:hello" || out != "" {
+		t.Errorf("Debug级别不应记录HTTP流量: body=%q log=%q", body, out)
+	}
+
+	body, out := send(LevelTrace)
+	if body != "echo:hello" {
+		t.Errorf("记录日志不应影响请求和响应: %q", body)
+	}
+	for _, want := range []string{"HTTP请求", "body=hello", "HTTP响应", "body=echo:hello", "status=200", logRedacted} {
+		if !strings.Contains(out, want) {
+			t.Errorf("日志缺少%q: %s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret-key") || strings.Contains(out, "secret-token") {
+		t.Errorf("日志中不应包含密钥: %s", out)
+	}
+}
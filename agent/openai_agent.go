@@ -2,23 +2,31 @@ package agent
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/shared"
 )
 
 // OpenAIAgent 实现Agent接口的OpenAI代理
 type OpenAIAgent struct {
-	client     openai.Client
-	config     AgentConfig
-	tools      map[string]Tool
-	toolParams []openai.ChatCompletionToolParam
+	client  openai.Client
+	config  AgentConfig
+	tools   map[string]Tool
+	toolsMu sync.RWMutex // 保护tools，对话开始时复制本次可用的工具
+
+	telemetry *telemetry   // OpenTelemetry追踪和指标，未配置时为空
+	logger    *slog.Logger // 结构化日志
 }
 
 // NewOpenAIAgent 创建一个新的OpenAI代理
@@ -34,8 +42,11 @@ func NewOpenAIAgent(config AgentConfig) (*OpenAIAgent, error) {
 		opts = append(opts, option.WithBaseURL(config.BaseURL))
 	}
 
-	// 如果设置了代理
-	if config.ProxyURL != "" {
+	logger := newLogger(config, "openai")
+
+	// 优先使用自定义HTTP客户端，其次使用代理
+	httpClient := config.Client
+	if httpClient == nil && config.ProxyURL != "" {
 		proxyURL, err := url.Parse(config.ProxyURL)
 		if err != nil {
 			return nil, fmt.Errorf("解析代理URL错误: %v", err)
@@ -44,12 +55,23 @@ func NewOpenAIAgent(config AgentConfig) (*OpenAIAgent, error) {
 		transport := &http.Transport{
 			Proxy: http.ProxyURL(proxyURL),
 		}
-		httpClient := &http.Client{
+		httpClient = &http.Client{
 			Transport: transport,
 		}
+	}
+	// 设置了Logger时记录HTTP流量，是否输出由LevelTrace决定
+	if config.Logger != nil {
+		httpClient = loggingClient(httpClient, logger)
+	}
+	if httpClient != nil {
 		opts = append(opts, option.WithHTTPClient(httpClient))
 	}
 
+	// 配置了重试策略时关闭SDK自带的重试，避免重复重试
+	if config.RetryPolicy != nil {
+		opts = append(opts, option.WithMaxRetries(0))
+	}
+
 	// 设置默认值
 	if config.MaxLoops <= 0 {
 		config.MaxLoops = 5
@@ -72,10 +94,12 @@ func NewOpenAIAgent(config AgentConfig) (*OpenAIAgent, error) {
 	client := openai.NewClient(opts...)
 
 	return &OpenAIAgent{
-		client:     client,
-		config:     config,
-		tools:      make(map[string]Tool),
-		toolParams: []openai.ChatCompletionToolParam{},
+		client: client,
+		config: config,
+		tools:  make(map[string]Tool),
+
+		telemetry: newTelemetry(config.Telemetry, "openai"),
+		logger:    logger,
 	}, nil
 }
 
@@ -86,8 +110,59 @@ func (oa *OpenAIAgent) StreamRunConversation(
 	history []ChatMessage,
 	handler StreamHandler,
 ) (*TokenUsage, []ChatMessage, error) {
-	// 打包工具参数
-	oa.rebuildToolParams()
+	return oa.StreamRunConversationEvents(ctx, modelName, history, handler.EventHandler())
+}
+
+// StreamRunConversationEvents 实现Agent接口的结构化事件流式对话方法
+func (oa *OpenAIAgent) StreamRunConversationEvents(
+	ctx context.Context,
+	modelName string,
+	history []ChatMessage,
+	handler StreamEventHandler,
+) (*TokenUsage, []ChatMessage, error) {
+	return oa.runConversation(ctx, modelName, history, handler, nil, nil)
+}
+
+// ResumeConversation 实现Agent接口的审批恢复方法，先按决定执行暂停时的工具调用，再继续对话循环
+func (oa *OpenAIAgent) ResumeConversation(
+	ctx context.Context,
+	pending *PendingApproval,
+	decisions []ApprovalDecision,
+	handler StreamEventHandler,
+) (*TokenUsage, []ChatMessage, error) {
+	if pending == nil {
+		return nil, nil, fmt.Errorf("待审批状态为空")
+	}
+	return oa.runConversation(ctx, pending.ModelName, pending.History, handler, pending, approvalDecisionMap(decisions))
+}
+
+// runConversation 对话循环，resume不为空时从审批暂停处继续
+func (oa *OpenAIAgent) runConversation(
+	ctx context.Context,
+	modelName string,
+	history []ChatMessage,
+	handler StreamEventHandler,
+	resume *PendingApproval,
+	decisions map[string]ApprovalDecision,
+) (_ *TokenUsage, _ []ChatMessage, err error) {
+	// 本次请求可用的工具，打包工具参数
+	oa.toolsMu.RLock()
+	tools := selectTools(ctx, oa.tools)
+	oa.toolsMu.RUnlock()
+	toolParams := oa.buildToolParams(tools)
+
+	// 按上下文预算裁剪历史，工具调用和对应的响应不会被拆开
+	// 暂停时保存的是裁剪后的历史，恢复时不再裁剪，避免重复总结
+	if resume == nil {
+		trimmed, err := oa.config.ContextPolicy.apply(ctx, history)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(trimmed) != len(history) {
+			oa.logger.DebugContext(ctx, "历史超出上下文预算，已裁剪", "messages", len(trimmed), "original", len(history))
+		}
+		history = trimmed
+	}
 
 	// 初始化token统计
 	tokenUsage := &TokenUsage{}
@@ -105,23 +180,75 @@ func (oa *OpenAIAgent) StreamRunConversation(
 
 	// 如果没有提供模型名称，使用默认值
 	if modelName == "" {
-		modelName = oa.config.ModelName
-		if modelName == "" {
-			modelName = "gpt-4o" // 默认模型
-		}
+		modelName = oa.DefaultModelName()
 	}
 
+	// 对话span，结束时记录累计token和错误
+	ctx, conv := oa.telemetry.startConversation(ctx, modelName)
+	defer func() { conv.end(tokenUsage, err) }()
+
 	// 创建消息数组，首先提取系统消息
 	var messages []openai.ChatCompletionMessageParamUnion
 	for _, msg := range history {
-		messages = append(messages, oa.convertMessage(msg))
+		messages = append(messages, oa.convertMessages(msg)...)
 	}
 
 	// 对话循环计数器
 	loopCount := 0
 
-	if oa.config.Debug {
-		PrintJSON("oa.toolParams", oa.toolParams)
+	// 执行一轮工具调用，把结果加入消息列表和对话历史
+	// 有工具需要审批时不执行任何工具，返回ApprovalRequiredError
+	runTools := func(calls []FunctionCall, decisions map[string]ApprovalDecision) error {
+		stage := &toolStage{
+			tools:       tools,
+			maxParallel: oa.config.MaxParallelToolCalls,
+			handler:     handler,
+			loop:        loopCount,
+			logger:      oa.logger,
+			errorPolicy: oa.config.ToolCallErrorPolicy,
+			middlewares: oa.config.ToolMiddlewares,
+			resultLimit: oa.config.ToolResultLimit,
+			telemetry:   oa.telemetry,
+		}
+		responses, pending, err := stage.run(ctx, calls, decisions)
+		if len(pending) > 0 {
+			approvalErr := newApprovalRequiredError(modelName, loopCount, history, conversationHistory, calls, pending, spentUsage(resume, tokenUsage))
+			oa.logger.InfoContext(ctx, "工具需要审批，对话暂停", "loop", loopCount, "pending", len(approvalErr.Pending.Requests))
+			handler.emit(StreamEvent{Type: EventApprovalRequired, Loop: loopCount, Approval: approvalErr.Pending})
+			return approvalErr
+		}
+
+		// OpenAI的ToolMessage只支持一个工具调用ID，每个响应单独一条消息
+		for _, funcResp := range responses {
+			toolResponseMsg := ChatMessage{
+				Role:              "tool", // 使用tool角色而不是user
+				FunctionResponses: []FunctionResponse{funcResp},
+			}
+			conversationHistory = append(conversationHistory, toolResponseMsg)
+			messages = append(messages, oa.convertMessage(toolResponseMsg))
+		}
+
+		// abort策略中止时每个调用都有错误响应，加入历史后再返回错误
+		if err != nil {
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return err
+		}
+		return nil
+	}
+
+	// 从审批暂停处恢复，先执行暂停时的工具调用
+	if resume != nil {
+		loopCount = resume.Loop
+		if err := runTools(resume.ToolCalls, decisions); err != nil {
+			return tokenUsage, conversationHistory, err
+		}
+
+		// 暂停前的token计入预算，超出时不再把工具结果发送给模型
+		if err := oa.config.Budget.check(modelName, loopCount, spentUsage(resume, tokenUsage), conversationHistory); err != nil {
+			oa.logger.WarnContext(ctx, "超出预算，对话停止", "loop", loopCount, "error", err)
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
 	}
 
 	// 对话循环
@@ -129,12 +256,14 @@ func (oa *OpenAIAgent) StreamRunConversation(
 		// 检查循环次数是否超过限制
 		loopCount++
 		if loopCount > oa.config.MaxLoops {
-			return tokenUsage, conversationHistory, fmt.Errorf("对话循环次数超过最大限制(%d)，可能存在递归", oa.config.MaxLoops)
+			err := &MaxLoopsError{MaxLoops: oa.config.MaxLoops}
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
 		}
 
 		// 频率限制：如果不是第一轮对话且启用了频率限制，则添加延迟
 		if loopCount > 1 && oa.config.EnableRateLimit && oa.config.RateLimitDelay > 0 {
-			oa.debugf("频率限制：等待 %d 毫秒后继续", oa.config.RateLimitDelay)
+			oa.logger.DebugContext(ctx, "频率限制等待", "delay_ms", oa.config.RateLimitDelay)
 			select {
 			case <-ctx.Done():
 				return tokenUsage, conversationHistory, ctx.Err()
@@ -143,19 +272,16 @@ func (oa *OpenAIAgent) StreamRunConversation(
 			}
 		}
 
-		oa.debugf("开始流式请求，模型=%s, 循环次数=%d/%d", modelName, loopCount, oa.config.MaxLoops)
-		if loopCount > 1 {
-			if oa.config.Debug {
-				PrintJSON("messages", messages)
-			}
-		}
+		ctx = conv.startLoop(ctx, loopCount)
+		handler.emit(StreamEvent{Type: EventLoopIteration, Loop: loopCount})
+		oa.logger.DebugContext(ctx, "开始流式请求", "model", modelName, "loop", loopCount, "max_loops", oa.config.MaxLoops, "messages", len(messages))
 
 		// 创建请求参数
 		params := openai.ChatCompletionNewParams{
 			Model:    modelName,
 			Messages: messages,
 			//Seed:     openai.Int(0),
-			Tools: oa.toolParams,
+			Tools: toolParams,
 			ToolChoice: openai.ChatCompletionToolChoiceOptionUnionParam{
 				OfAuto: param.NewOpt("auto"),
 			},
@@ -180,10 +306,20 @@ func (oa *OpenAIAgent) StreamRunConversation(
 			//指定使用哪些工具
 			if len(oa.config.FunctionCallingConfig.AllowedFunctionNames) > 0 {
 				//todo::
-				fmt.Println("openai 不支持指定多个工具")
+				oa.logger.WarnContext(ctx, "OpenAI不支持指定允许调用的工具，已忽略AllowedFunctionNames", "functions", oa.config.FunctionCallingConfig.AllowedFunctionNames)
 			}
 		}
 
+		// 推理强度
+		if oa.config.Thinking != nil && oa.config.Thinking.ReasoningEffort != "" {
+			params.ReasoningEffort = shared.ReasoningEffort(oa.config.Thinking.ReasoningEffort)
+		}
+
+		// 结构化输出
+		if rs := oa.config.ResponseSchema; rs != nil && len(rs.Schema) > 0 {
+			params.ResponseFormat = oa.responseFormat(rs)
+		}
+
 		// 设置最大回复token
 		if oa.config.MaxTokens > 0 {
 			params.MaxCompletionTokens = param.NewOpt(oa.config.MaxTokens)
@@ -199,71 +335,74 @@ func (oa *OpenAIAgent) StreamRunConversation(
 			params.TopP = param.NewOpt(oa.config.TopP)
 		}
 
-		// 创建流式请求
-		stream := oa.client.Chat.Completions.NewStreaming(ctx, params)
-
-		// 使用累加器处理流式响应
-		acc := openai.ChatCompletionAccumulator{}
-		toolCallReceived := false
-
-		// 处理流式响应
-		for stream.Next() {
-			chunk := stream.Current()
-
-			// 添加当前块到累加器
-			acc.AddChunk(chunk)
-
-			//文本完成
-			if _, ok := acc.JustFinishedContent(); ok {
-				println("finish-event: Content stream finished")
-			}
-
-			//AI拒绝回答的原因
-			if refusal, ok := acc.JustFinishedRefusal(); ok {
-				fmt.Println("AI 拒绝回答:", refusal)
-			}
-
-			// 检查是否有工具调用完成
-			if tool, ok := acc.JustFinishedToolCall(); ok {
-				toolCallReceived = true
-				fmt.Println("检测到完整工具调用:", tool.Index, tool.Name, tool.Arguments)
-			}
-
-			// 从chunk中提取文本内容并处理流式消息
-			if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
-				content := chunk.Choices[0].Delta.Content
-				// 调用处理函数
-				if handler != nil {
-					handler(content)
-				}
-			}
-		}
+		// 发起流式请求，临时错误按重试策略重新发起本轮请求
+		request := conv.startRequest(ctx, modelName, oa.config)
+		var turn *openAITurn
+		err := retryDo(ctx, oa.config.RetryPolicy, func() error {
+			var err error
+			turn, err = oa.streamTurn(ctx, params, handler, loopCount)
+			return err
+		}, func(attempt int, wait time.Duration, err error) {
+			oa.logger.WarnContext(ctx, "请求失败，等待重试", "loop", loopCount, "attempt", attempt, "wait", wait, "error", err)
+			request.retry(attempt, err)
+			handler.emit(StreamEvent{Type: EventRetry, Loop: loopCount, Attempt: attempt, Err: err})
+		})
 
 		// 检查流是否发生错误
-		if err := stream.Err(); err != nil {
-			return tokenUsage, conversationHistory, fmt.Errorf("流处理错误: %v", err)
+		if err != nil {
+			err = classifyError(fmt.Errorf("流处理错误: %w", err), loopCount)
+			request.end(nil, "", err)
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
 		}
 
+		acc := &turn.acc
+		toolCallReceived := turn.toolCallReceived
+
 		// 流结束后，获取完整响应
 		if len(acc.Choices) == 0 {
-			return tokenUsage, conversationHistory, fmt.Errorf("没有收到回复")
+			err := &StreamInterruptedError{Loop: loopCount, Err: errNoResponse}
+			request.end(nil, "", err)
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+
+		if finishReason := acc.Choices[0].FinishReason; finishReason != "" {
+			handler.emit(StreamEvent{Type: EventFinishReason, Loop: loopCount, FinishReason: finishReason})
 		}
 
 		// 更新Token使用情况
 		usage := acc.Usage
+		turnUsage := &TokenUsage{
+			TotalTokens:      int(usage.TotalTokens),
+			PromptTokens:     int(usage.PromptTokens),
+			CompletionTokens: int(usage.CompletionTokens),
+			CacheTokens:      int(turn.cachedTokens),
+			ReasoningTokens:  int(turn.reasoningTokens),
+		}
 
 		if usage.TotalTokens > 0 {
-			tokenUsage.TotalTokens += int(usage.TotalTokens)
-			tokenUsage.PromptTokens += int(usage.PromptTokens)
-			tokenUsage.CompletionTokens += int(usage.CompletionTokens)
-			tokenUsage.CacheTokens += int(usage.PromptTokensDetails.CachedTokens)
-			oa.debugf("Token使用情况 - 总计: %d, 提示词: %d, 完成: %d",
-				tokenUsage.TotalTokens, tokenUsage.PromptTokens, tokenUsage.CompletionTokens)
+			tokenUsage.Add(turnUsage)
+			oa.logger.DebugContext(ctx, "Token使用情况", "loop", loopCount,
+				"total_tokens", tokenUsage.TotalTokens, "prompt_tokens", tokenUsage.PromptTokens, "completion_tokens", tokenUsage.CompletionTokens)
+
+			usageCopy := *tokenUsage
+			handler.emit(StreamEvent{Type: EventUsage, Loop: loopCount, Usage: &usageCopy})
+		}
+		request.end(turnUsage, string(acc.Choices[0].FinishReason), nil)
+
+		// 回答被安全策略拦截
+		if err := contentBlocked(string(acc.Choices[0].FinishReason), "", loopCount, false); err != nil {
+			if content := acc.Choices[0].Message.Content; content != "" {
+				conversationHistory = append(conversationHistory, ChatMessage{Role: "assistant", Content: content})
+			}
+			handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+			return tokenUsage, conversationHistory, err
 		}
 
 		// 获取完整的助手消息
 		assistantMessage := acc.Choices[0].Message
-		oa.debugf("收到助手消息，内容: %s", assistantMessage.Content)
+		oa.logger.Log(ctx, LevelTrace, "收到助手消息", "loop", loopCount, "content", assistantMessage.Content)
 
 		// 将助手消息添加到对话中（OpenAI格式）
 		assistantParam := assistantMessage.ToParam()
@@ -278,92 +417,134 @@ func (oa *OpenAIAgent) StreamRunConversation(
 		// 处理工具调用
 		toolCalls := assistantMessage.ToolCalls
 		if toolCallReceived && len(toolCalls) > 0 {
-			oa.debugf("收到助手消息，包含 %d 个工具调用", len(toolCalls))
+			oa.logger.DebugContext(ctx, "收到工具调用", "loop", loopCount, "tool_calls", len(toolCalls))
 
 			// 添加工具调用到通用消息格式
 			assistantChatMsg.ToolCalls = oa.convertOpenAIToolCallsToToolCalls(toolCalls)
 
+			// 超出预算时不再执行本轮的工具
+			if stopped, err := oa.config.Budget.checkBeforeTools(modelName, loopCount, spentUsage(resume, tokenUsage), conversationHistory, assistantChatMsg); err != nil {
+				oa.logger.WarnContext(ctx, "超出预算，对话停止", "loop", loopCount, "error", err)
+				handler.emit(StreamEvent{Type: EventError, Loop: loopCount, Err: err})
+				return tokenUsage, stopped, err
+			}
+
 			// 添加助手消息到对话历史
 			conversationHistory = append(conversationHistory, assistantChatMsg)
 
-			// 处理所有工具调用
-			allToolsHandled := true
+			if err := runTools(assistantChatMsg.ToolCalls, nil); err != nil {
+				return tokenUsage, conversationHistory, err
+			}
 
-			for i, toolCall := range assistantMessage.ToolCalls {
-				oa.debugf("工具调用 #%d:", i+1)
-				oa.debugf("  ID: %s", toolCall.ID)
-				oa.debugf("  名称: %s", toolCall.Function.Name)
-				oa.debugf("  参数: %s", toolCall.Function.Arguments)
+			// 继续对话
+			continue
+		} else {
+			// 没有工具调用，添加普通助手消息到对话历史
+			conversationHistory = append(conversationHistory, assistantChatMsg)
 
-				// 处理ID为空的情况
-				callID := toolCall.ID
-				if callID == "" {
-					callID = fmt.Sprintf("auto_id_%d", i)
-					oa.debugf("工具调用ID为空，自动生成ID: %s", callID)
-				}
+			// 返回响应内容
+			oa.logger.DebugContext(ctx, "对话结束", "loops", loopCount, "total_tokens", tokenUsage.TotalTokens)
+			return tokenUsage, conversationHistory, nil
+		}
+	}
+}
 
-				// 查找工具
-				tool, exists := oa.tools[toolCall.Function.Name]
-				if !exists {
-					oa.debugf("未找到工具: %s", toolCall.Function.Name)
-					allToolsHandled = false
-					continue
-				}
+// openAITurn 一轮流式请求的结果
+type openAITurn struct {
+	acc              openai.ChatCompletionAccumulator // 累加后的完整响应
+	toolCallReceived bool                             // 是否收到完整的工具调用
+	cachedTokens     int64                            // 缓存命中token，累加器不统计明细
+	reasoningTokens  int64                            // 推理token，累加器不统计明细
+}
 
-				// 解析参数
-				var args map[string]interface{}
-				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-					oa.debugf("参数解析错误: %v", err)
-					allToolsHandled = false
-					continue
-				}
+// streamTurn 发起一轮流式请求并处理响应
+func (oa *OpenAIAgent) streamTurn(
+	ctx context.Context,
+	params openai.ChatCompletionNewParams,
+	handler StreamEventHandler,
+	loopCount int,
+) (*openAITurn, error) {
+	// 创建流式请求
+	stream := oa.client.Chat.Completions.NewStreaming(ctx, params)
+
+	// 使用累加器处理流式响应
+	turn := &openAITurn{}
+	acc := &turn.acc
+	// 按index记录流式工具调用，用于推送开始和参数增量事件
+	streamingToolCalls := map[int64]*FunctionCall{}
+
+	// 处理流式响应
+	for stream.Next() {
+		chunk := stream.Current()
+
+		// 添加当前块到累加器
+		acc.AddChunk(chunk)
+
+		// 累加器只统计token总数，明细从usage块中读取
+		if chunk.Usage.TotalTokens > 0 {
+			turn.cachedTokens += chunk.Usage.PromptTokensDetails.CachedTokens
+			turn.reasoningTokens += chunk.Usage.CompletionTokensDetails.ReasoningTokens
+		}
 
-				// 执行工具
-				result, err := tool.Handler(args)
-				if err != nil {
-					oa.debugf("工具执行错误: %v", err)
-					// 如果需要，可以将错误消息返回给模型
-					result = fmt.Sprintf("执行错误: %v", err)
-				}
+		//文本完成
+		if _, ok := acc.JustFinishedContent(); ok {
+			oa.logger.DebugContext(ctx, "文本流结束", "loop", loopCount)
+		}
 
-				oa.debugf("工具执行结果: %s", result)
+		//AI拒绝回答的原因
+		if refusal, ok := acc.JustFinishedRefusal(); ok {
+			oa.logger.WarnContext(ctx, "模型拒绝回答", "loop", loopCount, "refusal", refusal)
+		}
 
-				// 将工具响应添加到OpenAI对话
-				toolMsg := openai.ToolMessage(result, callID)
-				messages = append(messages, toolMsg)
+		// 检查是否有工具调用完成
+		if tool, ok := acc.JustFinishedToolCall(); ok {
+			turn.toolCallReceived = true
+			oa.logger.DebugContext(ctx, "检测到完整工具调用", "loop", loopCount, "index", tool.Index, "tool", tool.Name)
+		}
 
-				// 创建工具响应消息
-				toolResponseMsg := ChatMessage{
-					Role: "tool", // 使用tool角色而不是user
-				}
-				// 将工具响应添加到通用消息格式
-				functionResponse := FunctionResponse{
-					ID:     callID,
-					Name:   toolCall.Function.Name,
-					Result: map[string]any{"output": result},
-				}
-				toolResponseMsg.FunctionResponses = append(toolResponseMsg.FunctionResponses, functionResponse)
-				conversationHistory = append(conversationHistory, toolResponseMsg)
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		// 兼容OpenAI格式的推理模型(如DeepSeek)通过reasoning_content返回思考内容，单独推送
+		if field, ok := chunk.Choices[0].Delta.JSON.ExtraFields["reasoning_content"]; ok {
+			var reasoning string
+			if err := json.Unmarshal([]byte(field.Raw()), &reasoning); err == nil && reasoning != "" {
+				handler.emit(StreamEvent{Type: EventThinkingDelta, Loop: loopCount, Text: reasoning})
 			}
+		}
 
-			// 添加工具响应消息到对话历史
+		// 从chunk中提取文本内容并处理流式消息
+		if content := chunk.Choices[0].Delta.Content; content != "" {
+			handler.emit(StreamEvent{Type: EventTextDelta, Loop: loopCount, Text: content})
+		}
 
-			// 如果有工具调用失败，可以选择是否继续对话
-			if !allToolsHandled {
-				oa.debugf("部分工具调用失败，但继续对话")
+		// 工具调用增量：首次出现推送开始事件，之后推送参数片段
+		for _, delta := range chunk.Choices[0].Delta.ToolCalls {
+			toolCall, ok := streamingToolCalls[delta.Index]
+			if !ok {
+				toolCall = &FunctionCall{ID: delta.ID, Name: delta.Function.Name}
+				streamingToolCalls[delta.Index] = toolCall
+				handler.emit(StreamEvent{Type: EventToolCallStarted, Loop: loopCount, ToolCall: toolCall})
 			}
+			if delta.Function.Arguments != "" {
+				handler.emit(StreamEvent{Type: EventToolCallArgsDelta, Loop: loopCount, ToolCall: toolCall, ArgsDelta: delta.Function.Arguments})
+			}
+		}
+	}
 
-			// 继续对话
-			continue
-		} else {
-			// 没有工具调用，添加普通助手消息到对话历史
-			conversationHistory = append(conversationHistory, assistantChatMsg)
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return turn, nil
+}
 
-			// 返回响应内容
-			oa.debugf("对话结束，返回Token统计: %+v", tokenUsage)
-			return tokenUsage, conversationHistory, nil
-		}
+// DefaultModelName 未指定模型时使用的模型名称
+func (oa *OpenAIAgent) DefaultModelName() string {
+	if oa.config.ModelName != "" {
+		return oa.config.ModelName
 	}
+	return "gpt-4o" // 默认模型
 }
 
 // RegisterTool 注册一个工具
@@ -377,6 +558,8 @@ func (oa *OpenAIAgent) RegisterTool(function FunctionDefinitionParam, handler To
 	}
 
 	// 保存工具
+	oa.toolsMu.Lock()
+	defer oa.toolsMu.Unlock()
 	oa.tools[function.Name] = Tool{
 		Function: function,
 		Handler:  handler,
@@ -385,16 +568,57 @@ func (oa *OpenAIAgent) RegisterTool(function FunctionDefinitionParam, handler To
 	return nil
 }
 
+// RegisterContextTool 注册一个带上下文的工具
+func (oa *OpenAIAgent) RegisterContextTool(function FunctionDefinitionParam, handler ContextToolFunction, options ToolOptions) error {
+	if function.Name == "" {
+		return fmt.Errorf("工具名称不能为空")
+	}
+
+	if handler == nil {
+		return fmt.Errorf("工具处理函数不能为空")
+	}
+
+	// 保存工具
+	oa.toolsMu.Lock()
+	defer oa.toolsMu.Unlock()
+	oa.tools[function.Name] = Tool{
+		Function:       function,
+		ContextHandler: handler,
+		Options:        options,
+	}
+
+	return nil
+}
+
 // SetDebug 设置调试模式
 func (oa *OpenAIAgent) SetDebug(debug bool) {
 	oa.config.Debug = debug
+	oa.logger = newLogger(oa.config, "openai")
 }
 
-// 重建工具参数
-func (oa *OpenAIAgent) rebuildToolParams() {
-	oa.toolParams = []openai.ChatCompletionToolParam{}
+// UnregisterTool 注销工具，不影响进行中的对话
+func (oa *OpenAIAgent) UnregisterTool(name string) error {
+	oa.toolsMu.Lock()
+	defer oa.toolsMu.Unlock()
+	if _, ok := oa.tools[name]; !ok {
+		return &ToolNotFoundError{Name: name}
+	}
+	delete(oa.tools, name)
+	return nil
+}
 
-	for _, tool := range oa.tools {
+// ListTools 列出已注册工具的定义，按名称排序
+func (oa *OpenAIAgent) ListTools() []FunctionDefinitionParam {
+	oa.toolsMu.RLock()
+	defer oa.toolsMu.RUnlock()
+	return toolDefinitions(oa.tools)
+}
+
+// 构建工具参数
+func (oa *OpenAIAgent) buildToolParams(tools map[string]Tool) []openai.ChatCompletionToolParam {
+	toolParams := []openai.ChatCompletionToolParam{}
+
+	for _, tool := range sortedTools(tools) {
 		// 把agent.FunctionDefinitionParam转换为openai.FunctionDefinitionParam
 		functionDef := openai.FunctionDefinitionParam{
 			Name:        tool.Function.Name,
@@ -408,26 +632,69 @@ func (oa *OpenAIAgent) rebuildToolParams() {
 			Function: functionDef,
 		}
 
-		oa.toolParams = append(oa.toolParams, toolParam)
+		toolParams = append(toolParams, toolParam)
+	}
+
+	if oa.logger.Enabled(context.Background(), slog.LevelDebug) {
+		names := make([]string, 0, len(toolParams))
+		for _, param := range toolParams {
+			names = append(names, param.Function.Name)
+		}
+		oa.logger.Debug("工具参数构建完成", "tools", names)
 	}
+	return toolParams
+}
+
+// responseFormat 把结构化输出配置转换为json_schema响应格式
+func (oa *OpenAIAgent) responseFormat(rs *ResponseSchema) openai.ChatCompletionNewParamsResponseFormatUnion {
+	name := rs.Name
+	if name == "" {
+		name = "response"
+	}
+
+	jsonSchema := shared.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:   name,
+		Schema: rs.Schema,
+	}
+	if rs.Description != "" {
+		jsonSchema.Description = param.NewOpt(rs.Description)
+	}
+	if rs.Strict {
+		jsonSchema.Strict = param.NewOpt(true)
+	}
+
+	return openai.ChatCompletionNewParamsResponseFormatUnion{
+		OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{JSONSchema: jsonSchema},
+	}
+}
 
-	// 打印调试信息
-	if oa.config.Debug {
-		oa.debugf("工具参数构建完成: %d 个工具", len(oa.toolParams))
-		for i, param := range oa.toolParams {
-			oa.debugf("工具 #%d: %s", i, param.Function.Name)
+// convertMessages 转换一条通用消息
+// 一条工具消息包含多个函数响应时(如Gemini产生的历史)拆分为多条ToolMessage
+func (oa *OpenAIAgent) convertMessages(msg ChatMessage) []openai.ChatCompletionMessageParamUnion {
+	if msg.Role == "tool" && len(msg.FunctionResponses) > 1 {
+		messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(msg.FunctionResponses))
+		for _, funcResp := range msg.FunctionResponses {
+			messages = append(messages, oa.convertMessage(ChatMessage{
+				Role:              "tool",
+				FunctionResponses: []FunctionResponse{funcResp},
+			}))
 		}
+		return messages
 	}
+	return []openai.ChatCompletionMessageParamUnion{oa.convertMessage(msg)}
 }
 
 // convertMessage 转换消息角色和内容
 func (oa *OpenAIAgent) convertMessage(msg ChatMessage) openai.ChatCompletionMessageParamUnion {
 	switch msg.Role {
 	case "system":
-		return openai.SystemMessage(msg.Content)
+		return openai.SystemMessage(msg.textContent())
 
 	case "user":
-		// 用户消息
+		// 用户消息，包含多模态内容时使用内容片段数组
+		if len(msg.Parts) > 0 {
+			return openai.UserMessage(oa.convertContentParts(msg))
+		}
 		return openai.UserMessage(msg.Content)
 
 	case "assistant":
@@ -435,7 +702,7 @@ func (oa *OpenAIAgent) convertMessage(msg ChatMessage) openai.ChatCompletionMess
 		if len(msg.ToolCalls) > 0 {
 			// 正确处理带工具调用的助手消息
 			var assistant openai.ChatCompletionAssistantMessageParam
-			assistant.Content.OfString = param.NewOpt(msg.Content)
+			assistant.Content.OfString = param.NewOpt(msg.textContent())
 
 			// 转换所有工具调用
 			assistant.ToolCalls = make([]openai.ChatCompletionMessageToolCallParam, len(msg.ToolCalls))
@@ -446,10 +713,14 @@ func (oa *OpenAIAgent) convertMessage(msg ChatMessage) openai.ChatCompletionMess
 					Function: openai.ChatCompletionMessageToolCallFunctionParam{
 						Name: toolCall.Name,
 						Arguments: func() string {
+							// 参数不是合法JSON时原样发回，保持与模型的输出一致
+							if toolCall.RawArgs != "" {
+								return toolCall.RawArgs
+							}
 							// 将参数转回JSON字符串
 							argsBytes, err := json.Marshal(toolCall.Args)
 							if err != nil {
-								oa.debugf("转换工具调用参数错误: %v", err)
+								oa.logger.Warn("转换工具调用参数错误", "tool", toolCall.Name, "error", err)
 								return "{}"
 							}
 							return string(argsBytes)
@@ -461,17 +732,18 @@ func (oa *OpenAIAgent) convertMessage(msg ChatMessage) openai.ChatCompletionMess
 			return openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant}
 		}
 
-		// 普通助手消息
-		return openai.AssistantMessage(msg.Content)
+		// 普通助手消息，OpenAI的助手消息只支持文本
+		return openai.AssistantMessage(msg.textContent())
 
 	case "tool":
 		// 工具响应消息
 		if len(msg.FunctionResponses) > 0 {
 			// 只处理第一个函数响应，因为OpenAI的ToolMessage只支持一个工具调用ID和内容
+			// 包含多个函数响应的消息由convertMessages拆分
 			funcResp := msg.FunctionResponses[0]
 
 			var content string
-			if output, ok := funcResp.Result["output"]; ok {
+			if output, ok := funcResp.Result["output"]; ok && funcResp.Result["error"] != true {
 				content = fmt.Sprintf("%v", output)
 			} else {
 				outputJSON, _ := json.Marshal(funcResp.Result)
@@ -486,14 +758,64 @@ func (oa *OpenAIAgent) convertMessage(msg ChatMessage) openai.ChatCompletionMess
 
 	default:
 		// 默认作为用户消息处理
+		if len(msg.Parts) > 0 {
+			return openai.UserMessage(oa.convertContentParts(msg))
+		}
 		return openai.UserMessage(msg.Content)
 	}
 }
 
-// debugf 调试输出，统一处理所有调试信息
-func (oa *OpenAIAgent) debugf(format string, args ...interface{}) {
-	if oa.config.Debug {
-		fmt.Printf("【OpenAI】"+format+"\n", args...)
+// convertContentParts 转换用户消息的文本和多模态内容片段
+func (oa *OpenAIAgent) convertContentParts(msg ChatMessage) []openai.ChatCompletionContentPartUnionParam {
+	var parts []openai.ChatCompletionContentPartUnionParam
+	for _, part := range msg.contentParts() {
+		switch part.Type {
+		case PartText:
+			parts = append(parts, openai.TextContentPart(part.Text))
+
+		case PartImage:
+			url := part.URL
+			if len(part.Data) > 0 {
+				url = part.dataURL()
+			}
+			parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: url}))
+
+		case PartAudio:
+			if len(part.Data) == 0 {
+				oa.logger.Warn("OpenAI只支持内联音频，忽略音频引用", "url", part.URL)
+				continue
+			}
+			parts = append(parts, openai.InputAudioContentPart(openai.ChatCompletionContentPartInputAudioInputAudioParam{
+				Data:   base64.StdEncoding.EncodeToString(part.Data),
+				Format: audioFormat(part.MIMEType),
+			}))
+
+		case PartFile:
+			var file openai.ChatCompletionContentPartFileFileParam
+			if len(part.Data) > 0 {
+				file.FileData = param.NewOpt(part.dataURL())
+				if part.FileName != "" {
+					file.Filename = param.NewOpt(part.FileName)
+				}
+			} else {
+				file.FileID = param.NewOpt(part.URL)
+			}
+			parts = append(parts, openai.FileContentPart(file))
+
+		default:
+			oa.logger.Warn("忽略未知的内容片段类型", "type", part.Type)
+		}
+	}
+	return parts
+}
+
+// audioFormat 根据MIME类型返回OpenAI音频格式，只支持wav和mp3
+func audioFormat(mimeType string) string {
+	switch mimeType {
+	case "audio/mpeg", "audio/mp3":
+		return "mp3"
+	default:
+		return "wav"
 	}
 }
 
@@ -502,19 +824,33 @@ func (oa *OpenAIAgent) convertOpenAIToolCallsToToolCalls(toolCalls []openai.Chat
 	functionCalls := make([]FunctionCall, 0, len(toolCalls))
 
 	// 添加工具调用到通用消息格式
-	for _, toolCall := range toolCalls {
-		// 解析参数
+	for i, toolCall := range toolCalls {
+		oa.logger.Log(context.Background(), LevelTrace, "工具调用", "id", toolCall.ID, "tool", toolCall.Function.Name, "arguments", toolCall.Function.Arguments)
+
+		// 解析参数，解析失败时保留原始参数，由工具执行阶段把错误返回给模型
 		var args map[string]interface{}
-		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-			oa.debugf("参数解析错误: %v", err)
-			continue
+		var rawArgs string
+		if arguments := strings.TrimSpace(toolCall.Function.Arguments); arguments != "" {
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				oa.logger.Warn("工具调用参数解析错误", "tool", toolCall.Function.Name, "error", err)
+				args = nil
+				rawArgs = toolCall.Function.Arguments
+			}
+		}
+
+		// 处理ID为空的情况
+		callID := toolCall.ID
+		if callID == "" {
+			callID = fmt.Sprintf("auto_id_%d", i)
+			oa.logger.Debug("工具调用ID为空，自动生成ID", "tool", toolCall.Function.Name, "id", callID)
 		}
 
 		// 将OpenAI的工具调用转换为通用格式
 		functionCall := FunctionCall{
-			ID:   toolCall.ID,
-			Name: toolCall.Function.Name,
-			Args: args,
+			ID:      callID,
+			Name:    toolCall.Function.Name,
+			Args:    args,
+			RawArgs: rawArgs,
 		}
 
 		functionCalls = append(functionCalls, functionCall)
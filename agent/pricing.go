@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ModelPrice 模型价格，单位为每百万token的价格
+type ModelPrice struct {
+	Input       float64 `json:"input"`                  // 输入价格
+	Output      float64 `json:"output"`                 // 输出价格
+	CachedInput float64 `json:"cached_input,omitempty"` // 缓存命中的输入价格，为0时按输入价格计算
+	CacheWrite  float64 `json:"cache_write,omitempty"`  // 写入缓存的输入价格，为0时按输入价格计算；Anthropic为输入价格的1.25倍
+	Reasoning   float64 `json:"reasoning,omitempty"`    // 思考/推理价格，为0时按输出价格计算
+}
+
+// PricingTable 价格表，按模型名称索引
+// 模型名称找不到时按最长前缀匹配，如gpt-4o可匹配gpt-4o-2024-08-06
+type PricingTable struct {
+	Currency string                `json:"currency,omitempty"` // 货币单位，如USD
+	Models   map[string]ModelPrice `json:"models"`             // 模型价格
+}
+
+// LoadPricingTable 从JSON文件加载价格表
+func LoadPricingTable(path string) (*PricingTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取价格表错误: %w", err)
+	}
+	return ParsePricingTable(data)
+}
+
+// ParsePricingTable 解析JSON格式的价格表
+func ParsePricingTable(data []byte) (*PricingTable, error) {
+	table := &PricingTable{}
+	if err := json.Unmarshal(data, table); err != nil {
+		return nil, fmt.Errorf("解析价格表错误: %w", err)
+	}
+	return table, nil
+}
+
+// Price 查找模型价格
+func (t *PricingTable) Price(modelName string) (ModelPrice, bool) {
+	if t == nil {
+		return ModelPrice{}, false
+	}
+	if price, ok := t.Models[modelName]; ok {
+		return price, true
+	}
+
+	best := ""
+	for name := range t.Models {
+		if strings.HasPrefix(modelName, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return t.Models[best], true
+}
+
+// CostBreakdown 费用明细
+type CostBreakdown struct {
+	Currency        string  `json:"currency,omitempty"`      // 货币单位
+	InputCost       float64 `json:"input_cost"`              // 未命中缓存的输入费用
+	CachedInputCost float64 `json:"cached_input_cost"`       // 缓存命中的输入费用
+	CacheWriteCost  float64 `json:"cache_write_cost"`        // 写入缓存的输入费用
+	OutputCost      float64 `json:"output_cost"`             // 输出费用(不含思考)
+	ReasoningCost   float64 `json:"reasoning_cost"`          // 思考/推理费用
+	TotalCost       float64 `json:"total_cost"`              // 总费用
+	MissingPrice    bool    `json:"missing_price,omitempty"` // 有模型不在价格表中，其费用未计入
+}
+
+// Cost 计算一次对话的费用，模型不在价格表中时返回false
+func (t *PricingTable) Cost(modelName string, usage *TokenUsage) (*CostBreakdown, bool) {
+	price, ok := t.Price(modelName)
+	if !ok {
+		return &CostBreakdown{Currency: t.currency(), MissingPrice: true}, false
+	}
+	if usage == nil {
+		usage = &TokenUsage{}
+	}
+
+	cachedPrice := price.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = price.Input
+	}
+	cacheWritePrice := price.CacheWrite
+	if cacheWritePrice == 0 {
+		cacheWritePrice = price.Input
+	}
+	reasoningPrice := price.Reasoning
+	if reasoningPrice == 0 {
+		reasoningPrice = price.Output
+	}
+
+	// 缓存命中和写入缓存的token包含在提示词token中，思考token包含在完成token中
+	const perMillion = 1_000_000
+	cost := &CostBreakdown{
+		Currency:        t.currency(),
+		InputCost:       float64(usage.PromptTokens-usage.CacheTokens-usage.CacheWriteTokens) * price.Input / perMillion,
+		CachedInputCost: float64(usage.CacheTokens) * cachedPrice / perMillion,
+		CacheWriteCost:  float64(usage.CacheWriteTokens) * cacheWritePrice / perMillion,
+		OutputCost:      float64(usage.CompletionTokens-usage.ReasoningTokens) * price.Output / perMillion,
+		ReasoningCost:   float64(usage.ReasoningTokens) * reasoningPrice / perMillion,
+	}
+	cost.TotalCost = cost.InputCost + cost.CachedInputCost + cost.CacheWriteCost + cost.OutputCost + cost.ReasoningCost
+	return cost, true
+}
+
+func (t *PricingTable) currency() string {
+	if t == nil {
+		return ""
+	}
+	return t.Currency
+}
+
+// Add 累加另一份费用明细
+func (c *CostBreakdown) Add(other *CostBreakdown) {
+	if other == nil {
+		return
+	}
+	if c.Currency == "" {
+		c.Currency = other.Currency
+	}
+	c.InputCost += other.InputCost
+	c.CachedInputCost += other.CachedInputCost
+	c.CacheWriteCost += other.CacheWriteCost
+	c.OutputCost += other.OutputCost
+	c.ReasoningCost += other.ReasoningCost
+	c.TotalCost += other.TotalCost
+	c.MissingPrice = c.MissingPrice || other.MissingPrice
+}
+
+// UsageTotal 某个agent和模型的累计使用统计
+type UsageTotal struct {
+	AgentName AgentName  `json:"agent_name"` // agent名称
+	ModelName string     `json:"model_name"` // 模型名称
+	Requests  int        `json:"requests"`   // 对话次数(包括失败的尝试)
+	Usage     TokenUsage `json:"usage"`      // 累计token
+	Cost      float64    `json:"cost"`       // 累计费用，未设置价格表或模型不在价格表中时为0
+}
+
+// usageKey 累计统计的索引
+type usageKey struct {
+	agentName AgentName
+	modelName string
+}
+
+// SetPricingTable 设置价格表，设置后对话结果包含费用明细
+func (s *AgentService) SetPricingTable(table *PricingTable) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pricing = table
+}
+
+// recordUsage 记录一次对话的使用统计，返回本次费用，未设置价格表时返回nil
+func (s *AgentService) recordUsage(agentName AgentName, modelName string, usage *TokenUsage) *CostBreakdown {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var cost *CostBreakdown
+	if s.pricing != nil {
+		cost, _ = s.pricing.Cost(modelName, usage)
+	}
+
+	key := usageKey{agentName: agentName, modelName: modelName}
+	if s.usageTotals == nil {
+		s.usageTotals = make(map[usageKey]*UsageTotal)
+	}
+	total, ok := s.usageTotals[key]
+	if !ok {
+		total = &UsageTotal{AgentName: agentName, ModelName: modelName}
+		s.usageTotals[key] = total
+	}
+	total.Requests++
+	total.Usage.Add(usage)
+	if cost != nil {
+		total.Cost += cost.TotalCost
+	}
+	return cost
+}
+
+// UsageTotals 返回所有agent和模型的累计使用统计，按agent和模型名称排序
+func (s *AgentService) UsageTotals() []UsageTotal {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	totals := make([]UsageTotal, 0, len(s.usageTotals))
+	for _, total := range s.usageTotals {
+		totals = append(totals, *total)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].AgentName != totals[j].AgentName {
+			return totals[i].AgentName < totals[j].AgentName
+		}
+		return totals[i].ModelName < totals[j].ModelName
+	})
+	return totals
+}
+
+// UsageTotalFor 返回指定agent和模型的累计使用统计
+func (s *AgentService) UsageTotalFor(agentName AgentName, modelName string) UsageTotal {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if total, ok := s.usageTotals[usageKey{agentName: agentName, modelName: modelName}]; ok {
+		return *total
+	}
+	return UsageTotal{AgentName: agentName, ModelName: modelName}
+}
+
+// ResetUsageTotals 清空累计使用统计
+func (s *AgentService) ResetUsageTotals() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.usageTotals = nil
+}
+
+// defaultModelNamer 可以返回默认模型名称的agent
+type defaultModelNamer interface {
+	DefaultModelName() string
+}
+
+// resolveModelName 模型名称为空时使用agent的默认模型
+func resolveModelName(agent Agent, modelName string) string {
+	if modelName != "" {
+		return modelName
+	}
+	if namer, ok := agent.(defaultModelNamer); ok {
+		return namer.DefaultModelName()
+	}
+	return modelName
+}
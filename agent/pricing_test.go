@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPricingTableCost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	data := `{"currency":"USD","models":{
+		"gpt-4o":{"input":2.5,"output":10,"cached_input":1.25},
+		"gpt-4o-mini":{"input":0.15,"output":0.6},
+		"o3":{"input":2,"output":8,"reasoning":8}
+	}}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadPricingTable(path)
+	if err != nil {
+		t.Fatalf("加载价格表失败: %v", err)
+	}
+
+	// 最长前缀匹配
+	if price, ok := table.Price("gpt-4o-mini-2024-07-18"); !ok || price.Input != 0.15 {
+		t.Errorf("前缀匹配错误: %+v, %v", price, ok)
+	}
+	if _, ok := table.Price("claude-3"); ok {
+		t.Error("不在价格表中的模型不应匹配")
+	}
+
+	usage := &TokenUsage{PromptTokens: 1_000_000, CacheTokens: 400_000, CompletionTokens: 300_000, ReasoningTokens: 100_000}
+	cost, ok := table.Cost("gpt-4o-2024-08-06", usage)
+	if !ok {
+		t.Fatal("应找到价格")
+	}
+	// 输入60万*2.5 + 缓存40万*1.25 + 输出20万*10 + 思考10万*10(按输出价格)
+	if !almostEqual(cost.InputCost, 1.5) || !almostEqual(cost.CachedInputCost, 0.5) ||
+		!almostEqual(cost.OutputCost, 2) || !almostEqual(cost.ReasoningCost, 1) || !almostEqual(cost.TotalCost, 5) {
+		t.Errorf("费用计算错误: %+v", cost)
+	}
+	if cost.Currency != "USD" {
+		t.Errorf("货币单位错误: %q", cost.Currency)
+	}
+
+	if cost, ok := table.Cost("claude-3", usage); ok || !cost.MissingPrice || cost.TotalCost != 0 {
+		t.Errorf("缺少价格时结果错误: %+v", cost)
+	}
+
+	if _, err := ParsePricingTable([]byte("{")); err == nil {
+		t.Error("非法JSON应返回错误")
+	}
+}
+
+// 测试AgentService按agent和模型累计使用统计和费用
+func TestAgentServiceUsageTotals(t *testing.T) {
+	ctx := context.Background()
+	service := NewAgentService(ctx)
+	service.RegisterAgent(Gemini, &sessionTestAgent{})
+	service.SetPricingTable(&PricingTable{Models: map[string]ModelPrice{"model": {Input: 1_000_000, Output: 1_000_000}}})
+
+	history := []ChatMessage{{Role: "user", Content: "你好"}}
+	for i := 0; i < 2; i++ {
+		if _, _, err := service.StreamRunConversationEvents(ctx, Gemini, "model", history, nil); err != nil {
+			t.Fatalf("对话失败: %v", err)
+		}
+	}
+	result, err := service.StreamRunConversationWithFallback(ctx, Gemini, "model", []ChatMessage{{Role: "user", Content: "出错"}}, nil)
+	if err == nil {
+		t.Fatal("应返回错误")
+	}
+	// sessionTestAgent每次只返回TotalTokens，费用为0但仍计入请求次数
+	if result.Cost == nil || result.Cost.TotalCost != 0 {
+		t.Errorf("对话结果费用错误: %+v", result.Cost)
+	}
+
+	total := service.UsageTotalFor(Gemini, "model")
+	if total.Requests != 3 || total.Usage.TotalTokens != 3 {
+		t.Errorf("累计统计错误: %+v", total)
+	}
+	if totals := service.UsageTotals(); len(totals) != 1 {
+		t.Errorf("累计统计条数错误: %+v", totals)
+	}
+
+	service.ResetUsageTotals()
+	if total := service.UsageTotalFor(Gemini, "model"); total.Requests != 0 {
+		t.Errorf("清空后仍有统计: %+v", total)
+	}
+}
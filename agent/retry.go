@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// 默认可重试的HTTP状态码：请求超时、频率限制和服务端临时错误，529为Anthropic服务过载
+var defaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504, 529}
+
+// RetryPolicy 供应商临时错误的重试策略
+// 只重新发起失败的那一轮模型请求，已经执行过的工具不会重复执行
+type RetryPolicy struct {
+	MaxAttempts          int           // 最大尝试次数(包含第一次)，小于等于1不重试
+	InitialBackoff       time.Duration // 首次重试前的等待时间，默认500毫秒
+	MaxBackoff           time.Duration // 单次等待时间上限，默认30秒
+	Multiplier           float64       // 每次重试等待时间的倍数，默认2
+	Jitter               float64       // 随机抖动比例(0-1)，等待时间在 ±Jitter 范围内浮动，默认0.2
+	RetryableStatusCodes []int         // 可重试的HTTP状态码，默认408、429、500、502、503、504、529
+	NoRetryNetworkErrors bool          // 不重试连接重置、意外断流等网络错误
+}
+
+// backoff 计算第attempt次重试前的等待时间，attempt从1开始
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 500 * time.Millisecond
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	jitter := p.Jitter
+	if jitter <= 0 || jitter > 1 {
+		jitter = 0.2
+	}
+
+	wait := float64(initial)
+	for i := 1; i < attempt; i++ {
+		wait *= multiplier
+		if wait >= float64(maxBackoff) {
+			wait = float64(maxBackoff)
+			break
+		}
+	}
+	wait += wait * jitter * (rand.Float64()*2 - 1)
+	if wait > float64(maxBackoff) {
+		wait = float64(maxBackoff)
+	}
+	return time.Duration(wait)
+}
+
+// retryable 判断错误是否可以重试
+func (p *RetryPolicy) retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if code := statusCodeOf(err); code != 0 {
+		codes := p.RetryableStatusCodes
+		if len(codes) == 0 {
+			codes = defaultRetryableStatusCodes
+		}
+		for _, c := range codes {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+
+	return !p.NoRetryNetworkErrors && isNetworkError(err)
+}
+
+// isNetworkError 判断是否为超时、连接被拒绝、连接重置、意外断流等临时网络错误
+func isNetworkError(err error) bool {
+	// TLS握手、证书等错误也是net.Error，属于永久错误，只重试超时
+	var netErr net.Error
+	return (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// statusCodeOf 从供应商SDK的错误中提取HTTP状态码，无法识别时返回0
+func statusCodeOf(err error) int {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
+	}
+	var geminiErr genai.APIError
+	if errors.As(err, &geminiErr) {
+		return geminiErr.Code
+	}
+	var geminiErrPtr *genai.APIError
+	if errors.As(err, &geminiErrPtr) && geminiErrPtr != nil {
+		return geminiErrPtr.Code
+	}
+	var anthropicErr *AnthropicError
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode
+	}
+	return 0
+}
+
+// retryDo 按重试策略执行fn，遇到可重试错误时等待后重新执行
+// onRetry 在每次重试等待前调用，可用于推送事件或打印日志
+func retryDo(ctx context.Context, policy *RetryPolicy, fn func() error, onRetry func(attempt int, wait time.Duration, err error)) error {
+	err := fn()
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return err
+	}
+
+	for attempt := 1; attempt < policy.MaxAttempts && policy.retryable(err); attempt++ {
+		wait := policy.backoff(attempt)
+		if onRetry != nil {
+			onRetry(attempt, wait, err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// 测试可重试错误的判断
+func TestRetryPolicyRetryable(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"openai 429", fmt.Errorf("流处理错误: %w", &openai.Error{StatusCode: 429}), true},
+		{"openai 400", &openai.Error{StatusCode: 400}, false},
+		{"gemini 503", genai.APIError{Code: 503}, true},
+		{"gemini 401", genai.APIError{Code: 401}, false},
+		{"anthropic 529", &AnthropicError{StatusCode: 529, Type: "overloaded_error"}, true},
+		{"断流", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"连接被拒绝", &url.Error{Op: "Post", URL: "http://x", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, true},
+		{"超时", &url.Error{Op: "Post", URL: "http://x", Err: &net.DNSError{IsTimeout: true}}, true},
+		{"证书错误", &url.Error{Op: "Post", URL: "https://x", Err: x509.UnknownAuthorityError{}}, false},
+		{"TLS握手", &url.Error{Op: "Post", URL: "https://x", Err: tls.RecordHeaderError{Msg: "bad"}}, false},
+		{"取消", context.Canceled, false},
+		{"普通错误", errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		if got := policy.retryable(c.err); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// 测试退避时间按倍数增长且不超过上限
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.1}
+	for attempt, base := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond} {
+		wait := policy.backoff(attempt)
+		if wait < base*9/10 || wait > base*11/10 {
+			t.Errorf("第%d次重试等待时间 %s 超出范围 %s±10%%", attempt, wait, base)
+		}
+	}
+	if wait := policy.backoff(10); wait > time.Second {
+		t.Errorf("等待时间超过上限: %s", wait)
+	}
+}
+
+// 测试retryDo只在可重试错误时重试，并遵守最大尝试次数
+func TestRetryDo(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	calls := 0
+	err := retryDo(context.Background(), policy, func() error {
+		calls++
+		return genai.APIError{Code: 429}
+	}, nil)
+	if err == nil || calls != 3 {
+		t.Errorf("期望尝试3次后失败, calls=%d err=%v", calls, err)
+	}
+
+	calls = 0
+	err = retryDo(context.Background(), policy, func() error {
+		calls++
+		if calls == 1 {
+			return genai.APIError{Code: 503}
+		}
+		return nil
+	}, nil)
+	if err != nil || calls != 2 {
+		t.Errorf("期望第2次成功, calls=%d err=%v", calls, err)
+	}
+
+	calls = 0
+	_ = retryDo(context.Background(), policy, func() error {
+		calls++
+		return genai.APIError{Code: 400}
+	}, nil)
+	if calls != 1 {
+		t.Errorf("不可重试的错误不应重试, calls=%d", calls)
+	}
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Session 会话，保存系统提示和对话历史，记录使用的agent和模型
+// 可序列化为JSON保存，通过AgentService.RestoreSession恢复后继续对话
+type Session struct {
+	AgentName    AgentName        `json:"agent_name"`              //agent名称
+	ModelName    string           `json:"model_name,omitempty"`    //模型名称，为空时使用agent的默认模型
+	SystemPrompt string           `json:"system_prompt,omitempty"` //系统提示
+	Messages     []ChatMessage    `json:"messages"`                //对话历史，不含系统提示
+	Usage        TokenUsage       `json:"usage"`                   //累计token使用统计
+	Pending      *PendingApproval `json:"pending,omitempty"`       //等待审批的对话状态
+	PendingAgent AgentName        `json:"pending_agent,omitempty"` //暂停时实际回答的agent(可能是降级目标)
+
+	service *AgentService
+	mu      sync.Mutex
+}
+
+// NewSession 创建会话
+func (s *AgentService) NewSession(agentName AgentName, modelName string, systemPrompt string) *Session {
+	return &Session{
+		AgentName:    agentName,
+		ModelName:    modelName,
+		SystemPrompt: systemPrompt,
+		service:      s,
+	}
+}
+
+// RestoreSession 从JSON恢复会话
+func (s *AgentService) RestoreSession(data []byte) (*Session, error) {
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("解析会话错误: %w", err)
+	}
+	session.service = s
+	return session, nil
+}
+
+// Send 发送用户消息，返回最终回答文本
+func (ss *Session) Send(ctx context.Context, userText string) (string, error) {
+	_, history, err := ss.SendMessage(ctx, ChatMessage{Role: "user", Content: userText}, nil)
+	if err != nil {
+		return "", err
+	}
+	return lastAssistantContent(history), nil
+}
+
+// SendMessage 发送消息(可包含多模态内容)，handler接收结构化流式事件
+// 返回本次的token统计和对话历史，成功后历史追加到会话中
+// 工具需要审批时返回ApprovalRequiredError，会话记录暂停状态，审批后调用Resume继续
+func (ss *Session) SendMessage(ctx context.Context, msg ChatMessage, handler StreamEventHandler) (*TokenUsage, []ChatMessage, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.service == nil {
+		return nil, nil, fmt.Errorf("会话未关联AgentService")
+	}
+	if ss.Pending != nil {
+		return nil, nil, fmt.Errorf("会话正在等待工具审批，请先调用Resume")
+	}
+
+	history := append(ss.fullHistory(), msg)
+	result, err := ss.service.StreamRunConversationWithFallback(ctx, ss.AgentName, ss.ModelName, history, handler)
+	ss.Usage.Add(result.Usage)
+	return result.Usage, result.History, ss.commit(result.AgentName, result.History, err)
+}
+
+// Resume 审批后恢复暂停的对话，返回最终回答文本，出错时保留暂停状态可以重试
+func (ss *Session) Resume(ctx context.Context, decisions []ApprovalDecision, handler StreamEventHandler) (string, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.service == nil {
+		return "", fmt.Errorf("会话未关联AgentService")
+	}
+	if ss.Pending == nil {
+		return "", fmt.Errorf("会话没有等待审批的对话")
+	}
+
+	agentName := ss.PendingAgent
+	if agentName == "" {
+		agentName = ss.AgentName
+	}
+	usage, history, err := ss.service.ResumeConversation(ctx, agentName, ss.Pending, decisions, handler)
+	ss.Usage.Add(usage)
+	var approvalErr *ApprovalRequiredError
+	if err != nil && !errors.As(err, &approvalErr) {
+		return "", err
+	}
+
+	ss.Pending = nil
+	ss.PendingAgent = ""
+	if err := ss.commit(agentName, history, err); err != nil {
+		return "", err
+	}
+	return lastAssistantContent(ss.Messages), nil
+}
+
+// commit 记录一次对话的结果，成功或审批暂停时追加历史，其他错误时丢弃本次对话
+func (ss *Session) commit(agentName AgentName, history []ChatMessage, err error) error {
+	var approvalErr *ApprovalRequiredError
+	if err != nil && !errors.As(err, &approvalErr) {
+		return err
+	}
+
+	ss.Messages = append(ss.Messages, history...)
+	if approvalErr != nil {
+		ss.Pending = approvalErr.Pending
+		ss.PendingAgent = agentName
+	}
+	return err
+}
+
+// History 返回包含系统提示的完整对话历史副本
+func (ss *Session) History() []ChatMessage {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.fullHistory()
+}
+
+// Clear 清空对话历史和待审批状态，保留系统提示和token统计
+func (ss *Session) Clear() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.Messages = nil
+	ss.Pending = nil
+	ss.PendingAgent = ""
+}
+
+// MarshalJSON 加锁序列化会话
+func (ss *Session) MarshalJSON() ([]byte, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	type session Session
+	return json.Marshal((*session)(ss))
+}
+
+// fullHistory 拼接系统提示和对话历史
+func (ss *Session) fullHistory() []ChatMessage {
+	history := make([]ChatMessage, 0, len(ss.Messages)+1)
+	if ss.SystemPrompt != "" {
+		history = append(history, ChatMessage{Role: "system", Content: ss.SystemPrompt})
+	}
+	return append(history, ss.Messages...)
+}
+
+// lastAssistantContent 返回历史中最后一条有内容的助手消息文本
+func lastAssistantContent(history []ChatMessage) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "assistant" && history[i].Content != "" {
+			return history[i].Content
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// sessionTestAgent 测试用agent，回答收到的历史条数，用户消息为"删除"时请求审批
+type sessionTestAgent struct{}
+
+func (a *sessionTestAgent) StreamRunConversation(ctx context.Context, modelName string, history []ChatMessage, handler StreamHandler) (*TokenUsage, []ChatMessage, error) {
+	return a.StreamRunConversationEvents(ctx, modelName, history, handler.EventHandler())
+}
+
+func (a *sessionTestAgent) StreamRunConversationEvents(ctx context.Context, modelName string, history []ChatMessage, handler StreamEventHandler) (*TokenUsage, []ChatMessage, error) {
+	last := history[len(history)-1]
+	if last.Content == "删除" {
+		call := FunctionCall{ID: "call_1", Name: "delete_user"}
+		conversation := []ChatMessage{last, {Role: "assistant", ToolCalls: []FunctionCall{call}}}
+		err := newApprovalRequiredError(modelName, 1, history, conversation, []FunctionCall{call}, []FunctionCall{call}, &TokenUsage{})
+		return &TokenUsage{TotalTokens: 1}, conversation, err
+	}
+	if last.Content == "出错" {
+		return &TokenUsage{TotalTokens: 1}, []ChatMessage{last}, fmt.Errorf("请求失败")
+	}
+	reply := ChatMessage{Role: "assistant", Content: fmt.Sprintf("收到%d条", len(history))}
+	return &TokenUsage{TotalTokens: 1}, []ChatMessage{last, reply}, nil
+}
+
+func (a *sessionTestAgent) ResumeConversation(ctx context.Context, pending *PendingApproval, decisions []ApprovalDecision, handler StreamEventHandler) (*TokenUsage, []ChatMessage, error) {
+	return &TokenUsage{TotalTokens: 1}, []ChatMessage{
+		{Role: "tool", FunctionResponses: []FunctionResponse{{ID: "call_1", Name: "delete_user", Result: map[string]any{"output": "ok"}}}},
+		{Role: "assistant", Content: "已删除"},
+	}, nil
+}
+
+func (a *sessionTestAgent) RegisterTool(FunctionDefinitionParam, ToolFunction) error { return nil }
+
+func (a *sessionTestAgent) RegisterContextTool(FunctionDefinitionParam, ContextToolFunction, ToolOptions) error {
+	return nil
+}
+
+func (a *sessionTestAgent) SetDebug(bool) {}
+
+func (a *sessionTestAgent) UnregisterTool(string) error { return nil }
+
+func (a *sessionTestAgent) ListTools() []FunctionDefinitionParam { return nil }
+
+// 测试会话累积历史并可序列化后恢复
+func TestSessionSendAndRestore(t *testing.T) {
+	service := NewAgentService(context.Background())
+	service.RegisterAgent(Gemini, &sessionTestAgent{})
+
+	session := service.NewSession(Gemini, "model", "你是助手")
+	if reply, err := session.Send(context.Background(), "你好"); err != nil || reply != "收到2条" {
+		t.Fatalf("第一次发送结果错误: %q, %v", reply, err)
+	}
+
+	// 出错时不记录本次对话
+	if _, err := session.Send(context.Background(), "出错"); err == nil {
+		t.Fatal("应返回错误")
+	}
+	if len(session.History()) != 3 {
+		t.Fatalf("出错后历史不应变化: %+v", session.History())
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	restored, err := service.RestoreSession(data)
+	if err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if reply, err := restored.Send(context.Background(), "继续"); err != nil || reply != "收到4条" {
+		t.Fatalf("恢复后发送结果错误: %q, %v", reply, err)
+	}
+	if restored.Usage.TotalTokens != 3 || restored.History()[0].Role != "system" {
+		t.Errorf("恢复后会话状态错误: %+v", restored)
+	}
+}
+
+// 测试会话在工具审批时暂停并恢复
+func TestSessionApproval(t *testing.T) {
+	service := NewAgentService(context.Background())
+	service.RegisterAgent(Gemini, &sessionTestAgent{})
+	session := service.NewSession(Gemini, "model", "")
+
+	_, err := session.Send(context.Background(), "删除")
+	var approvalErr *ApprovalRequiredError
+	if !errors.As(err, &approvalErr) || session.Pending == nil {
+		t.Fatalf("应暂停等待审批: %v", err)
+	}
+	if _, err := session.Send(context.Background(), "你好"); err == nil {
+		t.Error("等待审批时不应允许发送新消息")
+	}
+
+	reply, err := session.Resume(context.Background(), []ApprovalDecision{{CallID: "call_1", Action: ApprovalApprove}}, nil)
+	if err != nil || reply != "已删除" {
+		t.Fatalf("恢复结果错误: %q, %v", reply, err)
+	}
+	if session.Pending != nil || len(session.History()) != 4 {
+		t.Errorf("恢复后会话状态错误: %+v", session.History())
+	}
+}
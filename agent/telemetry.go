@@ -0,0 +1,321 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName tracer和meter的名称
+const instrumentationName = "github.com/562589540/agent-go/agent"
+
+// GenAI语义约定中semconv v1.30.0还没有定义的属性和取值
+const (
+	genAIOperationInvokeAgent = "invoke_agent" // 一次完整的agent对话
+	genAIOperationExecuteTool = "execute_tool" // 一次工具执行
+
+	genAIToolNameKey   = attribute.Key("gen_ai.tool.name")    // 工具名称
+	genAIToolCallIDKey = attribute.Key("gen_ai.tool.call.id") // 工具调用ID
+
+	agentLoopKey            = attribute.Key("agent.loop")                     // 循环次数
+	agentLoopsKey           = attribute.Key("agent.loops")                    // 对话的总循环次数
+	agentUsageCacheKey      = attribute.Key("agent.usage.cache_tokens")       // 缓存命中token
+	agentUsageCacheWriteKey = attribute.Key("agent.usage.cache_write_tokens") // 写入缓存token
+	agentUsageReasoningKey  = attribute.Key("agent.usage.reasoning_tokens")   // 思考token
+	agentRetryAttemptKey    = attribute.Key("agent.retry.attempt")            // 重试次数
+	agentApprovalPendingKey = attribute.Key("agent.approval.pending")         // 因等待审批暂停的工具调用数
+
+	agentToolDurationName = "agent.tool.duration" // 工具执行耗时指标
+	agentToolCallsName    = "agent.tool.calls"    // 工具调用次数指标，按error.type区分失败
+)
+
+// Telemetry OpenTelemetry配置
+// 为每次对话、每轮循环、每次供应商请求和每次工具执行生成span，属性遵循GenAI语义约定，
+// 并记录请求耗时、token用量、工具耗时和工具调用次数(按error.type统计错误率)
+type Telemetry struct {
+	TracerProvider trace.TracerProvider // 为空时使用otel全局TracerProvider
+	MeterProvider  metric.MeterProvider // 为空时使用otel全局MeterProvider
+}
+
+// telemetry agent使用的tracer和指标，为nil时所有方法都不做任何事
+type telemetry struct {
+	system string // gen_ai.system
+	tracer trace.Tracer
+
+	operationDuration metric.Float64Histogram // gen_ai.client.operation.duration
+	tokenUsage        metric.Int64Histogram   // gen_ai.client.token.usage
+	toolDuration      metric.Float64Histogram // agent.tool.duration
+	toolCalls         metric.Int64Counter     // agent.tool.calls
+}
+
+// newTelemetry 按配置创建，config为空时不启用
+func newTelemetry(config *Telemetry, system string) *telemetry {
+	if config == nil {
+		return nil
+	}
+	tp := config.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	mp := config.MeterProvider
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	t := &telemetry{system: system, tracer: tp.Tracer(instrumentationName)}
+	// 创建失败时otel会返回可用的空实现，这里忽略错误
+	t.operationDuration, _ = meter.Float64Histogram(semconv.GenAIClientOperationDurationName,
+		metric.WithUnit(semconv.GenAIClientOperationDurationUnit),
+		metric.WithDescription(semconv.GenAIClientOperationDurationDescription))
+	t.tokenUsage, _ = meter.Int64Histogram(semconv.GenAIClientTokenUsageName,
+		metric.WithUnit(semconv.GenAIClientTokenUsageUnit),
+		metric.WithDescription(semconv.GenAIClientTokenUsageDescription))
+	t.toolDuration, _ = meter.Float64Histogram(agentToolDurationName,
+		metric.WithUnit("s"),
+		metric.WithDescription("Tool execution duration"))
+	t.toolCalls, _ = meter.Int64Counter(agentToolCallsName,
+		metric.WithUnit("{call}"),
+		metric.WithDescription("Number of tool calls, failed calls carry error.type"))
+	return t
+}
+
+// conversationSpan 一次对话的span，每轮循环的span是它的子span
+type conversationSpan struct {
+	t     *telemetry
+	ctx   context.Context // 对话span所在的ctx
+	span  trace.Span
+	loop  trace.Span // 当前循环的span
+	model string
+	loops int
+	start time.Time
+}
+
+// startConversation 开始对话span
+func (t *telemetry) startConversation(ctx context.Context, modelName string) (context.Context, *conversationSpan) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, genAIOperationInvokeAgent+" "+modelName,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			semconv.GenAIOperationNameKey.String(genAIOperationInvokeAgent),
+			semconv.GenAISystemKey.String(t.system),
+			semconv.GenAIRequestModelKey.String(modelName),
+		))
+	return ctx, &conversationSpan{t: t, ctx: ctx, span: span, model: modelName, start: time.Now()}
+}
+
+// startLoop 结束上一轮循环的span并开始新一轮，返回本轮使用的ctx
+func (c *conversationSpan) startLoop(ctx context.Context, loop int) context.Context {
+	if c == nil {
+		return ctx
+	}
+	c.endLoop()
+	c.loops = loop
+	ctx, c.loop = c.t.tracer.Start(c.ctx, "loop "+strconv.Itoa(loop), trace.WithAttributes(agentLoopKey.Int(loop)))
+	return ctx
+}
+
+func (c *conversationSpan) endLoop() {
+	if c.loop != nil {
+		c.loop.End()
+		c.loop = nil
+	}
+}
+
+// end 结束对话span，记录累计token和对话耗时
+func (c *conversationSpan) end(usage *TokenUsage, err error) {
+	if c == nil {
+		return
+	}
+	c.endLoop()
+	c.span.SetAttributes(agentLoopsKey.Int(c.loops))
+	if usage != nil {
+		c.span.SetAttributes(usageAttributes(usage)...)
+	}
+
+	var approvalErr *ApprovalRequiredError
+	if errors.As(err, &approvalErr) {
+		// 等待审批不是错误
+		c.span.SetAttributes(agentApprovalPendingKey.Int(len(approvalErr.Pending.Requests)))
+		err = nil
+	}
+	attrs := c.t.operationAttributes(genAIOperationInvokeAgent, c.model, err)
+	recordSpanError(c.span, err)
+	c.t.operationDuration.Record(context.Background(), time.Since(c.start).Seconds(), metric.WithAttributes(attrs...))
+	c.span.End()
+}
+
+// requestSpan 一次供应商请求的span
+type requestSpan struct {
+	t     *telemetry
+	ctx   context.Context
+	span  trace.Span
+	model string
+	start time.Time
+}
+
+// startRequest 开始供应商请求span，ctx应为本轮循环的ctx
+func (c *conversationSpan) startRequest(ctx context.Context, modelName string, config AgentConfig) *requestSpan {
+	if c == nil {
+		return nil
+	}
+	attrs := []attribute.KeyValue{
+		semconv.GenAIOperationNameChat,
+		semconv.GenAISystemKey.String(c.t.system),
+		semconv.GenAIRequestModelKey.String(modelName),
+	}
+	if config.MaxTokens > 0 {
+		attrs = append(attrs, semconv.GenAIRequestMaxTokensKey.Int64(config.MaxTokens))
+	}
+	if config.Temperature > 0 {
+		attrs = append(attrs, semconv.GenAIRequestTemperatureKey.Float64(config.Temperature))
+	}
+	if config.TopP > 0 {
+		attrs = append(attrs, semconv.GenAIRequestTopPKey.Float64(config.TopP))
+	}
+	ctx, span := c.t.tracer.Start(ctx, "chat "+modelName, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return &requestSpan{t: c.t, ctx: ctx, span: span, model: modelName, start: time.Now()}
+}
+
+// retry 记录一次重试
+func (r *requestSpan) retry(attempt int, err error) {
+	if r == nil {
+		return
+	}
+	r.span.AddEvent("retry", trace.WithAttributes(agentRetryAttemptKey.Int(attempt), semconv.ErrorTypeKey.String(errorType(err))))
+}
+
+// end 结束请求span，usage为本次请求的token用量
+func (r *requestSpan) end(usage *TokenUsage, finishReason string, err error) {
+	if r == nil {
+		return
+	}
+	if finishReason != "" {
+		r.span.SetAttributes(semconv.GenAIResponseFinishReasonsKey.StringSlice([]string{finishReason}))
+	}
+	attrs := r.t.operationAttributes("chat", r.model, err)
+	if usage != nil && usage.TotalTokens > 0 {
+		r.span.SetAttributes(usageAttributes(usage)...)
+		tokenAttrs := r.t.operationAttributes("chat", r.model, nil)
+		r.t.tokenUsage.Record(r.ctx, int64(usage.PromptTokens), metric.WithAttributes(append(tokenAttrs, semconv.GenAITokenTypeInput)...))
+		r.t.tokenUsage.Record(r.ctx, int64(usage.CompletionTokens), metric.WithAttributes(append(tokenAttrs, semconv.GenAITokenTypeCompletion)...))
+	}
+	recordSpanError(r.span, err)
+	r.t.operationDuration.Record(r.ctx, time.Since(r.start).Seconds(), metric.WithAttributes(attrs...))
+	r.span.End()
+}
+
+// startTool 开始工具执行span
+func (t *telemetry) startTool(ctx context.Context, inv *toolInvocation) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	return t.tracer.Start(ctx, genAIOperationExecuteTool+" "+inv.Name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			semconv.GenAIOperationNameKey.String(genAIOperationExecuteTool),
+			semconv.GenAISystemKey.String(t.system),
+			genAIToolNameKey.String(inv.Name),
+			genAIToolCallIDKey.String(inv.ID),
+			agentLoopKey.Int(inv.Loop),
+		))
+}
+
+// endTool 结束工具执行span并记录耗时
+func (t *telemetry) endTool(ctx context.Context, span trace.Span, inv *toolInvocation, start time.Time) {
+	if t == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{genAIToolNameKey.String(inv.Name)}
+	if inv.Err != nil {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(inv.ErrType))
+		span.SetAttributes(semconv.ErrorTypeKey.String(inv.ErrType))
+		span.SetStatus(codes.Error, inv.Err.Error())
+	}
+	t.toolDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	span.End()
+}
+
+// recordToolCall 记录一次工具调用，包括没有执行的不合法调用和被拒绝的调用
+func (t *telemetry) recordToolCall(ctx context.Context, inv *toolInvocation) {
+	if t == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{genAIToolNameKey.String(inv.Name)}
+	if inv.Err != nil {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(inv.ErrType))
+	}
+	t.toolCalls.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// operationAttributes 请求和对话指标的公共属性
+func (t *telemetry) operationAttributes(operation, modelName string, err error) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.GenAIOperationNameKey.String(operation),
+		semconv.GenAISystemKey.String(t.system),
+		semconv.GenAIRequestModelKey.String(modelName),
+	}
+	if err != nil {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(errorType(err)))
+	}
+	return attrs
+}
+
+// usageAttributes token用量属性
+func usageAttributes(usage *TokenUsage) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.GenAIUsageInputTokensKey.Int(usage.PromptTokens),
+		semconv.GenAIUsageOutputTokensKey.Int(usage.CompletionTokens),
+		agentUsageCacheKey.Int(usage.CacheTokens),
+		agentUsageCacheWriteKey.Int(usage.CacheWriteTokens),
+		agentUsageReasoningKey.Int(usage.ReasoningTokens),
+	}
+}
+
+// recordSpanError 把错误记录到span
+func recordSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.SetAttributes(semconv.ErrorTypeKey.String(errorType(err)))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// errorTypes 错误对应的error.type，取值数量有限，可用于指标分组
+var errorTypes = []struct {
+	err  error
+	name string
+}{
+	{ErrRateLimited, "rate_limited"},
+	{ErrAuthFailed, "auth_failed"},
+	{ErrContentBlocked, "content_blocked"},
+	{ErrContextLengthExceeded, "context_length_exceeded"},
+	{ErrStreamInterrupted, "stream_interrupted"},
+	{ErrMaxLoopsExceeded, "max_loops_exceeded"},
+	{ErrBudgetExceeded, "budget_exceeded"},
+	{ErrToolNotFound, toolErrorNotFound},
+	{ErrInvalidToolArgs, toolErrorArgs},
+	{context.DeadlineExceeded, "timeout"},
+	{context.Canceled, "canceled"},
+}
+
+// errorType 错误的error.type，无法归类时为_OTHER
+func errorType(err error) string {
+	for _, t := range errorTypes {
+		if errors.Is(err, t.err) {
+			return t.name
+		}
+	}
+	return semconv.ErrorTypeOther.Value.AsString()
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&ProviderError{Kind: ErrRateLimited, StatusCode: 429}, "rate_limited"},
+		{fmt.Errorf("流处理错误: %w", &ProviderError{Kind: ErrAuthFailed}), "auth_failed"},
+		{&MaxLoopsError{MaxLoops: 5}, "max_loops_exceeded"},
+		{&ContentBlockedError{Reason: "SAFETY"}, "content_blocked"},
+		{&InvalidToolArgsError{Name: "x", Err: errors.New("bad")}, toolErrorArgs},
+		{fmt.Errorf("请求失败: %w", context.DeadlineExceeded), "timeout"},
+		{context.Canceled, "canceled"},
+		{errors.New("未知错误"), "_OTHER"},
+	}
+	for _, tt := range tests {
+		if got := errorType(tt.err); got != tt.want {
+			t.Errorf("errorType(%v) = %s, 期望 %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestTelemetryDisabled(t *testing.T) {
+	// 未配置时所有方法都不做任何事
+	tel := newTelemetry(nil, "openai")
+	if tel != nil {
+		t.Fatal("未配置时应为nil")
+	}
+	ctx := context.Background()
+	ctx, conv := tel.startConversation(ctx, "m")
+	loopCtx := conv.startLoop(ctx, 1)
+	if loopCtx != ctx {
+		t.Error("未启用时不应修改ctx")
+	}
+	request := conv.startRequest(loopCtx, "m", AgentConfig{})
+	request.retry(1, errors.New("x"))
+	request.end(&TokenUsage{TotalTokens: 1}, "stop", nil)
+
+	inv := &toolInvocation{Name: "t", Err: errors.New("x"), ErrType: toolErrorExecution}
+	toolCtx, span := tel.startTool(loopCtx, inv)
+	tel.endTool(toolCtx, span, inv, time.Now())
+	tel.recordToolCall(toolCtx, inv)
+	conv.end(&TokenUsage{}, nil)
+}
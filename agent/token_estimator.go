@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"encoding/json"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	messageOverheadTokens = 4   // 每条消息的角色和格式开销
+	mediaPartTokens       = 258 // 每个图片、音频、文件片段按固定值估算
+)
+
+// TokenEstimator 本地估算一组消息的token数
+type TokenEstimator func(messages []ChatMessage) int
+
+// EstimateTokens 在发送前粗略估算消息的token数，不依赖供应商的分词器
+// 文本按EstimateTextTokens估算，工具调用和响应按JSON估算，多模态片段按固定值估算
+func EstimateTokens(messages []ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += messageOverheadTokens + EstimateTextTokens(msg.Content)
+		for _, part := range msg.Parts {
+			if part.Type == PartText {
+				total += EstimateTextTokens(part.Text)
+			} else {
+				total += mediaPartTokens
+			}
+		}
+		for _, call := range msg.ToolCalls {
+			args, _ := json.Marshal(call.Args)
+			total += EstimateTextTokens(call.Name) + EstimateTextTokens(string(args))
+		}
+		for _, resp := range msg.FunctionResponses {
+			result, _ := json.Marshal(resp.Result)
+			total += EstimateTextTokens(resp.Name) + EstimateTextTokens(string(result))
+		}
+	}
+	return total
+}
+
+// EstimateTextTokens 估算文本的token数：中日韩等字符约1个字符1个token，其他字符约4个字符1个token
+func EstimateTextTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	wide, other := 0, 0
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		text = text[size:]
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			wide++
+		} else {
+			other++
+		}
+	}
+	return wide + (other+3)/4
+}
@@ -0,0 +1,358 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 工具错误类型，作为结构化错误结果的error_type返回给模型
+const (
+	toolErrorExecution = "execution_error" // 工具返回错误或panic
+	toolErrorTimeout   = "timeout"         // 超过工具配置的超时时间
+	toolErrorCanceled  = "canceled"        // 对话被取消
+	toolErrorDenied    = "denied"          // 用户拒绝执行
+	toolErrorNotFound  = "tool_not_found"  // 调用了未注册的工具
+	toolErrorArgs      = "invalid_args"    // 参数不是合法JSON或不符合参数定义
+	toolErrorSkipped   = "skipped"         // 按skip策略跳过的调用
+	toolErrorAborted   = "aborted"         // 按abort策略中止对话，未执行
+)
+
+// ToolCallErrorPolicy 模型调用了未注册的工具或给出不合法参数时的处理策略
+type ToolCallErrorPolicy string
+
+const (
+	ToolCallSelfCorrect ToolCallErrorPolicy = "self_correct" // 把错误原因和可用工具返回给模型，由模型修正后重新调用
+	ToolCallAbort       ToolCallErrorPolicy = "abort"        // 停止对话，返回ToolNotFoundError或InvalidToolArgsError，本轮工具都不执行，每个调用以错误响应加入历史
+	ToolCallSkip        ToolCallErrorPolicy = "skip"         // 不执行该调用，只告知模型已跳过，不要求重试
+)
+
+// toolInvocation 一次待执行的工具调用及其执行结果
+type toolInvocation struct {
+	ID   string                 // 工具调用ID
+	Name string                 // 工具名称
+	Args map[string]interface{} // 解析后的参数
+	Tool Tool                   // 对应的已注册工具
+	Loop int                    // 当前循环次数
+
+	Middlewares []ToolMiddleware // agent的全局中间件，在工具自身的中间件外层
+	Telemetry   *telemetry       // 为空时不生成工具span
+
+	Output    string   // 工具输出
+	Err       error    // 工具执行错误
+	ErrType   string   // 错误类型
+	Available []string // 调用了未注册的工具时可用的工具名称
+}
+
+// executeToolInvocations 执行一组工具调用，结果写回各自的invocation，调用方按原顺序读取
+// maxParallel 小于等于1时按顺序执行，否则最多同时执行maxParallel个
+func executeToolInvocations(ctx context.Context, invocations []*toolInvocation, maxParallel int) {
+	if maxParallel <= 1 || len(invocations) <= 1 {
+		for _, inv := range invocations {
+			inv.invoke(ctx)
+		}
+		return
+	}
+
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for _, inv := range invocations {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(inv *toolInvocation) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			inv.invoke(ctx)
+		}(inv)
+	}
+	wg.Wait()
+}
+
+// invoke 执行工具，处理超时和取消
+// 处理函数不响应ctx时不会等待其返回，超时或取消后直接以错误结果返回给模型
+func (inv *toolInvocation) invoke(ctx context.Context) {
+	start := time.Now()
+	ctx, span := inv.Telemetry.startTool(ctx, inv)
+	defer inv.Telemetry.endTool(ctx, span, inv, start)
+
+	timeout := inv.Tool.Options.Timeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// 不可取消的上下文直接同步调用
+	if ctx.Done() == nil {
+		inv.Output, inv.Err = inv.safeCall(ctx)
+		if inv.Err != nil {
+			inv.ErrType = toolErrorExecution
+		}
+		return
+	}
+
+	type callResult struct {
+		output string
+		err    error
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		output, err := inv.safeCall(ctx)
+		done <- callResult{output: output, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		inv.Output, inv.Err = result.output, result.err
+		if inv.Err != nil {
+			inv.ErrType = toolErrorExecution
+		}
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && timeout > 0 {
+			inv.Err = fmt.Errorf("工具 %s 执行超时(%s)", inv.Name, timeout)
+			inv.ErrType = toolErrorTimeout
+		} else {
+			inv.Err = fmt.Errorf("工具 %s 执行被取消: %w", inv.Name, ctx.Err())
+			inv.ErrType = toolErrorCanceled
+		}
+	}
+}
+
+// safeCall 经过中间件调用工具处理函数，panic转换为错误返回给模型
+func (inv *toolInvocation) safeCall(ctx context.Context) (output string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			output = ""
+			err = fmt.Errorf("工具 %s 中间件panic: %v", inv.Name, r)
+		}
+	}()
+
+	handler := func(ctx context.Context, exec *ToolExecution) (result ToolResult) {
+		start := time.Now()
+		defer func() {
+			if r := recover(); r != nil {
+				result = ToolResult{Err: fmt.Errorf("工具 %s 执行panic: %v", inv.Name, r)}
+			}
+			result.Duration = time.Since(start)
+		}()
+		output, err := inv.Tool.call(ctx, exec.Args)
+		return ToolResult{Output: output, Err: err}
+	}
+
+	exec := &ToolExecution{Name: inv.Name, CallID: inv.ID, Args: inv.Args, Loop: inv.Loop}
+	result := chainToolMiddlewares(handler, inv.Middlewares, inv.Tool.Options.Middlewares)(ctx, exec)
+	return result.Output, result.Err
+}
+
+// resultMap 转换为返回给模型的函数响应
+// 成功时为{"output": 输出}，失败时附带error和error_type字段
+func (inv *toolInvocation) resultMap() map[string]any {
+	if inv.Err == nil {
+		return map[string]any{"output": inv.Output}
+	}
+
+	result := map[string]any{
+		"output":     fmt.Sprintf("执行错误: %v", inv.Err),
+		"error":      true,
+		"error_type": inv.ErrType,
+	}
+	switch inv.ErrType {
+	case toolErrorNotFound, toolErrorArgs:
+		result["output"] = fmt.Sprintf("调用错误: %v，请修正后重新调用", inv.Err)
+		if len(inv.Available) > 0 {
+			result["available_tools"] = inv.Available
+		}
+	case toolErrorSkipped:
+		result["output"] = fmt.Sprintf("调用已跳过: %v，请不要重试", inv.Err)
+	case toolErrorAborted:
+		result["output"] = fmt.Sprintf("调用已中止: %v", inv.Err)
+	}
+	return result
+}
+
+// toolStage 一轮工具调用的执行流程：查找工具、审批检查、执行并按原顺序生成函数响应
+type toolStage struct {
+	tools       map[string]Tool     // 已注册工具
+	maxParallel int                 // 最大并发数
+	handler     StreamEventHandler  // 事件回调
+	loop        int                 // 当前循环次数
+	logger      *slog.Logger        // 结构化日志
+	errorPolicy ToolCallErrorPolicy // 未注册工具和不合法参数的处理策略
+	middlewares []ToolMiddleware    // 全局工具中间件
+	resultLimit *ResultLimit        // 全局工具结果长度限制
+	telemetry   *telemetry          // 工具span和指标
+}
+
+// checkCall 检查工具是否注册以及参数是否合法
+func (s *toolStage) checkCall(call FunctionCall, args map[string]interface{}) (string, error) {
+	tool, exists := s.tools[call.Name]
+	if !exists {
+		return toolErrorNotFound, &ToolNotFoundError{Name: call.Name, CallID: call.ID}
+	}
+	if args == nil && call.RawArgs != "" {
+		return toolErrorArgs, &InvalidToolArgsError{Name: call.Name, CallID: call.ID, Err: fmt.Errorf("参数不是合法的JSON: %s", call.RawArgs)}
+	}
+	if err := validateToolArgs(tool.Function.Parameters, args); err != nil {
+		return toolErrorArgs, &InvalidToolArgsError{Name: call.Name, CallID: call.ID, Err: err}
+	}
+	return "", nil
+}
+
+// reject 按策略处理不合法的调用，abort策略返回错误
+func (s *toolStage) reject(inv *toolInvocation, err error, errType string) error {
+	s.logger.Warn("工具调用不合法", "loop", s.loop, "tool", inv.Name, "id", inv.ID, "error", err)
+	switch s.errorPolicy {
+	case ToolCallAbort:
+		return err
+	case ToolCallSkip:
+		inv.Err, inv.ErrType = err, toolErrorSkipped
+	default:
+		inv.Err, inv.ErrType = err, errType
+		if errType == toolErrorNotFound {
+			inv.Available = s.toolNames()
+		}
+	}
+	return nil
+}
+
+// abort abort策略中止时为本轮每个调用生成错误响应，历史中的工具调用都有对应的响应，不会被供应商拒绝
+// failed为不合法调用的下标，其余调用都没有执行
+func (s *toolStage) abort(calls []FunctionCall, failed int, err error, errType string) []FunctionResponse {
+	responses := make([]FunctionResponse, 0, len(calls))
+	for i, call := range calls {
+		inv := &toolInvocation{ID: call.ID, Name: call.Name, Err: fmt.Errorf("对话因工具 %s 调用不合法而中止", calls[failed].Name), ErrType: toolErrorAborted}
+		if i == failed {
+			inv.Err, inv.ErrType = err, errType
+		}
+		responses = append(responses, FunctionResponse{ID: inv.ID, Name: inv.Name, Result: inv.resultMap()})
+	}
+	return responses
+}
+
+// toolNames 已注册工具的名称，按名称排序
+func (s *toolStage) toolNames() []string {
+	names := make([]string, 0, len(s.tools))
+	for name := range s.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// run 执行本轮工具调用，decisions为恢复对话时对待审批调用的决定
+// 有需要审批但还没有决定的调用时不执行任何工具，返回待审批的调用
+func (s *toolStage) run(ctx context.Context, calls []FunctionCall, decisions map[string]ApprovalDecision) ([]FunctionResponse, []FunctionCall, error) {
+	var invocations []*toolInvocation // 本轮所有调用，按原顺序返回结果
+	var runnable []*toolInvocation    // 需要执行的调用
+	var pending []FunctionCall
+
+	for i, call := range calls {
+		inv := &toolInvocation{
+			ID:          call.ID,
+			Name:        call.Name,
+			Args:        call.Args,
+			Tool:        s.tools[call.Name],
+			Loop:        s.loop,
+			Middlewares: s.middlewares,
+			Telemetry:   s.telemetry,
+		}
+		invocations = append(invocations, inv)
+
+		// 每个调用都要有函数响应，不合法的调用按策略返回错误结果
+		if errType, err := s.checkCall(call, call.Args); err != nil {
+			if err := s.reject(inv, err, errType); err != nil {
+				return s.abort(calls, i, err, errType), nil, err
+			}
+			continue
+		}
+
+		tool := inv.Tool
+		if !tool.Options.RequireApproval {
+			runnable = append(runnable, inv)
+			continue
+		}
+
+		decision, ok := decisions[call.ID]
+		if !ok {
+			pending = append(pending, call)
+			continue
+		}
+		switch decision.Action {
+		case ApprovalApprove:
+			runnable = append(runnable, inv)
+		case ApprovalEdit:
+			inv.Args = decision.Args
+			if errType, err := s.checkCall(call, inv.Args); err != nil {
+				if err := s.reject(inv, err, errType); err != nil {
+					return s.abort(calls, i, err, errType), nil, err
+				}
+				continue
+			}
+			runnable = append(runnable, inv)
+		default:
+			// 拒绝或无法识别的决定都不执行
+			reason := decision.Reason
+			if reason == "" {
+				reason = "未说明原因"
+			}
+			inv.Err = fmt.Errorf("用户拒绝执行工具 %s: %s", inv.Name, reason)
+			inv.ErrType = toolErrorDenied
+		}
+	}
+
+	if len(pending) > 0 {
+		return nil, pending, nil
+	}
+
+	for _, inv := range runnable {
+		s.logger.DebugContext(ctx, "执行工具", "loop", s.loop, "tool", inv.Name, "id", inv.ID)
+		if s.logger.Enabled(ctx, LevelTrace) {
+			argsJSON, _ := json.Marshal(inv.Args)
+			s.logger.Log(ctx, LevelTrace, "工具参数", "tool", inv.Name, "id", inv.ID, "args", string(argsJSON))
+		}
+	}
+
+	// 执行工具，配置了并发数时同时执行
+	executeToolInvocations(ctx, runnable, s.maxParallel)
+
+	// 对话已取消，不再把结果发送给模型
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// 按原调用顺序组装函数响应，错误信息以结构化结果返回给模型
+	responses := make([]FunctionResponse, 0, len(invocations))
+	for _, inv := range invocations {
+		if inv.Err != nil {
+			s.logger.WarnContext(ctx, "工具执行错误", "loop", s.loop, "tool", inv.Name, "id", inv.ID, "error", inv.Err)
+		} else {
+			s.logger.DebugContext(ctx, "工具执行成功", "loop", s.loop, "tool", inv.Name, "id", inv.ID)
+			s.logger.Log(ctx, LevelTrace, "工具结果", "tool", inv.Name, "id", inv.ID, "output", inv.Output)
+		}
+		s.telemetry.recordToolCall(ctx, inv)
+
+		funcResp := FunctionResponse{
+			ID:     inv.ID,
+			Name:   inv.Name,
+			Result: inv.resultMap(),
+		}
+
+		// 结果过长时只把处理后的内容发送给模型，保留完整结果
+		if inv.Err == nil {
+			if output, limited := resultLimitFor(inv.Tool, s.resultLimit).apply(ctx, inv.Name, inv.Output); limited {
+				s.logger.DebugContext(ctx, "工具结果过长，已按长度限制处理", "tool", inv.Name, "id", inv.ID, "bytes", len(inv.Output))
+				funcResp.Result["output"] = output
+				funcResp.Result["truncated"] = true
+				funcResp.FullOutput = inv.Output
+			}
+		}
+		responses = append(responses, funcResp)
+		s.handler.emit(StreamEvent{Type: EventToolResult, Loop: s.loop, ToolResult: &funcResp})
+	}
+	return responses, nil, nil
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试并发执行工具调用时结果保持原顺序且不超过并发上限
+func TestExecuteToolInvocationsParallel(t *testing.T) {
+	var running, peak int32
+	tool := Tool{
+		Function: FunctionDefinitionParam{Name: "slow"},
+		Handler: func(args map[string]interface{}) (string, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return fmt.Sprintf("result-%v", args["i"]), nil
+		},
+	}
+
+	var invocations []*toolInvocation
+	for i := 0; i < 6; i++ {
+		invocations = append(invocations, &toolInvocation{
+			ID:   fmt.Sprintf("call_%d", i),
+			Name: "slow",
+			Args: map[string]interface{}{"i": i},
+			Tool: tool,
+		})
+	}
+
+	executeToolInvocations(context.Background(), invocations, 3)
+
+	for i, inv := range invocations {
+		if inv.Err != nil {
+			t.Fatalf("工具调用 %d 返回错误: %v", i, inv.Err)
+		}
+		if want := fmt.Sprintf("result-%d", i); inv.Output != want {
+			t.Errorf("工具调用 %d 结果错误: got %q, want %q", i, inv.Output, want)
+		}
+	}
+	if peak > 3 {
+		t.Errorf("并发数超过上限: %d", peak)
+	}
+	if peak < 2 {
+		t.Errorf("工具调用没有并发执行, 峰值并发: %d", peak)
+	}
+}
+
+// 测试工具panic被转换为错误
+func TestExecuteToolInvocationsPanic(t *testing.T) {
+	inv := &toolInvocation{
+		Name: "broken",
+		Tool: Tool{Handler: func(args map[string]interface{}) (string, error) {
+			panic("boom")
+		}},
+	}
+
+	executeToolInvocations(context.Background(), []*toolInvocation{inv}, 1)
+
+	if inv.Err == nil {
+		t.Fatal("期望panic被转换为错误")
+	}
+}
+
+// 测试工具超时以结构化错误返回，且不等待不响应ctx的处理函数
+func TestExecuteToolInvocationsTimeout(t *testing.T) {
+	inv := &toolInvocation{
+		ID:   "call_1",
+		Name: "hang",
+		Tool: Tool{
+			Handler: func(args map[string]interface{}) (string, error) {
+				time.Sleep(time.Second)
+				return "late", nil
+			},
+			Options: ToolOptions{Timeout: 20 * time.Millisecond},
+		},
+	}
+
+	start := time.Now()
+	executeToolInvocations(context.Background(), []*toolInvocation{inv}, 1)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("超时后仍在等待工具返回: %s", elapsed)
+	}
+
+	result := inv.resultMap()
+	if result["error"] != true || result["error_type"] != toolErrorTimeout {
+		t.Errorf("期望结构化超时错误, got %v", result)
+	}
+}
+
+// 测试带上下文的工具可以读取请求级的值
+func TestExecuteToolInvocationsContextValue(t *testing.T) {
+	type ctxKey struct{}
+	inv := &toolInvocation{
+		Name: "whoami",
+		Tool: Tool{ContextHandler: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			return fmt.Sprint(ctx.Value(ctxKey{})), nil
+		}},
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "user-42"))
+	defer cancel()
+	executeToolInvocations(ctx, []*toolInvocation{inv}, 1)
+
+	if inv.Output != "user-42" {
+		t.Errorf("工具没有收到上下文中的值: %q", inv.Output)
+	}
+}
+
+// 测试未注册的工具和不合法参数按策略处理，每个调用都有函数响应
+func TestToolStageCallErrors(t *testing.T) {
+	var executed []string
+	calls := []FunctionCall{
+		{ID: "call_1", Name: "unknown"},
+		{ID: "call_2", Name: "get_user", RawArgs: `{"id":`},
+		{ID: "call_3", Name: "get_user", Args: map[string]interface{}{}},
+		{ID: "call_4", Name: "get_user", Args: map[string]interface{}{"id": 1}},
+	}
+	newStage := func(policy ToolCallErrorPolicy) *toolStage {
+		stage := newApprovalTestStage(&executed)
+		getUser := stage.tools["get_user"]
+		getUser.Function.Parameters = map[string]interface{}{"type": "object", "required": []interface{}{"id"}}
+		stage.tools["get_user"] = getUser
+		stage.errorPolicy = policy
+		return stage
+	}
+
+	responses, _, err := newStage("").run(context.Background(), calls, nil)
+	if err != nil || len(responses) != 4 {
+		t.Fatalf("应为每个调用返回响应: %v, %v", responses, err)
+	}
+	wantTypes := []interface{}{toolErrorNotFound, toolErrorArgs, toolErrorArgs, nil}
+	for i, resp := range responses {
+		if resp.ID != calls[i].ID || resp.Result["error_type"] != wantTypes[i] {
+			t.Errorf("响应%d错误: %+v", i, resp)
+		}
+	}
+	if available, _ := responses[0].Result["available_tools"].([]string); len(available) != 2 {
+		t.Errorf("应返回可用工具: %+v", responses[0].Result)
+	}
+	if len(executed) != 1 {
+		t.Errorf("只应执行合法的调用: %v", executed)
+	}
+
+	responses, _, err = newStage(ToolCallSkip).run(context.Background(), calls, nil)
+	if err != nil || responses[1].Result["error_type"] != toolErrorSkipped {
+		t.Errorf("skip策略结果错误: %+v, %v", responses, err)
+	}
+
+	executed = nil
+	responses, _, err = newStage(ToolCallAbort).run(context.Background(), calls, nil)
+	if !errors.Is(err, ErrToolNotFound) || len(executed) != 0 {
+		t.Errorf("abort策略应停止且不执行工具: %v, %v", err, executed)
+	}
+	// 中止时每个调用都有错误响应
+	if len(responses) != len(calls) || responses[0].Result["error_type"] != toolErrorNotFound || responses[3].ID != calls[3].ID || responses[3].Result["error_type"] != toolErrorAborted {
+		t.Errorf("abort策略应为每个调用返回错误响应: %+v", responses)
+	}
+	_, _, err = newStage(ToolCallAbort).run(context.Background(), calls[1:], nil)
+	var argsErr *InvalidToolArgsError
+	if !errors.As(err, &argsErr) || argsErr.CallID != "call_2" {
+		t.Errorf("abort策略应返回参数错误: %v", err)
+	}
+}
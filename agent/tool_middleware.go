@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"time"
+)
+
+// ToolExecution 一次工具执行的信息，中间件可以在调用下一层前修改Args
+type ToolExecution struct {
+	Name   string                 // 工具名称
+	CallID string                 // 工具调用ID
+	Args   map[string]interface{} // 调用参数
+	Loop   int                    // 当前循环次数
+}
+
+// ToolResult 工具执行结果，中间件可以改写后返回
+type ToolResult struct {
+	Output   string        // 工具输出
+	Err      error         // 执行错误，返回给模型时作为执行错误处理
+	Duration time.Duration // 处理函数的执行耗时，中间件直接返回结果时为0
+}
+
+// ToolHandlerFunc 中间件链中的一层
+type ToolHandlerFunc func(ctx context.Context, exec *ToolExecution) ToolResult
+
+// ToolMiddleware 工具中间件，可用于日志、指标、权限检查、结果截断等
+// 调用next执行后续中间件和工具处理函数，不调用next则直接返回结果(短路)
+type ToolMiddleware func(next ToolHandlerFunc) ToolHandlerFunc
+
+// chainToolMiddlewares 按顺序组装中间件，第一个中间件在最外层
+func chainToolMiddlewares(handler ToolHandlerFunc, middlewares ...[]ToolMiddleware) ToolHandlerFunc {
+	var all []ToolMiddleware
+	for _, m := range middlewares {
+		all = append(all, m...)
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		handler = all[i](handler)
+	}
+	return handler
+}
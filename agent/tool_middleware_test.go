@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordMiddleware 记录经过的顺序，并在结果后追加名称
+func recordMiddleware(name string, order *[]string) ToolMiddleware {
+	return func(next ToolHandlerFunc) ToolHandlerFunc {
+		return func(ctx context.Context, exec *ToolExecution) ToolResult {
+			*order = append(*order, name)
+			result := next(ctx, exec)
+			result.Output += "|" + name
+			return result
+		}
+	}
+}
+
+func TestToolMiddlewareChain(t *testing.T) {
+	var order []string
+	var seen ToolResult
+	observe := func(next ToolHandlerFunc) ToolHandlerFunc {
+		return func(ctx context.Context, exec *ToolExecution) ToolResult {
+			seen = next(ctx, exec)
+			return seen
+		}
+	}
+	// 改写参数
+	rewrite := func(next ToolHandlerFunc) ToolHandlerFunc {
+		return func(ctx context.Context, exec *ToolExecution) ToolResult {
+			exec.Args = map[string]interface{}{"city": "上海"}
+			return next(ctx, exec)
+		}
+	}
+
+	stage := &toolStage{
+		tools: map[string]Tool{
+			"weather": {
+				Function: FunctionDefinitionParam{Name: "weather"},
+				Handler: func(args map[string]interface{}) (string, error) {
+					time.Sleep(time.Millisecond)
+					return fmt.Sprint(args["city"]), nil
+				},
+				Options: ToolOptions{Middlewares: []ToolMiddleware{recordMiddleware("tool", &order), rewrite, observe}},
+			},
+		},
+		middlewares: []ToolMiddleware{recordMiddleware("global", &order)},
+		logger:      discardLogger,
+	}
+
+	calls := []FunctionCall{{ID: "call_1", Name: "weather", Args: map[string]interface{}{"city": "北京"}}}
+	responses, _, err := stage.run(context.Background(), calls, nil)
+	if err != nil {
+		t.Fatalf("执行失败: %v", err)
+	}
+	if got := responses[0].Result["output"]; got != "上海|tool|global" {
+		t.Errorf("中间件结果错误: %v", got)
+	}
+	if strings.Join(order, ",") != "global,tool" {
+		t.Errorf("中间件顺序错误: %v", order)
+	}
+	if seen.Duration <= 0 {
+		t.Errorf("应记录执行耗时: %+v", seen)
+	}
+}
+
+func TestToolMiddlewareShortCircuit(t *testing.T) {
+	called := false
+	deny := func(next ToolHandlerFunc) ToolHandlerFunc {
+		return func(ctx context.Context, exec *ToolExecution) ToolResult {
+			if exec.Name == "delete_user" {
+				return ToolResult{Err: fmt.Errorf("没有权限")}
+			}
+			return next(ctx, exec)
+		}
+	}
+	var seenErr error
+	observe := func(next ToolHandlerFunc) ToolHandlerFunc {
+		return func(ctx context.Context, exec *ToolExecution) ToolResult {
+			result := next(ctx, exec)
+			seenErr = result.Err
+			return result
+		}
+	}
+
+	stage := &toolStage{
+		tools: map[string]Tool{
+			"delete_user": {
+				Function: FunctionDefinitionParam{Name: "delete_user"},
+				Handler: func(args map[string]interface{}) (string, error) {
+					called = true
+					return "ok", nil
+				},
+			},
+			"crash": {
+				Function: FunctionDefinitionParam{Name: "crash"},
+				Handler:  func(args map[string]interface{}) (string, error) { panic("boom") },
+			},
+		},
+		middlewares: []ToolMiddleware{observe, deny},
+		logger:      discardLogger,
+	}
+
+	responses, _, _ := stage.run(context.Background(), []FunctionCall{{ID: "call_1", Name: "delete_user"}}, nil)
+	if called || responses[0].Result["error_type"] != toolErrorExecution {
+		t.Errorf("中间件应短路执行: called=%v, %+v", called, responses[0].Result)
+	}
+
+	// 处理函数panic时中间件能看到错误
+	responses, _, _ = stage.run(context.Background(), []FunctionCall{{ID: "call_2", Name: "crash"}}, nil)
+	if seenErr == nil || !strings.Contains(seenErr.Error(), "panic") || responses[0].Result["error"] != true {
+		t.Errorf("中间件应看到panic错误: %v, %+v", seenErr, responses[0].Result)
+	}
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ResultOverflowPolicy 工具结果超出长度限制时的处理策略
+type ResultOverflowPolicy string
+
+const (
+	ResultTruncate  ResultOverflowPolicy = "truncate"  // 保留开头，末尾加截断标记
+	ResultHeadTail  ResultOverflowPolicy = "head_tail" // 保留开头和结尾，中间加省略标记
+	ResultSummarize ResultOverflowPolicy = "summarize" // 使用Summarizer总结，失败时按truncate处理
+)
+
+// ResultSummarizer 把过长的工具结果总结为不超过maxChars个字符的文本
+type ResultSummarizer func(ctx context.Context, toolName string, output string, maxChars int) (string, error)
+
+// ResultLimit 工具结果的长度限制，只影响发送给模型的内容，完整结果保存在FunctionResponse.FullOutput
+type ResultLimit struct {
+	MaxChars   int                  // 最大字符数，小于等于0时不限制
+	Policy     ResultOverflowPolicy // 超出时的处理策略，默认truncate
+	Summarizer ResultSummarizer     // summarize策略的总结函数，可使用NewAgentResultSummarizer
+}
+
+// resultLimitFor 工具自身的限制优先于全局限制
+func resultLimitFor(tool Tool, global *ResultLimit) *ResultLimit {
+	if tool.Options.ResultLimit != nil {
+		return tool.Options.ResultLimit
+	}
+	return global
+}
+
+// apply 按限制处理工具结果，返回发送给模型的内容以及是否超出限制
+func (l *ResultLimit) apply(ctx context.Context, toolName, output string) (string, bool) {
+	if l == nil || l.MaxChars <= 0 {
+		return output, false
+	}
+	total := utf8.RuneCountInString(output)
+	if total <= l.MaxChars {
+		return output, false
+	}
+
+	switch l.Policy {
+	case ResultHeadTail:
+		runes := []rune(output)
+		head := l.MaxChars / 2
+		tail := l.MaxChars - head
+		return fmt.Sprintf("%s\n...[结果过长，省略中间%d个字符]...\n%s", string(runes[:head]), total-l.MaxChars, string(runes[total-tail:])), true
+	case ResultSummarize:
+		if l.Summarizer != nil {
+			summary, err := l.Summarizer(ctx, toolName, output, l.MaxChars)
+			if err == nil && summary != "" {
+				return fmt.Sprintf("[结果过长(%d个字符)，以下为摘要]\n%s", total, summary), true
+			}
+		}
+	}
+	runes := []rune(output)
+	return fmt.Sprintf("%s\n...[结果过长，已截断，原长度%d个字符]", string(runes[:l.MaxChars]), total), true
+}
+
+// NewAgentResultSummarizer 使用agent总结过长的工具结果，建议使用没有注册工具的agent和便宜的模型
+func NewAgentResultSummarizer(summaryAgent Agent, modelName string) ResultSummarizer {
+	return func(ctx context.Context, toolName string, output string, maxChars int) (string, error) {
+		prompt := []ChatMessage{
+			{Role: "system", Content: fmt.Sprintf("以下是工具 %s 的返回结果，请在%d个字符以内总结其中的关键信息，保留具体的数值、名称和标识符。", toolName, maxChars)},
+			{Role: "user", Content: output},
+		}
+		_, history, err := summaryAgent.StreamRunConversationEvents(ctx, modelName, prompt, nil)
+		if err != nil {
+			return "", err
+		}
+		summary := lastAssistantContent(history)
+		if summary == "" {
+			return "", fmt.Errorf("总结结果为空")
+		}
+		return summary, nil
+	}
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResultLimitApply(t *testing.T) {
+	ctx := context.Background()
+	output := "一二三四五六七八九十"
+
+	if got, limited := (&ResultLimit{MaxChars: 10}).apply(ctx, "t", output); limited || got != output {
+		t.Errorf("未超出时不应处理: %q", got)
+	}
+
+	got, limited := (&ResultLimit{MaxChars: 4}).apply(ctx, "t", output)
+	if !limited || !strings.HasPrefix(got, "一二三四\n") || !strings.Contains(got, "原长度10个字符") {
+		t.Errorf("截断结果错误: %q", got)
+	}
+
+	got, _ = (&ResultLimit{MaxChars: 4, Policy: ResultHeadTail}).apply(ctx, "t", output)
+	if !strings.HasPrefix(got, "一二\n") || !strings.HasSuffix(got, "\n九十") || !strings.Contains(got, "省略中间6个字符") {
+		t.Errorf("保留首尾结果错误: %q", got)
+	}
+
+	summarize := &ResultLimit{MaxChars: 4, Policy: ResultSummarize, Summarizer: func(ctx context.Context, toolName, output string, maxChars int) (string, error) {
+		return "数字", nil
+	}}
+	if got, _ = summarize.apply(ctx, "t", output); !strings.HasSuffix(got, "\n数字") {
+		t.Errorf("总结结果错误: %q", got)
+	}
+
+	// 总结失败时按截断处理
+	summarize.Summarizer = func(ctx context.Context, toolName, output string, maxChars int) (string, error) {
+		return "", errors.New("失败")
+	}
+	if got, _ = summarize.apply(ctx, "t", output); !strings.HasPrefix(got, "一二三四\n") {
+		t.Errorf("总结失败时应截断: %q", got)
+	}
+}
+
+// 测试工具自身的限制优先于全局限制，完整结果保留在FullOutput
+func TestToolStageResultLimit(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	handler := func(args map[string]interface{}) (string, error) { return long, nil }
+	stage := &toolStage{
+		tools: map[string]Tool{
+			"global": {Function: FunctionDefinitionParam{Name: "global"}, Handler: handler},
+			"own":    {Function: FunctionDefinitionParam{Name: "own"}, Handler: handler, Options: ToolOptions{ResultLimit: &ResultLimit{MaxChars: 200}}},
+		},
+		resultLimit: &ResultLimit{MaxChars: 10},
+		logger:      discardLogger,
+	}
+
+	responses, _, err := stage.run(context.Background(), []FunctionCall{{ID: "1", Name: "global"}, {ID: "2", Name: "own"}}, nil)
+	if err != nil {
+		t.Fatalf("执行失败: %v", err)
+	}
+	if output := responses[0].Result["output"].(string); !strings.HasPrefix(output, strings.Repeat("a", 10)+"\n") || responses[0].Result["truncated"] != true {
+		t.Errorf("全局限制未生效: %+v", responses[0].Result)
+	}
+	if responses[0].FullOutput != long {
+		t.Errorf("应保留完整结果: %d", len(responses[0].FullOutput))
+	}
+	if responses[1].Result["output"] != long || responses[1].FullOutput != "" {
+		t.Errorf("工具自身的限制应优先: %+v", responses[1])
+	}
+}
@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidateToolArgs 按工具的参数定义(JSON Schema)检查模型给出的参数
+// 支持type、enum、required、properties、additionalProperties和items，可以是toolgen生成的Schema
+// 返回的错误包含所有不合法的参数，便于模型一次修正
+func ValidateToolArgs(schema map[string]interface{}, args map[string]interface{}) error {
+	if len(schema) == 0 {
+		return nil
+	}
+	var problems []string
+	validateValue(schema, args, "", &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// validateToolArgs 执行工具前检查参数
+func validateToolArgs(schema map[string]interface{}, args map[string]interface{}) error {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	return ValidateToolArgs(schema, args)
+}
+
+// validateValue 检查一个值，path为参数路径，根对象为空
+func validateValue(schema map[string]interface{}, value interface{}, path string, problems *[]string) {
+	if types := schemaTypes(schema); len(types) > 0 && !matchesAnyType(value, types) {
+		*problems = append(*problems, fmt.Sprintf("%s 应为%s类型，实际为%s", describePath(path), strings.Join(types, "或"), jsonTypeOf(value)))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 && !inEnum(value, enum) {
+		*problems = append(*problems, fmt.Sprintf("%s 的值 %s 不在可选值 %s 中", describePath(path), jsonText(value), jsonText(enum)))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		validateObject(schema, v, path, problems)
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateValue(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	}
+}
+
+// validateObject 检查对象的必填字段、已定义字段和额外字段
+func validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, problems *[]string) {
+	for _, name := range requiredFields(schema) {
+		if value, ok := obj[name]; !ok || value == nil {
+			*problems = append(*problems, fmt.Sprintf("缺少必填%s", describePath(joinPath(path, name))))
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := obj[name]
+		if propSchema, ok := properties[name].(map[string]interface{}); ok {
+			// 可选参数传null视为未传
+			if value != nil {
+				validateValue(propSchema, value, joinPath(path, name), problems)
+			}
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				*problems = append(*problems, fmt.Sprintf("不支持%s", describePath(joinPath(path, name))))
+			}
+		case map[string]interface{}:
+			if value != nil {
+				validateValue(extra, value, joinPath(path, name), problems)
+			}
+		}
+	}
+}
+
+// requiredFields 读取schema的required，兼容[]string和JSON解码得到的[]interface{}
+func requiredFields(schema map[string]interface{}) []string {
+	switch required := schema["required"].(type) {
+	case []string:
+		return required
+	case []interface{}:
+		names := make([]string, 0, len(required))
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// schemaTypes 读取schema的type，兼容字符串和数组写法
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{strings.ToLower(t)}
+	case []string:
+		return t
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, strings.ToLower(s))
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// matchesAnyType 判断值是否符合其中一种JSON类型
+func matchesAnyType(value interface{}, types []string) bool {
+	actual := jsonTypeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf 返回值对应的JSON类型，没有小数部分的数字为integer
+func jsonTypeOf(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+	switch v := value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		if f := rv.Float(); f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	case reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return rv.Kind().String()
+}
+
+// inEnum 判断值是否在可选值中，数字按数值比较
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, e := range enum {
+		if a, ok := toFloat(value); ok {
+			if b, ok := toFloat(e); ok && a == b {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(value, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat 把数字转换为float64
+func toFloat(value interface{}) (float64, bool) {
+	if n, ok := value.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "参数"
+	}
+	return "参数 " + path
+}
+
+func jsonText(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
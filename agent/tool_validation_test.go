@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateToolArgs(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":   map[string]interface{}{"type": "string"},
+			"age":    map[string]interface{}{"type": "integer"},
+			"role":   map[string]interface{}{"type": "string", "enum": []interface{}{"admin", "user"}},
+			"status": map[string]interface{}{"type": "integer", "enum": []interface{}{0, 1, 2}},
+			"tags":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"address": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+				"required":   []interface{}{"city"},
+			},
+		},
+		"required":             []interface{}{"name"},
+		"additionalProperties": false,
+	}
+
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want string // 错误信息应包含的内容，为空表示合法
+	}{
+		{"合法", map[string]interface{}{"name": "a", "age": float64(3), "status": float64(1), "tags": []interface{}{"x"}, "address": map[string]interface{}{"city": "北京"}}, ""},
+		{"可选参数为null", map[string]interface{}{"name": "a", "age": nil}, ""},
+		{"缺少必填", map[string]interface{}{}, "缺少必填参数 name"},
+		{"类型错误", map[string]interface{}{"name": "a", "age": "3"}, "参数 age 应为integer类型，实际为string"},
+		{"小数不是整数", map[string]interface{}{"name": "a", "age": 3.5}, "参数 age 应为integer类型"},
+		{"枚举", map[string]interface{}{"name": "a", "role": "root"}, `参数 role 的值 "root" 不在可选值 ["admin","user"] 中`},
+		{"数字枚举", map[string]interface{}{"name": "a", "status": float64(5)}, "参数 status 的值 5"},
+		{"数组元素", map[string]interface{}{"name": "a", "tags": []interface{}{"x", 1}}, "参数 tags[1] 应为string类型"},
+		{"嵌套对象", map[string]interface{}{"name": "a", "address": map[string]interface{}{}}, "缺少必填参数 address.city"},
+		{"额外参数", map[string]interface{}{"name": "a", "extra": 1}, "不支持参数 extra"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateToolArgs(schema, tt.args)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("不应返回错误: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("错误信息不符: got %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	// 所有问题一次返回
+	err := ValidateToolArgs(schema, map[string]interface{}{"age": "x", "role": "root"})
+	if err == nil || strings.Count(err.Error(), ";") != 2 {
+		t.Errorf("应返回全部错误: %v", err)
+	}
+}
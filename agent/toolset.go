@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"sort"
+)
+
+// toolsetKey 本次请求可用工具的ctx键
+type toolsetKey struct{}
+
+// WithTools 限制本次请求只能使用指定名称的已注册工具，不传名称时不提供任何工具
+// 同一个agent可以按功能为不同请求提供不同的工具集，恢复审批暂停的对话时应使用相同的设置
+func WithTools(ctx context.Context, names ...string) context.Context {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return context.WithValue(ctx, toolsetKey{}, set)
+}
+
+// selectTools 复制本次请求可用的工具，未通过WithTools限制时为全部已注册工具
+// 对话使用复制的工具集，注册和注销工具不影响进行中的对话
+func selectTools(ctx context.Context, tools map[string]Tool) map[string]Tool {
+	set, limited := ctx.Value(toolsetKey{}).(map[string]bool)
+	selected := make(map[string]Tool, len(tools))
+	for name, tool := range tools {
+		if !limited || set[name] {
+			selected[name] = tool
+		}
+	}
+	return selected
+}
+
+// sortedTools 按名称排序的工具列表，保证每次请求的工具顺序一致
+func sortedTools(tools map[string]Tool) []Tool {
+	list := make([]Tool, 0, len(tools))
+	for _, tool := range tools {
+		list = append(list, tool)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Function.Name < list[j].Function.Name
+	})
+	return list
+}
+
+// toolDefinitions 按名称排序的工具定义
+func toolDefinitions(tools map[string]Tool) []FunctionDefinitionParam {
+	list := sortedTools(tools)
+	defs := make([]FunctionDefinitionParam, 0, len(list))
+	for _, tool := range list {
+		defs = append(defs, tool.Function)
+	}
+	return defs
+}
@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestToolRegistration(t *testing.T) {
+	oa, err := NewOpenAIAgent(AgentConfig{APIKey: "test"})
+	if err != nil {
+		t.Fatalf("创建agent失败: %v", err)
+	}
+	handler := func(args map[string]interface{}) (string, error) { return "ok", nil }
+	for _, name := range []string{"search", "get_user", "delete_user"} {
+		if err := oa.RegisterTool(FunctionDefinitionParam{Name: name}, handler); err != nil {
+			t.Fatalf("注册工具失败: %v", err)
+		}
+	}
+
+	tools := oa.ListTools()
+	if len(tools) != 3 || tools[0].Name != "delete_user" || tools[2].Name != "search" {
+		t.Fatalf("工具列表错误: %+v", tools)
+	}
+
+	if err := oa.UnregisterTool("delete_user"); err != nil {
+		t.Fatalf("注销工具失败: %v", err)
+	}
+	if err := oa.UnregisterTool("delete_user"); !errors.Is(err, ErrToolNotFound) {
+		t.Errorf("注销不存在的工具应返回ErrToolNotFound: %v", err)
+	}
+	if tools = oa.ListTools(); len(tools) != 2 {
+		t.Errorf("注销后工具列表错误: %+v", tools)
+	}
+
+	// 按请求选择可用工具
+	params := oa.buildToolParams(selectTools(WithTools(context.Background(), "search", "missing"), oa.tools))
+	if len(params) != 1 || params[0].Function.Name != "search" {
+		t.Errorf("请求工具集错误: %+v", params)
+	}
+	if params := oa.buildToolParams(selectTools(WithTools(context.Background()), oa.tools)); len(params) != 0 {
+		t.Errorf("空工具集不应提供工具: %+v", params)
+	}
+	if params := oa.buildToolParams(selectTools(context.Background(), oa.tools)); len(params) != 2 {
+		t.Errorf("未限制时应提供全部工具: %+v", params)
+	}
+}
+
+// 测试不在本次工具集中的工具按未注册处理
+func TestToolStageHiddenTool(t *testing.T) {
+	all := map[string]Tool{
+		"search": {Function: FunctionDefinitionParam{Name: "search"}, Handler: func(args map[string]interface{}) (string, error) { return "ok", nil }},
+		"admin":  {Function: FunctionDefinitionParam{Name: "admin"}, Handler: func(args map[string]interface{}) (string, error) { return "ok", nil }},
+	}
+	stage := &toolStage{
+		tools:  selectTools(WithTools(context.Background(), "search"), all),
+		logger: discardLogger,
+	}
+	responses, _, err := stage.run(context.Background(), []FunctionCall{{ID: "1", Name: "admin"}}, nil)
+	if err != nil || responses[0].Result["error_type"] != toolErrorNotFound {
+		t.Errorf("隐藏的工具应按未注册处理: %+v, %v", responses, err)
+	}
+}
@@ -0,0 +1,374 @@
+package agenttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/562589540/agent-go/agent"
+)
+
+// ErrNoResponse 预设回复已用完
+var ErrNoResponse = errors.New("agenttest: 没有更多预设回复")
+
+// Response 预设的一轮模型回复
+type Response struct {
+	Text         []string             // 回答文本增量，依次推送，拼接后为助手消息内容
+	Thinking     []string             // 思考内容增量
+	ToolCalls    []agent.FunctionCall // 工具调用，执行已注册的工具后进入下一轮
+	Usage        *agent.TokenUsage    // 本轮token消耗
+	FinishReason string               // 结束原因，为空时按是否有工具调用填充tool_calls或stop
+	Err          error                // 本轮请求返回的错误，设置后忽略其他字段
+}
+
+// Request 模型收到的一次请求
+type Request struct {
+	ModelName string                          // 模型名称
+	Loop      int                             // 循环次数，从1开始
+	Messages  []agent.ChatMessage             // 发送给模型的完整历史
+	Tools     []agent.FunctionDefinitionParam // 已注册的工具
+}
+
+// Agent 按顺序回放预设回复的agent.Agent实现，不访问网络
+// 工具调用直接执行注册的处理函数，不经过参数校验、中间件和结果长度限制，
+// 需要测试这些功能时使用NewOpenAIServer或NewGeminiServer配合真实的agent
+type Agent struct {
+	MaxLoops int // 最大对话循环次数，默认为5
+
+	mu        sync.Mutex
+	responses []Response
+	requests  []Request
+	tools     map[string]agent.Tool
+	debug     bool
+}
+
+var _ agent.Agent = (*Agent)(nil)
+
+// NewAgent 创建按顺序回放预设回复的agent
+func NewAgent(responses ...Response) *Agent {
+	return &Agent{
+		MaxLoops:  5,
+		responses: responses,
+		tools:     make(map[string]agent.Tool),
+	}
+}
+
+// Enqueue 追加预设回复
+func (a *Agent) Enqueue(responses ...Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.responses = append(a.responses, responses...)
+}
+
+// Remaining 尚未使用的预设回复数量
+func (a *Agent) Remaining() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.responses)
+}
+
+// Requests 按顺序返回模型收到的所有请求
+func (a *Agent) Requests() []Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Request(nil), a.requests...)
+}
+
+// StreamRunConversation 实现Agent接口的流式对话方法
+func (a *Agent) StreamRunConversation(ctx context.Context, modelName string, history []agent.ChatMessage, handler agent.StreamHandler) (*agent.TokenUsage, []agent.ChatMessage, error) {
+	return a.StreamRunConversationEvents(ctx, modelName, history, handler.EventHandler())
+}
+
+// StreamRunConversationEvents 实现Agent接口的结构化事件流式对话方法
+func (a *Agent) StreamRunConversationEvents(ctx context.Context, modelName string, history []agent.ChatMessage, handler agent.StreamEventHandler) (*agent.TokenUsage, []agent.ChatMessage, error) {
+	return a.runConversation(ctx, modelName, history, handler, nil, nil)
+}
+
+// ResumeConversation 实现Agent接口的审批恢复方法
+func (a *Agent) ResumeConversation(ctx context.Context, pending *agent.PendingApproval, decisions []agent.ApprovalDecision, handler agent.StreamEventHandler) (*agent.TokenUsage, []agent.ChatMessage, error) {
+	if pending == nil {
+		return nil, nil, fmt.Errorf("待审批状态为空")
+	}
+	return a.runConversation(ctx, pending.ModelName, pending.History, handler, pending, decisions)
+}
+
+// RegisterTool 注册工具
+func (a *Agent) RegisterTool(function agent.FunctionDefinitionParam, handler agent.ToolFunction) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tools[function.Name] = agent.Tool{Function: function, Handler: handler}
+	return nil
+}
+
+// RegisterContextTool 注册带上下文的工具
+func (a *Agent) RegisterContextTool(function agent.FunctionDefinitionParam, handler agent.ContextToolFunction, options agent.ToolOptions) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tools[function.Name] = agent.Tool{Function: function, ContextHandler: handler, Options: options}
+	return nil
+}
+
+// UnregisterTool 注销工具
+func (a *Agent) UnregisterTool(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.tools[name]; !ok {
+		return &agent.ToolNotFoundError{Name: name}
+	}
+	delete(a.tools, name)
+	return nil
+}
+
+// ListTools 按名称排序列出已注册工具
+func (a *Agent) ListTools() []agent.FunctionDefinitionParam {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.toolDefinitions()
+}
+
+// SetDebug 设置调试模式，没有实际作用
+func (a *Agent) SetDebug(debug bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.debug = debug
+}
+
+func (a *Agent) toolDefinitions() []agent.FunctionDefinitionParam {
+	defs := make([]agent.FunctionDefinitionParam, 0, len(a.tools))
+	for _, tool := range a.tools {
+		defs = append(defs, tool.Function)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+	return defs
+}
+
+// next 记录请求并取出下一条预设回复
+func (a *Agent) next(modelName string, loop int, messages []agent.ChatMessage) (Response, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests = append(a.requests, Request{
+		ModelName: modelName,
+		Loop:      loop,
+		Messages:  append([]agent.ChatMessage(nil), messages...),
+		Tools:     a.toolDefinitions(),
+	})
+	if len(a.responses) == 0 {
+		return Response{}, ErrNoResponse
+	}
+	resp := a.responses[0]
+	a.responses = a.responses[1:]
+	return resp, nil
+}
+
+// runConversation 对话循环，与真实agent一致：历史只包含本次问题和之后产生的消息
+func (a *Agent) runConversation(
+	ctx context.Context,
+	modelName string,
+	history []agent.ChatMessage,
+	handler agent.StreamEventHandler,
+	resume *agent.PendingApproval,
+	decisions []agent.ApprovalDecision,
+) (*agent.TokenUsage, []agent.ChatMessage, error) {
+	emit := func(event agent.StreamEvent) {
+		if handler != nil {
+			handler(event)
+		}
+	}
+
+	tokenUsage := &agent.TokenUsage{}
+	var conversationHistory []agent.ChatMessage
+	if len(history) > 0 && history[len(history)-1].Role == "user" {
+		conversationHistory = append(conversationHistory, history[len(history)-1])
+	}
+	messages := append([]agent.ChatMessage(nil), history...)
+
+	// 执行一轮工具调用，有工具需要审批且没有决定时暂停
+	loop := 0
+	runTools := func(calls []agent.FunctionCall, decisions map[string]agent.ApprovalDecision) error {
+		responses, pending, err := a.runTools(ctx, calls, decisions)
+		if err != nil {
+			emit(agent.StreamEvent{Type: agent.EventError, Loop: loop, Err: err})
+			return err
+		}
+		if len(pending) > 0 {
+			approvalErr := &agent.ApprovalRequiredError{Pending: &agent.PendingApproval{
+				ModelName: modelName,
+				Loop:      loop,
+				History:   pausedHistory(history, conversationHistory),
+				ToolCalls: calls,
+				Requests:  pending,
+			}}
+			emit(agent.StreamEvent{Type: agent.EventApprovalRequired, Loop: loop, Approval: approvalErr.Pending})
+			return approvalErr
+		}
+		for i := range responses {
+			emit(agent.StreamEvent{Type: agent.EventToolResult, Loop: loop, ToolResult: &responses[i]})
+			msg := agent.ChatMessage{Role: "tool", FunctionResponses: []agent.FunctionResponse{responses[i]}}
+			conversationHistory = append(conversationHistory, msg)
+			messages = append(messages, msg)
+		}
+		return nil
+	}
+
+	if resume != nil {
+		loop = resume.Loop
+		decisionMap := make(map[string]agent.ApprovalDecision, len(decisions))
+		for _, d := range decisions {
+			decisionMap[d.CallID] = d
+		}
+		if err := runTools(resume.ToolCalls, decisionMap); err != nil {
+			return tokenUsage, conversationHistory, err
+		}
+	}
+
+	maxLoops := a.MaxLoops
+	if maxLoops <= 0 {
+		maxLoops = 5
+	}
+
+	for {
+		loop++
+		if loop > maxLoops {
+			err := &agent.MaxLoopsError{MaxLoops: maxLoops}
+			emit(agent.StreamEvent{Type: agent.EventError, Loop: loop, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+		if err := ctx.Err(); err != nil {
+			return tokenUsage, conversationHistory, err
+		}
+
+		emit(agent.StreamEvent{Type: agent.EventLoopIteration, Loop: loop})
+		resp, err := a.next(modelName, loop, messages)
+		if err == nil {
+			err = resp.Err
+		}
+		if err != nil {
+			emit(agent.StreamEvent{Type: agent.EventError, Loop: loop, Err: err})
+			return tokenUsage, conversationHistory, err
+		}
+
+		for _, text := range resp.Thinking {
+			emit(agent.StreamEvent{Type: agent.EventThinkingDelta, Loop: loop, Text: text})
+		}
+		for _, text := range resp.Text {
+			emit(agent.StreamEvent{Type: agent.EventTextDelta, Loop: loop, Text: text})
+		}
+		for i := range resp.ToolCalls {
+			emit(agent.StreamEvent{Type: agent.EventToolCallStarted, Loop: loop, ToolCall: &resp.ToolCalls[i]})
+		}
+
+		finishReason := resp.FinishReason
+		if finishReason == "" {
+			finishReason = "stop"
+			if len(resp.ToolCalls) > 0 {
+				finishReason = "tool_calls"
+			}
+		}
+		emit(agent.StreamEvent{Type: agent.EventFinishReason, Loop: loop, FinishReason: finishReason})
+
+		if resp.Usage != nil {
+			tokenUsage.Add(resp.Usage)
+			usageCopy := *tokenUsage
+			emit(agent.StreamEvent{Type: agent.EventUsage, Loop: loop, Usage: &usageCopy})
+		}
+
+		assistantMsg := agent.ChatMessage{
+			Role:      "assistant",
+			Content:   strings.Join(resp.Text, ""),
+			ToolCalls: resp.ToolCalls,
+		}
+		conversationHistory = append(conversationHistory, assistantMsg)
+		messages = append(messages, assistantMsg)
+
+		if len(resp.ToolCalls) == 0 {
+			return tokenUsage, conversationHistory, nil
+		}
+		if err := runTools(resp.ToolCalls, nil); err != nil {
+			return tokenUsage, conversationHistory, err
+		}
+	}
+}
+
+// runTools 按顺序执行工具调用，返回函数响应或需要审批的调用
+func (a *Agent) runTools(ctx context.Context, calls []agent.FunctionCall, decisions map[string]agent.ApprovalDecision) ([]agent.FunctionResponse, []agent.FunctionCall, error) {
+	a.mu.Lock()
+	tools := make(map[string]agent.Tool, len(a.tools))
+	for name, tool := range a.tools {
+		tools[name] = tool
+	}
+	a.mu.Unlock()
+
+	var pending []agent.FunctionCall
+	for _, call := range calls {
+		if tool, ok := tools[call.Name]; ok && tool.Options.RequireApproval {
+			if _, decided := decisions[call.ID]; !decided {
+				pending = append(pending, call)
+			}
+		}
+	}
+	if len(pending) > 0 {
+		return nil, pending, nil
+	}
+
+	responses := make([]agent.FunctionResponse, 0, len(calls))
+	for _, call := range calls {
+		args := call.Args
+		var output string
+		var err error
+
+		tool, ok := tools[call.Name]
+		decision, decided := decisions[call.ID]
+		switch {
+		case !ok:
+			err = &agent.ToolNotFoundError{Name: call.Name, CallID: call.ID}
+		case decided && decision.Action == agent.ApprovalEdit:
+			args = decision.Args
+			output, err = callTool(ctx, tool, args)
+		case decided && decision.Action != agent.ApprovalApprove:
+			reason := decision.Reason
+			if reason == "" {
+				reason = "未说明原因"
+			}
+			err = fmt.Errorf("用户拒绝执行工具 %s: %s", call.Name, reason)
+		default:
+			output, err = callTool(ctx, tool, args)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+
+		result := map[string]any{"output": output}
+		if err != nil {
+			result = map[string]any{"output": fmt.Sprintf("执行错误: %v", err), "error": true}
+		}
+		responses = append(responses, agent.FunctionResponse{ID: call.ID, Name: call.Name, Result: result})
+	}
+	return responses, nil, nil
+}
+
+// callTool 调用工具处理函数
+func callTool(ctx context.Context, tool agent.Tool, args map[string]any) (string, error) {
+	if tool.ContextHandler != nil {
+		return tool.ContextHandler(ctx, args)
+	}
+	if tool.Handler != nil {
+		return tool.Handler(args)
+	}
+	return "", fmt.Errorf("工具 %s 没有处理函数", tool.Function.Name)
+}
+
+// pausedHistory 审批暂停时恢复所需的完整历史，本次问题不重复添加
+func pausedHistory(history, conversationHistory []agent.ChatMessage) []agent.ChatMessage {
+	progressed := conversationHistory
+	if len(progressed) > 0 && progressed[0].Role == "user" && len(history) > 0 && history[len(history)-1].Role == "user" {
+		progressed = progressed[1:]
+	}
+	full := make([]agent.ChatMessage, 0, len(history)+len(progressed))
+	full = append(full, history...)
+	return append(full, progressed...)
+}
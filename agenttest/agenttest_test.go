@@ -0,0 +1,530 @@
+package agenttest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/562589540/agent-go/agent"
+	"github.com/562589540/agent-go/agenttest"
+)
+
+var echoTool = agent.FunctionDefinitionParam{
+	Name:        "echo",
+	Description: "原样返回text",
+	Parameters: map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+		"required":   []string{"text"},
+	},
+}
+
+func echo(args map[string]interface{}) (string, error) {
+	return fmt.Sprint(args["text"]), nil
+}
+
+// toolThenAnswer 先调用echo工具再回答的两轮响应
+func toolThenAnswer() []agenttest.Turn {
+	return []agenttest.Turn{
+		{
+			ToolCalls: []agent.FunctionCall{{ID: "call_1", Name: "echo", Args: map[string]any{"text": "你好"}}},
+			Usage:     &agent.TokenUsage{TotalTokens: 15, PromptTokens: 10, CompletionTokens: 5},
+		},
+		{
+			Thinking: []string{"工具返回了你好"},
+			Text:     []string{"工具说", "你好"},
+			Usage:    &agent.TokenUsage{TotalTokens: 30, PromptTokens: 20, CompletionTokens: 10, ReasoningTokens: 4},
+		},
+	}
+}
+
+func collect(events *[]agent.StreamEvent) agent.StreamEventHandler {
+	return func(event agent.StreamEvent) {
+		*events = append(*events, event)
+	}
+}
+
+func textOf(events []agent.StreamEvent, eventType agent.StreamEventType) string {
+	var sb strings.Builder
+	for _, e := range events {
+		if e.Type == eventType {
+			sb.WriteString(e.Text)
+		}
+	}
+	return sb.String()
+}
+
+func checkConversation(t *testing.T, usage *agent.TokenUsage, history []agent.ChatMessage, events []agent.StreamEvent) {
+	t.Helper()
+	if usage == nil || usage.TotalTokens != 45 || usage.PromptTokens != 30 || usage.CompletionTokens != 15 || usage.ReasoningTokens != 4 {
+		t.Errorf("token统计错误: %+v", usage)
+	}
+	checkHistory(t, history, events)
+}
+
+// checkHistory 检查toolThenAnswer产生的对话历史和流式事件
+func checkHistory(t *testing.T, history []agent.ChatMessage, events []agent.StreamEvent) {
+	t.Helper()
+	if len(history) != 4 {
+		t.Fatalf("应有用户、工具调用、工具结果、回答4条历史，实际%d条: %+v", len(history), history)
+	}
+	if call := history[1].ToolCalls; len(call) != 1 || call[0].Name != "echo" || call[0].Args["text"] != "你好" {
+		t.Errorf("工具调用错误: %+v", history[1])
+	}
+	if resp := history[2].FunctionResponses; len(resp) != 1 || resp[0].Result["output"] != "你好" {
+		t.Errorf("工具结果错误: %+v", history[2])
+	}
+	if history[3].Role != "assistant" || history[3].Content != "工具说你好" {
+		t.Errorf("回答错误: %+v", history[3])
+	}
+	if got := textOf(events, agent.EventTextDelta); got != "工具说你好" {
+		t.Errorf("文本增量错误: %q", got)
+	}
+	if got := textOf(events, agent.EventThinkingDelta); got != "工具返回了你好" {
+		t.Errorf("思考增量错误: %q", got)
+	}
+}
+
+func TestAgentReplaysResponses(t *testing.T) {
+	var responses []agenttest.Response
+	for _, turn := range toolThenAnswer() {
+		responses = append(responses, agenttest.Response{Text: turn.Text, Thinking: turn.Thinking, ToolCalls: turn.ToolCalls, Usage: turn.Usage})
+	}
+	mock := agenttest.NewAgent(responses...)
+	mock.RegisterTool(echoTool, echo)
+
+	var events []agent.StreamEvent
+	usage, history, err := mock.StreamRunConversationEvents(context.Background(), "mock", []agent.ChatMessage{
+		{Role: "system", Content: "你是助手"},
+		{Role: "user", Content: "调用echo"},
+	}, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConversation(t, usage, history, events)
+
+	requests := mock.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("应收到2次请求，实际%d次", len(requests))
+	}
+	if n := len(requests[1].Messages); n != 4 {
+		t.Errorf("第二次请求应包含系统、用户、工具调用、工具结果4条消息，实际%d条", n)
+	}
+	if len(requests[0].Tools) != 1 || requests[0].Tools[0].Name != "echo" {
+		t.Errorf("请求工具错误: %+v", requests[0].Tools)
+	}
+
+	// 预设回复用完后返回错误
+	if _, _, err := mock.StreamRunConversationEvents(context.Background(), "mock", []agent.ChatMessage{{Role: "user", Content: "再来"}}, nil); !errors.Is(err, agenttest.ErrNoResponse) {
+		t.Errorf("预设回复用完应返回ErrNoResponse，实际%v", err)
+	}
+
+	// 预设错误原样返回
+	mock.Enqueue(agenttest.Response{Err: agent.ErrRateLimited})
+	if _, _, err := mock.StreamRunConversationEvents(context.Background(), "mock", []agent.ChatMessage{{Role: "user", Content: "再来"}}, nil); !errors.Is(err, agent.ErrRateLimited) {
+		t.Errorf("应返回预设错误，实际%v", err)
+	}
+}
+
+func TestAgentApproval(t *testing.T) {
+	mock := agenttest.NewAgent(
+		agenttest.Response{ToolCalls: []agent.FunctionCall{{ID: "call_1", Name: "delete", Args: map[string]any{"path": "/tmp/a"}}}},
+		agenttest.Response{Text: []string{"已删除"}},
+	)
+	var deleted string
+	mock.RegisterContextTool(agent.FunctionDefinitionParam{Name: "delete"}, func(ctx context.Context, args map[string]interface{}) (string, error) {
+		deleted = fmt.Sprint(args["path"])
+		return "ok", nil
+	}, agent.ToolOptions{RequireApproval: true})
+
+	_, _, err := mock.StreamRunConversationEvents(context.Background(), "mock", []agent.ChatMessage{{Role: "user", Content: "删除"}}, nil)
+	var approvalErr *agent.ApprovalRequiredError
+	if !errors.As(err, &approvalErr) {
+		t.Fatalf("应返回ApprovalRequiredError，实际%v", err)
+	}
+	if deleted != "" {
+		t.Fatal("审批前不应执行工具")
+	}
+
+	_, history, err := mock.ResumeConversation(context.Background(), approvalErr.Pending, []agent.ApprovalDecision{
+		{CallID: "call_1", Action: agent.ApprovalEdit, Args: map[string]interface{}{"path": "/tmp/b"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != "/tmp/b" {
+		t.Errorf("应按修改后的参数执行，实际%q", deleted)
+	}
+	if len(history) != 2 || history[1].Content != "已删除" {
+		t.Errorf("恢复后的历史错误: %+v", history)
+	}
+}
+
+func TestOpenAIAgentEndToEnd(t *testing.T) {
+	srv := agenttest.NewOpenAIServer(toolThenAnswer()...)
+	defer srv.Close()
+
+	oa, err := agent.NewOpenAIAgent(srv.AgentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oa.RegisterTool(echoTool, echo)
+
+	var events []agent.StreamEvent
+	usage, history, err := oa.StreamRunConversationEvents(context.Background(), "gpt-test", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConversation(t, usage, history, events)
+
+	requests := srv.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("应收到2次请求，实际%d次", len(requests))
+	}
+	var body struct {
+		Model    string           `json:"model"`
+		Stream   bool             `json:"stream"`
+		Tools    []map[string]any `json:"tools"`
+		Messages []struct {
+			Role       string `json:"role"`
+			ToolCallID string `json:"tool_call_id"`
+			Content    any    `json:"content"`
+		} `json:"messages"`
+	}
+	if err := requests[1].Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Model != "gpt-test" || !body.Stream || len(body.Tools) != 1 {
+		t.Errorf("请求参数错误: %+v", body)
+	}
+	if n := len(body.Messages); n != 3 || body.Messages[2].Role != "tool" || body.Messages[2].ToolCallID != "call_1" {
+		t.Errorf("第二次请求应带上工具结果: %+v", body.Messages)
+	}
+	if auth := requests[0].Header.Get("Authorization"); auth != "Bearer test-key" {
+		t.Errorf("Authorization错误: %q", auth)
+	}
+}
+
+func TestGeminiAgentEndToEnd(t *testing.T) {
+	srv := agenttest.NewGeminiServer(toolThenAnswer()...)
+	defer srv.Close()
+
+	ga, err := agent.NewGeminiAgent(srv.AgentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga.RegisterTool(echoTool, echo)
+
+	var events []agent.StreamEvent
+	usage, history, err := ga.StreamRunConversationEvents(context.Background(), "gemini-test", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConversation(t, usage, history, events)
+
+	requests := srv.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("应收到2次请求，实际%d次", len(requests))
+	}
+	if !strings.Contains(requests[0].Path, "models/gemini-test:streamGenerateContent") {
+		t.Errorf("请求路径错误: %s", requests[0].Path)
+	}
+	var body struct {
+		Contents []struct {
+			Role  string           `json:"role"`
+			Parts []map[string]any `json:"parts"`
+		} `json:"contents"`
+	}
+	if err := requests[1].Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(body.Contents); n != 3 || body.Contents[2].Parts[0]["functionResponse"] == nil {
+		t.Errorf("第二次请求应带上工具结果: %+v", body.Contents)
+	}
+}
+
+func TestAnthropicAgentEndToEnd(t *testing.T) {
+	srv := agenttest.NewAnthropicServer(toolThenAnswer()...)
+	defer srv.Close()
+
+	aa, err := agent.NewAnthropicAgent(srv.AgentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aa.RegisterTool(echoTool, echo)
+
+	var events []agent.StreamEvent
+	usage, history, err := aa.StreamRunConversationEvents(context.Background(), "claude-test", []agent.ChatMessage{
+		{Role: "system", Content: "你是助手"},
+		{Role: "user", Content: "调用echo"},
+	}, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Anthropic不单独返回思考token
+	if usage == nil || usage.TotalTokens != 45 || usage.PromptTokens != 30 || usage.CompletionTokens != 15 {
+		t.Errorf("token统计错误: %+v", usage)
+	}
+	checkHistory(t, history, events)
+
+	requests := srv.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("应收到2次请求，实际%d次", len(requests))
+	}
+	var body struct {
+		Model     string           `json:"model"`
+		MaxTokens int              `json:"max_tokens"`
+		System    string           `json:"system"`
+		Stream    bool             `json:"stream"`
+		Tools     []map[string]any `json:"tools"`
+		Messages  []struct {
+			Role    string           `json:"role"`
+			Content []map[string]any `json:"content"`
+		} `json:"messages"`
+	}
+	if err := requests[1].Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Model != "claude-test" || body.System != "你是助手" || body.MaxTokens <= 0 || !body.Stream || len(body.Tools) != 1 {
+		t.Errorf("请求参数错误: %+v", body)
+	}
+	if n := len(body.Messages); n != 3 || body.Messages[1].Content[0]["type"] != "tool_use" ||
+		body.Messages[2].Role != "user" || body.Messages[2].Content[0]["type"] != "tool_result" || body.Messages[2].Content[0]["tool_use_id"] != "call_1" {
+		t.Errorf("第二次请求应带上工具调用和工具结果: %+v", body.Messages)
+	}
+	if key := requests[0].Header.Get("X-Api-Key"); key != "test-key" {
+		t.Errorf("x-api-key错误: %q", key)
+	}
+	if version := requests[0].Header.Get("Anthropic-Version"); version == "" {
+		t.Error("缺少anthropic-version请求头")
+	}
+}
+
+func TestAnthropicThinkingResume(t *testing.T) {
+	srv := agenttest.NewAnthropicServer(agenttest.Turn{
+		Thinking:  []string{"需要调用echo"},
+		ToolCalls: []agent.FunctionCall{{ID: "call_1", Name: "echo", Args: map[string]any{"text": "你好"}}},
+	}, agenttest.Turn{Text: []string{"你好"}})
+	defer srv.Close()
+
+	config := srv.AgentConfig()
+	config.Thinking = &agent.ThinkingConfig{Enabled: true, BudgetTokens: 2048}
+	aa, err := agent.NewAnthropicAgent(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aa.RegisterContextTool(echoTool, func(ctx context.Context, args map[string]interface{}) (string, error) {
+		return echo(args)
+	}, agent.ToolOptions{RequireApproval: true})
+
+	_, _, err = aa.StreamRunConversationEvents(context.Background(), "claude-test", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, nil)
+	var approvalErr *agent.ApprovalRequiredError
+	if !errors.As(err, &approvalErr) {
+		t.Fatalf("应暂停等待审批，实际%v", err)
+	}
+
+	// 暂停状态序列化保存后恢复
+	data, err := json.Marshal(approvalErr.Pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending agent.PendingApproval
+	if err := json.Unmarshal(data, &pending); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := aa.ResumeConversation(context.Background(), &pending, []agent.ApprovalDecision{{CallID: "call_1", Action: agent.ApprovalApprove}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		Thinking *struct {
+			Type string `json:"type"`
+		} `json:"thinking"`
+		Messages []struct {
+			Role    string           `json:"role"`
+			Content []map[string]any `json:"content"`
+		} `json:"messages"`
+	}
+	var body request
+	if err := srv.Requests()[1].Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Thinking == nil || len(body.Messages) != 3 {
+		t.Fatalf("恢复后的请求应开启思考并带上工具结果: %+v", body)
+	}
+	if block := body.Messages[1].Content[0]; block["type"] != "thinking" || block["thinking"] != "需要调用echo" || block["signature"] != "agenttest-signature" {
+		t.Errorf("恢复后的助手消息应以带签名的思考块开头: %+v", body.Messages[1].Content)
+	}
+
+	// 历史中的工具调用没有思考块(如从其他供应商降级)时，本轮关闭思考
+	srv.Enqueue(agenttest.Turn{Text: []string{"你好"}})
+	history := []agent.ChatMessage{
+		{Role: "user", Content: "调用echo"},
+		{Role: "assistant", ToolCalls: []agent.FunctionCall{{ID: "call_1", Name: "echo", Args: map[string]any{"text": "你好"}}}},
+		{Role: "tool", FunctionResponses: []agent.FunctionResponse{{ID: "call_1", Name: "echo", Result: map[string]any{"output": "你好"}}}},
+	}
+	if _, _, err := aa.StreamRunConversationEvents(context.Background(), "claude-test", history, nil); err != nil {
+		t.Fatal(err)
+	}
+	body = request{}
+	if err := srv.Requests()[2].Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Thinking != nil {
+		t.Errorf("工具调用没有思考块时不应开启思考: %+v", body)
+	}
+}
+
+func TestStreamHandlerRetry(t *testing.T) {
+	// 第一次请求输出部分回答后断流，第二次完整返回
+	stream := func(complete bool) string {
+		events := []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":3}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"你好，"}}`,
+		}
+		if complete {
+			events = append(events,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"世界"}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}`,
+				`{"type":"message_stop"}`)
+		}
+		return "data: " + strings.Join(events, "\n\ndata: ") + "\n\n"
+	}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, stream(requests > 1))
+	}))
+	defer srv.Close()
+
+	aa, err := agent.NewAnthropicAgent(agent.AgentConfig{
+		BaseURL:     srv.URL,
+		Client:      srv.Client(),
+		RetryPolicy: &agent.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	_, history, err := aa.StreamRunConversation(context.Background(), "m", []agent.ChatMessage{{Role: "user", Content: "你好"}}, func(text string) {
+		out.WriteString(text)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("应重试1次，实际请求%d次", requests)
+	}
+	if out.String() != "你好，世界" || history[len(history)-1].Content != "你好，世界" {
+		t.Errorf("重试后不应重复输出: %q", out.String())
+	}
+}
+
+func TestToolCallAbortHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		server func(...agenttest.Turn) *agenttest.Server
+		create func(agent.AgentConfig) (agent.Agent, error)
+	}{
+		{"openai", agenttest.NewOpenAIServer, newOpenAI},
+		{"gemini", agenttest.NewGeminiServer, newGemini},
+		{"anthropic", agenttest.NewAnthropicServer, newAnthropic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tt.server(agenttest.Turn{ToolCalls: []agent.FunctionCall{
+				{ID: "call_1", Name: "echo", Args: map[string]any{"text": "你好"}},
+				{ID: "call_2", Name: "missing", Args: map[string]any{}},
+			}})
+			defer srv.Close()
+			config := srv.AgentConfig()
+			config.ToolCallErrorPolicy = agent.ToolCallAbort
+			a, err := tt.create(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			executed := false
+			a.RegisterTool(echoTool, func(args map[string]interface{}) (string, error) {
+				executed = true
+				return echo(args)
+			})
+
+			_, history, err := a.StreamRunConversationEvents(context.Background(), "m", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, nil)
+			if !errors.Is(err, agent.ErrToolNotFound) || executed {
+				t.Fatalf("应中止且不执行工具: %v, %v", err, executed)
+			}
+
+			// 每个工具调用都有对应的响应
+			responded := map[string]bool{}
+			for _, msg := range history {
+				for _, resp := range msg.FunctionResponses {
+					responded[resp.ID] = resp.Result["error"] == true
+				}
+			}
+			if len(history) < 3 || len(history[1].ToolCalls) != 2 {
+				t.Fatalf("历史应包含工具调用和响应: %+v", history)
+			}
+			for _, call := range history[1].ToolCalls {
+				if !responded[call.ID] {
+					t.Errorf("工具调用%s没有错误响应: %+v", call.ID, history)
+				}
+			}
+		})
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		server func(...agenttest.Turn) *agenttest.Server
+		create func(agent.AgentConfig) (agent.Agent, error)
+		status int
+		want   error
+	}{
+		{"openai限流", agenttest.NewOpenAIServer, newOpenAI, http.StatusTooManyRequests, agent.ErrRateLimited},
+		{"openai鉴权", agenttest.NewOpenAIServer, newOpenAI, http.StatusUnauthorized, agent.ErrAuthFailed},
+		{"gemini限流", agenttest.NewGeminiServer, newGemini, http.StatusTooManyRequests, agent.ErrRateLimited},
+		{"gemini鉴权", agenttest.NewGeminiServer, newGemini, http.StatusForbidden, agent.ErrAuthFailed},
+		{"anthropic限流", agenttest.NewAnthropicServer, newAnthropic, http.StatusTooManyRequests, agent.ErrRateLimited},
+		{"anthropic鉴权", agenttest.NewAnthropicServer, newAnthropic, http.StatusUnauthorized, agent.ErrAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tt.server(agenttest.Turn{StatusCode: tt.status, ErrorMessage: "模拟错误"})
+			defer srv.Close()
+			config := srv.AgentConfig()
+			a, err := tt.create(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = a.StreamRunConversationEvents(context.Background(), "m", []agent.ChatMessage{{Role: "user", Content: "你好"}}, nil)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("应返回%v，实际%v", tt.want, err)
+			}
+		})
+	}
+}
+
+func newOpenAI(config agent.AgentConfig) (agent.Agent, error) {
+	// 关闭SDK自带的重试，错误立即返回
+	config.RetryPolicy = &agent.RetryPolicy{}
+	return agent.NewOpenAIAgent(config)
+}
+
+func newGemini(config agent.AgentConfig) (agent.Agent, error) {
+	return agent.NewGeminiAgent(config)
+}
+
+func newAnthropic(config agent.AgentConfig) (agent.Agent, error) {
+	return agent.NewAnthropicAgent(config)
+}
@@ -0,0 +1,143 @@
+package agenttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// NewAnthropicServer 启动兼容Anthropic Messages API流式接口的本地服务
+// 使用AgentConfig()或把BaseURL设置为服务地址即可让AnthropicAgent访问本服务
+func NewAnthropicServer(turns ...Turn) *Server {
+	return newServer(anthropicProtocol{}, turns)
+}
+
+type anthropicProtocol struct{}
+
+func (anthropicProtocol) match(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/v1/messages")
+}
+
+func (anthropicProtocol) writeStream(w http.ResponseWriter, turn Turn) {
+	usage := map[string]any{"input_tokens": 0, "output_tokens": 0}
+	if u := turn.Usage; u != nil {
+		usage["input_tokens"] = u.PromptTokens - u.CacheTokens
+		usage["cache_read_input_tokens"] = u.CacheTokens
+	}
+	writeAnthropicEvent(w, map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":      "msg_agenttest",
+			"type":    "message",
+			"role":    "assistant",
+			"model":   "agenttest",
+			"content": []any{},
+			"usage":   usage,
+		},
+	})
+
+	index := 0
+	block := func(start map[string]any, deltas ...map[string]any) {
+		writeAnthropicEvent(w, map[string]any{"type": "content_block_start", "index": index, "content_block": start})
+		for _, delta := range deltas {
+			writeAnthropicEvent(w, map[string]any{"type": "content_block_delta", "index": index, "delta": delta})
+		}
+		writeAnthropicEvent(w, map[string]any{"type": "content_block_stop", "index": index})
+		index++
+	}
+
+	if len(turn.Thinking) > 0 {
+		var deltas []map[string]any
+		for _, text := range turn.Thinking {
+			deltas = append(deltas, map[string]any{"type": "thinking_delta", "thinking": text})
+		}
+		deltas = append(deltas, map[string]any{"type": "signature_delta", "signature": "agenttest-signature"})
+		block(map[string]any{"type": "thinking", "thinking": ""}, deltas...)
+	}
+	if len(turn.Text) > 0 {
+		var deltas []map[string]any
+		for _, text := range turn.Text {
+			deltas = append(deltas, map[string]any{"type": "text_delta", "text": text})
+		}
+		block(map[string]any{"type": "text", "text": ""}, deltas...)
+	}
+	for i, call := range turn.ToolCalls {
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("toolu_%d", i+1)
+		}
+		arguments := call.RawArgs
+		if arguments == "" && call.Args != nil {
+			data, _ := json.Marshal(call.Args)
+			arguments = string(data)
+		}
+		// 参数分两段推送
+		half := len(arguments) / 2
+		block(map[string]any{"type": "tool_use", "id": id, "name": call.Name, "input": map[string]any{}},
+			map[string]any{"type": "input_json_delta", "partial_json": arguments[:half]},
+			map[string]any{"type": "input_json_delta", "partial_json": arguments[half:]})
+	}
+
+	stopReason := turn.FinishReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+		if len(turn.ToolCalls) > 0 {
+			stopReason = "tool_use"
+		}
+	}
+	outputTokens := 0
+	if turn.Usage != nil {
+		outputTokens = turn.Usage.CompletionTokens
+	}
+	writeAnthropicEvent(w, map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": stopReason},
+		"usage": map[string]any{"output_tokens": outputTokens},
+	})
+	writeAnthropicEvent(w, map[string]any{"type": "message_stop"})
+}
+
+func (anthropicProtocol) writeError(w http.ResponseWriter, status int, turn Turn) {
+	code := turn.ErrorCode
+	if code == "" {
+		code = anthropicErrorType(status)
+	}
+	message := turn.ErrorMessage
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	writeJSON(w, status, map[string]any{
+		"type":  "error",
+		"error": map[string]any{"type": code, "message": message},
+	})
+}
+
+// writeAnthropicEvent 写入带event字段的SSE事件，事件名取data中的type
+func writeAnthropicEvent(w http.ResponseWriter, data map[string]any) {
+	io.WriteString(w, fmt.Sprintf("event: %s\n", data["type"]))
+	writeEvent(w, data)
+}
+
+// anthropicErrorType HTTP状态码对应的Anthropic错误类型
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
@@ -0,0 +1,90 @@
+package agenttest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+	"github.com/562589540/agent-go/agenttest"
+)
+
+func TestBudgetStopsBeforeTools(t *testing.T) {
+	srv := agenttest.NewOpenAIServer(agenttest.Turn{
+		Text:      []string{"先查一下"},
+		ToolCalls: []agent.FunctionCall{{ID: "call_1", Name: "echo", Args: map[string]any{"text": "你好"}}},
+		Usage:     &agent.TokenUsage{TotalTokens: 15, PromptTokens: 10, CompletionTokens: 5},
+	})
+	defer srv.Close()
+
+	config := srv.AgentConfig()
+	config.Budget = &agent.BudgetLimit{MaxTotalTokens: 10}
+	a, err := newOpenAI(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executed := 0
+	a.RegisterTool(echoTool, func(args map[string]interface{}) (string, error) {
+		executed++
+		return echo(args)
+	})
+
+	_, history, err := a.StreamRunConversationEvents(context.Background(), "m", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, nil)
+	if !errors.Is(err, agent.ErrBudgetExceeded) {
+		t.Fatalf("应返回超出预算，实际%v", err)
+	}
+	if executed != 0 {
+		t.Error("超出预算后不应执行工具")
+	}
+	// 历史中不应留下没有响应的工具调用
+	if len(history) != 2 || history[1].Content != "先查一下" || len(history[1].ToolCalls) != 0 {
+		t.Errorf("历史错误: %+v", history)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("应只请求1次，实际%d次", n)
+	}
+}
+
+func TestBudgetCheckedAfterResume(t *testing.T) {
+	srv := agenttest.NewOpenAIServer(agenttest.Turn{
+		ToolCalls: []agent.FunctionCall{{ID: "call_1", Name: "echo", Args: map[string]any{"text": "你好"}}},
+		Usage:     &agent.TokenUsage{TotalTokens: 15, PromptTokens: 10, CompletionTokens: 5},
+	}, agenttest.Turn{Text: []string{"不应请求"}})
+	defer srv.Close()
+
+	newAgent := func(limit int) agent.Agent {
+		config := srv.AgentConfig()
+		config.Budget = &agent.BudgetLimit{MaxTotalTokens: limit}
+		a, err := newOpenAI(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.RegisterContextTool(echoTool, func(ctx context.Context, args map[string]interface{}) (string, error) {
+			return echo(args)
+		}, agent.ToolOptions{RequireApproval: true})
+		return a
+	}
+
+	_, _, err := newAgent(20).StreamRunConversationEvents(context.Background(), "m", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, nil)
+	var approvalErr *agent.ApprovalRequiredError
+	if !errors.As(err, &approvalErr) {
+		t.Fatalf("应暂停等待审批，实际%v", err)
+	}
+	if approvalErr.Pending.Usage.TotalTokens != 15 {
+		t.Errorf("暂停状态应记录已用token: %+v", approvalErr.Pending.Usage)
+	}
+
+	// 以更小的预算恢复，暂停前的token计入预算
+	_, history, err := newAgent(10).ResumeConversation(context.Background(), approvalErr.Pending, []agent.ApprovalDecision{
+		{CallID: "call_1", Action: agent.ApprovalApprove},
+	}, nil)
+	if !errors.Is(err, agent.ErrBudgetExceeded) {
+		t.Fatalf("恢复后应返回超出预算，实际%v", err)
+	}
+	if len(history) != 1 || len(history[0].FunctionResponses) != 1 {
+		t.Errorf("历史应以工具响应结尾: %+v", history)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("超出预算后不应再请求模型，实际%d次", n)
+	}
+}
@@ -0,0 +1,329 @@
+package agenttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CassetteMode 录制回放模式
+type CassetteMode string
+
+const (
+	CassetteRecord CassetteMode = "record" // 请求真实服务并录制，Save时写入文件
+	CassetteReplay CassetteMode = "replay" // 只从文件回放，不访问网络
+	CassetteAuto   CassetteMode = "auto"   // 文件存在时回放，否则录制
+)
+
+// redacted 脱敏后的占位值
+const redacted = "REDACTED"
+
+// defaultRedactHeaders 默认脱敏的请求头和响应头
+var defaultRedactHeaders = []string{"Authorization", "X-Goog-Api-Key", "Api-Key", "X-Api-Key", "Cookie", "Set-Cookie"}
+
+// defaultRedactQuery 默认脱敏的URL查询参数
+var defaultRedactQuery = []string{"key", "api_key"}
+
+// Cassette 录制的请求响应记录，按请求顺序保存
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction 一次请求和对应的响应
+type Interaction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteRequest 录制的请求
+type CassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// CassetteResponse 录制的响应，流式响应保存完整的SSE文本
+type CassetteResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// RecorderConfig 录制回放配置
+type RecorderConfig struct {
+	Path          string            // cassette文件路径
+	Mode          CassetteMode      // 模式，默认auto
+	Transport     http.RoundTripper // 录制时实际发送请求的Transport，默认http.DefaultTransport
+	RedactHeaders []string          // 额外脱敏的请求头和响应头，默认已包含Authorization、X-Goog-Api-Key等
+	RedactQuery   []string          // 额外脱敏的URL查询参数，默认已包含key、api_key
+}
+
+// Recorder 录制和回放供应商HTTP流量的http.RoundTripper
+// 通过Client()设置到AgentConfig.Client，回放时按请求方法、路径和规范化后的请求体匹配
+type Recorder struct {
+	config   RecorderConfig
+	mode     CassetteMode
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool // 回放时已使用的记录
+}
+
+// ErrNoInteraction 回放时cassette中没有匹配的请求
+var ErrNoInteraction = errors.New("agenttest: cassette中没有匹配的请求")
+
+// NewRecorder 创建录制回放Transport，回放模式下读取cassette文件
+func NewRecorder(config RecorderConfig) (*Recorder, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("cassette文件路径为空")
+	}
+	if config.Transport == nil {
+		config.Transport = http.DefaultTransport
+	}
+
+	mode := config.Mode
+	if mode == "" {
+		mode = CassetteAuto
+	}
+	if mode == CassetteAuto {
+		mode = CassetteRecord
+		if _, err := os.Stat(config.Path); err == nil {
+			mode = CassetteReplay
+		}
+	}
+
+	r := &Recorder{config: config, mode: mode}
+	switch mode {
+	case CassetteReplay:
+		data, err := os.ReadFile(config.Path)
+		if err != nil {
+			return nil, fmt.Errorf("读取cassette错误: %w", err)
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("解析cassette错误: %w", err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	case CassetteRecord:
+	default:
+		return nil, fmt.Errorf("不支持的cassette模式: %s", mode)
+	}
+	return r, nil
+}
+
+// Mode 实际使用的模式，auto会解析为record或replay
+func (r *Recorder) Mode() CassetteMode {
+	return r.mode
+}
+
+// Client 返回使用该Transport的HTTP客户端
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Interactions 已录制或已加载的请求响应记录
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.cassette.Interactions...)
+}
+
+// Save 录制模式下把记录写入cassette文件，回放模式下不做任何事
+// 流式响应在读取完毕或关闭后才会完整记录，应在对话结束后调用
+func (r *Recorder) Save() error {
+	if r.mode != CassetteRecord {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("序列化cassette错误: %w", err)
+	}
+	if dir := filepath.Dir(r.config.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("创建cassette目录错误: %w", err)
+		}
+	}
+	if err := os.WriteFile(r.config.Path, data, 0o644); err != nil {
+		return fmt.Errorf("写入cassette错误: %w", err)
+	}
+	return nil
+}
+
+// RoundTrip 实现http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取请求体错误: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if r.mode == CassetteReplay {
+		return r.replay(req, body)
+	}
+	return r.record(req, body)
+}
+
+// replay 返回第一条未使用且匹配的记录
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	normalized := normalizeBody(body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !r.matches(interaction.Request, req, normalized) {
+			continue
+		}
+		r.used[i] = true
+		resp := interaction.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+			StatusCode:    resp.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        resp.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(resp.Body)),
+			ContentLength: int64(len(resp.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL.Path)
+}
+
+// matches 按请求方法、路径和规范化后的请求体匹配，不比较域名和查询参数
+func (r *Recorder) matches(recorded CassetteRequest, req *http.Request, normalized string) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+	if u, err := url.Parse(recorded.URL); err != nil || u.Path != req.URL.Path {
+		return false
+	}
+	return normalizeBody([]byte(recorded.Body)) == normalized
+}
+
+// record 发送真实请求，响应体读取完毕或关闭后写入记录
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	resp, err := r.config.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先占位保证记录顺序与请求顺序一致
+	r.mu.Lock()
+	index := len(r.cassette.Interactions)
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: CassetteRequest{
+			Method: req.Method,
+			URL:    r.redactURL(req),
+			Header: r.redactHeader(req.Header),
+			Body:   string(body),
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode,
+			Header:     r.redactHeader(resp.Header),
+		},
+	})
+	r.mu.Unlock()
+
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		done: func(data []byte) {
+			r.mu.Lock()
+			r.cassette.Interactions[index].Response.Body = string(data)
+			r.mu.Unlock()
+		},
+	}
+	return resp, nil
+}
+
+// redactHeader 复制请求头并脱敏
+func (r *Recorder) redactHeader(header http.Header) http.Header {
+	clone := header.Clone()
+	for _, names := range [][]string{defaultRedactHeaders, r.config.RedactHeaders} {
+		for _, name := range names {
+			if _, ok := clone[http.CanonicalHeaderKey(name)]; ok {
+				clone.Set(name, redacted)
+			}
+		}
+	}
+	return clone
+}
+
+// redactURL 脱敏URL中的密钥参数
+func (r *Recorder) redactURL(req *http.Request) string {
+	u := *req.URL
+	query := u.Query()
+	changed := false
+	for _, names := range [][]string{defaultRedactQuery, r.config.RedactQuery} {
+		for _, name := range names {
+			if query.Has(name) {
+				query.Set(name, redacted)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// normalizeBody JSON请求体按键排序后紧凑输出，其他内容去掉首尾空白
+func normalizeBody(body []byte) string {
+	var v any
+	if err := json.Unmarshal(body, &v); err == nil {
+		if data, err := json.Marshal(v); err == nil {
+			return string(data)
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// recordingBody 边读取边记录响应体，流式响应可以正常逐块读取
+type recordingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func(data []byte)
+	once sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+// Close 客户端在读完之前关闭时先读取剩余的响应体，避免录制的响应被截断
+func (b *recordingBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		if _, err = io.Copy(&b.buf, b.ReadCloser); err != nil {
+			err = fmt.Errorf("录制剩余响应体错误: %w", err)
+		}
+		b.done(b.buf.Bytes())
+	})
+	return errors.Join(err, b.ReadCloser.Close())
+}
+
+func (b *recordingBody) finish() {
+	b.once.Do(func() {
+		b.done(b.buf.Bytes())
+	})
+}
@@ -0,0 +1,130 @@
+package agenttest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+	"github.com/562589540/agent-go/agenttest"
+)
+
+func TestRecorderRecordAndReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		server func(...agenttest.Turn) *agenttest.Server
+		create func(agent.AgentConfig) (agent.Agent, error)
+	}{
+		{"openai", agenttest.NewOpenAIServer, newOpenAI},
+		{"gemini", agenttest.NewGeminiServer, newGemini},
+		{"anthropic", agenttest.NewAnthropicServer, newAnthropic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cassettes", tt.name+".json")
+			question := []agent.ChatMessage{{Role: "user", Content: "调用echo"}}
+
+			run := func(config agent.AgentConfig, recorder *agenttest.Recorder) ([]agent.ChatMessage, error) {
+				config.APIKey = "secret-api-key"
+				config.Client = recorder.Client()
+				a, err := tt.create(config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				a.RegisterTool(echoTool, echo)
+				_, history, err := a.StreamRunConversationEvents(context.Background(), "m", question, nil)
+				return history, err
+			}
+
+			// 录制
+			srv := tt.server(toolThenAnswer()...)
+			config := srv.AgentConfig()
+			recorder, err := agenttest.NewRecorder(agenttest.RecorderConfig{Path: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorder.Mode() != agenttest.CassetteRecord {
+				t.Fatalf("文件不存在时应录制，实际%s", recorder.Mode())
+			}
+			recorded, err := run(config, recorder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := recorder.Save(); err != nil {
+				t.Fatal(err)
+			}
+			srv.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), "secret-api-key") {
+				t.Error("cassette中不应包含API密钥")
+			}
+			if n := len(recorder.Interactions()); n != 2 {
+				t.Fatalf("应录制2次请求，实际%d次", n)
+			}
+			if body := recorder.Interactions()[1].Response.Body; !strings.Contains(body, "data: ") {
+				t.Errorf("应保存完整的SSE响应: %q", body)
+			}
+
+			// 回放，服务已关闭
+			replayer, err := agenttest.NewRecorder(agenttest.RecorderConfig{Path: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replayer.Mode() != agenttest.CassetteReplay {
+				t.Fatalf("文件存在时应回放，实际%s", replayer.Mode())
+			}
+			replayed, err := run(config, replayer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(replayed) != len(recorded) || replayed[len(replayed)-1].Content != recorded[len(recorded)-1].Content {
+				t.Errorf("回放结果与录制不一致:\n录制: %+v\n回放: %+v", recorded, replayed)
+			}
+
+			// 记录已用完或请求不同时返回错误
+			question = []agent.ChatMessage{{Role: "user", Content: "另一个问题"}}
+			if _, err := run(config, replayer); !errors.Is(err, agenttest.ErrNoInteraction) {
+				t.Errorf("没有匹配的记录应返回ErrNoInteraction，实际%v", err)
+			}
+		})
+	}
+}
+
+// 测试客户端在读完之前关闭响应体时仍录制完整的响应
+func TestRecorderEarlyClose(t *testing.T) {
+	const body = "data: {\"n\":1}\n\ndata: {\"n\":2}\n\ndata: [DONE]\n\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	recorder, err := agenttest.NewRecorder(agenttest.RecorderConfig{Path: filepath.Join(t.TempDir(), "early.json"), Mode: agenttest.CassetteRecord})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := recorder.Client().Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只读取第一个事件就关闭
+	if _, err := io.ReadFull(resp.Body, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := recorder.Interactions()[0].Response.Body; got != body {
+		t.Errorf("录制的响应被截断: %q", got)
+	}
+}
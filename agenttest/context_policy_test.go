@@ -0,0 +1,71 @@
+package agenttest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+	"github.com/562589540/agent-go/agenttest"
+)
+
+// 测试审批后恢复时沿用暂停前裁剪的历史，不再重复总结
+func TestContextPolicyResume(t *testing.T) {
+	for _, p := range providers {
+		t.Run(p.name, func(t *testing.T) {
+			srv := p.newServer(
+				agenttest.Turn{ToolCalls: []agent.FunctionCall{{ID: "call_1", Name: "delete", Args: map[string]any{"path": "/tmp/a"}}}},
+				agenttest.Turn{Text: []string{"已删除"}},
+			)
+			defer srv.Close()
+
+			summaries := 0
+			config := srv.AgentConfig()
+			config.ContextPolicy = &agent.ContextPolicy{
+				MaxPromptTokens: 2,
+				Strategy:        agent.ContextSummarize,
+				KeepLastN:       1,
+				Estimator:       func(messages []agent.ChatMessage) int { return len(messages) },
+				Summarizer: func(ctx context.Context, messages []agent.ChatMessage) (string, error) {
+					summaries++
+					return "用户之前问过天气", nil
+				},
+			}
+			a, err := p.newAgent(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.RegisterContextTool(agent.FunctionDefinitionParam{Name: "delete"}, func(ctx context.Context, args map[string]interface{}) (string, error) {
+				return "ok", nil
+			}, agent.ToolOptions{RequireApproval: true})
+
+			history := []agent.ChatMessage{
+				{Role: "system", Content: "你是助手"},
+				{Role: "user", Content: "今天天气如何"},
+				{Role: "assistant", Content: "晴天"},
+				{Role: "user", Content: "删除/tmp/a"},
+			}
+			_, _, err = a.StreamRunConversationEvents(context.Background(), "test", history, nil)
+			var approvalErr *agent.ApprovalRequiredError
+			if !errors.As(err, &approvalErr) {
+				t.Fatalf("应返回审批错误: %v", err)
+			}
+			if _, _, err := a.ResumeConversation(context.Background(), approvalErr.Pending, []agent.ApprovalDecision{{CallID: "call_1", Action: agent.ApprovalApprove}}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if summaries != 1 {
+				t.Errorf("应只总结一次，实际%d次", summaries)
+			}
+			requests := srv.Requests()
+			if len(requests) != 2 {
+				t.Fatalf("应收到2次请求，实际%d次", len(requests))
+			}
+			// 恢复后的请求使用裁剪后的历史
+			if body := string(requests[1].Body); !strings.Contains(body, "用户之前问过天气") || strings.Contains(body, "今天天气如何") {
+				t.Errorf("恢复后的请求历史错误: %s", body)
+			}
+		})
+	}
+}
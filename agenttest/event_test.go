@@ -0,0 +1,135 @@
+package agenttest_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+	"github.com/562589540/agent-go/agenttest"
+)
+
+// providers 各供应商的本地服务和对应的agent
+var providers = []struct {
+	name      string
+	newServer func(turns ...agenttest.Turn) *agenttest.Server
+	newAgent  func(config agent.AgentConfig) (agent.Agent, error)
+}{
+	{"openai", agenttest.NewOpenAIServer, newOpenAI},
+	{"gemini", agenttest.NewGeminiServer, newGemini},
+	{"anthropic", agenttest.NewAnthropicServer, newAnthropic},
+}
+
+// eventTypes 事件类型序列，连续相同类型的增量事件只保留一个
+func eventTypes(events []agent.StreamEvent) []agent.StreamEventType {
+	var types []agent.StreamEventType
+	for _, e := range events {
+		if n := len(types); n > 0 && types[n-1] == e.Type {
+			continue
+		}
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// 测试工具调用和回答两轮对话的事件顺序、类型和内容
+func TestStreamEvents(t *testing.T) {
+	want := []agent.StreamEventType{
+		agent.EventLoopIteration, agent.EventToolCallStarted, agent.EventToolCallArgsDelta,
+		agent.EventFinishReason, agent.EventUsage, agent.EventToolResult,
+		agent.EventLoopIteration, agent.EventThinkingDelta, agent.EventTextDelta,
+		agent.EventFinishReason, agent.EventUsage,
+	}
+	for _, p := range providers {
+		t.Run(p.name, func(t *testing.T) {
+			srv := p.newServer(toolThenAnswer()...)
+			defer srv.Close()
+			a, err := p.newAgent(srv.AgentConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.RegisterTool(echoTool, echo)
+
+			var events []agent.StreamEvent
+			if _, _, err := a.StreamRunConversationEvents(context.Background(), "test", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, collect(&events)); err != nil {
+				t.Fatal(err)
+			}
+			if got := eventTypes(events); !slices.Equal(got, want) {
+				t.Fatalf("事件顺序错误:\n实际 %v\n期望 %v", got, want)
+			}
+
+			loop := 0
+			var args strings.Builder
+			var usages []*agent.TokenUsage
+			for _, e := range events {
+				if e.Type == agent.EventLoopIteration {
+					loop++
+				}
+				if e.Loop != loop {
+					t.Errorf("%s事件的循环次数应为%d，实际%d", e.Type, loop, e.Loop)
+				}
+				switch e.Type {
+				case agent.EventToolCallStarted:
+					if e.ToolCall == nil || e.ToolCall.ID != "call_1" || e.ToolCall.Name != "echo" {
+						t.Errorf("工具调用开始事件错误: %+v", e.ToolCall)
+					}
+				case agent.EventToolCallArgsDelta:
+					if e.ToolCall == nil || e.ToolCall.ID != "call_1" {
+						t.Errorf("参数增量事件应带上工具调用: %+v", e)
+					}
+					args.WriteString(e.ArgsDelta)
+				case agent.EventToolResult:
+					if e.ToolResult == nil || e.ToolResult.ID != "call_1" || e.ToolResult.Result["output"] != "你好" {
+						t.Errorf("工具结果事件错误: %+v", e.ToolResult)
+					}
+				case agent.EventFinishReason:
+					if e.FinishReason == "" {
+						t.Error("结束原因为空")
+					}
+				case agent.EventUsage:
+					usages = append(usages, e.Usage)
+				}
+			}
+			if args.String() != `{"text":"你好"}` {
+				t.Errorf("参数增量拼接错误: %q", args.String())
+			}
+			if textOf(events, agent.EventTextDelta) != "工具说你好" || textOf(events, agent.EventThinkingDelta) != "工具返回了你好" {
+				t.Errorf("文本或思考增量错误: %+v", events)
+			}
+			// 使用统计为截止当前的累计值
+			if len(usages) != 2 || usages[0].TotalTokens != 15 || usages[1].TotalTokens != 45 || usages[1].PromptTokens != 30 {
+				t.Errorf("使用统计事件错误: %+v", usages)
+			}
+		})
+	}
+}
+
+// 测试纯文本回调只收到回答文本
+func TestStreamHandlerAdapter(t *testing.T) {
+	for _, p := range providers {
+		t.Run(p.name, func(t *testing.T) {
+			srv := p.newServer(toolThenAnswer()...)
+			defer srv.Close()
+			a, err := p.newAgent(srv.AgentConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.RegisterTool(echoTool, echo)
+
+			var chunks []string
+			_, history, err := a.StreamRunConversation(context.Background(), "test", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, func(text string) {
+				chunks = append(chunks, text)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(chunks, "|") != "工具说|你好" {
+				t.Errorf("回调收到的文本错误: %q", chunks)
+			}
+			if len(history) != 4 || history[3].Content != "工具说你好" {
+				t.Errorf("对话历史错误: %+v", history)
+			}
+		})
+	}
+}
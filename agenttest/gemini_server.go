@@ -0,0 +1,112 @@
+package agenttest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// NewGeminiServer 启动兼容Gemini streamGenerateContent接口的本地服务
+// 使用AgentConfig()或把BaseURL设置为服务地址即可让GeminiAgent访问本服务
+func NewGeminiServer(turns ...Turn) *Server {
+	return newServer(geminiProtocol{}, turns)
+}
+
+type geminiProtocol struct{}
+
+func (geminiProtocol) match(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, ":streamGenerateContent")
+}
+
+func (geminiProtocol) writeStream(w http.ResponseWriter, turn Turn) {
+	var chunks []map[string]any
+	for _, text := range turn.Thinking {
+		chunks = append(chunks, geminiChunk(map[string]any{"text": text, "thought": true}))
+	}
+	for _, text := range turn.Text {
+		chunks = append(chunks, geminiChunk(map[string]any{"text": text}))
+	}
+	// Gemini在一个数据块中返回完整的工具调用参数
+	if len(turn.ToolCalls) > 0 {
+		var parts []map[string]any
+		for _, call := range turn.ToolCalls {
+			functionCall := map[string]any{"name": call.Name, "args": call.Args}
+			if call.ID != "" {
+				functionCall["id"] = call.ID
+			}
+			parts = append(parts, map[string]any{"functionCall": functionCall})
+		}
+		chunks = append(chunks, geminiChunk(parts...))
+	}
+	if len(chunks) == 0 {
+		chunks = append(chunks, geminiChunk())
+	}
+
+	last := chunks[len(chunks)-1]
+	candidate := last["candidates"].([]map[string]any)[0]
+	candidate["finishReason"] = "STOP"
+	if turn.FinishReason != "" {
+		candidate["finishReason"] = turn.FinishReason
+	}
+	if u := turn.Usage; u != nil {
+		last["usageMetadata"] = map[string]any{
+			"promptTokenCount":        u.PromptTokens,
+			"candidatesTokenCount":    u.CompletionTokens - u.ReasoningTokens,
+			"thoughtsTokenCount":      u.ReasoningTokens,
+			"cachedContentTokenCount": u.CacheTokens,
+			"totalTokenCount":         u.TotalTokens,
+		}
+	}
+
+	for _, chunk := range chunks {
+		writeEvent(w, chunk)
+	}
+}
+
+func (geminiProtocol) writeError(w http.ResponseWriter, status int, turn Turn) {
+	code := turn.ErrorCode
+	if code == "" {
+		code = geminiStatus(status)
+	}
+	message := turn.ErrorMessage
+	if message == "" {
+		message = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	}
+	writeJSON(w, status, map[string]any{"error": map[string]any{
+		"code":    status,
+		"message": message,
+		"status":  code,
+	}})
+}
+
+// geminiChunk 包含一个候选回复的数据块
+func geminiChunk(parts ...map[string]any) map[string]any {
+	if parts == nil {
+		parts = []map[string]any{}
+	}
+	return map[string]any{
+		"candidates": []map[string]any{{
+			"content": map[string]any{"role": "model", "parts": parts},
+			"index":   0,
+		}},
+	}
+}
+
+// geminiStatus Google API按HTTP状态码对应的错误状态
+func geminiStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	}
+	return "INTERNAL"
+}
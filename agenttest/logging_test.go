@@ -0,0 +1,65 @@
+package agenttest_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+	"github.com/562589540/agent-go/agenttest"
+)
+
+func TestAgentLogging(t *testing.T) {
+	tests := []struct {
+		name   string
+		server func(...agenttest.Turn) *agenttest.Server
+		create func(agent.AgentConfig) (agent.Agent, error)
+	}{
+		{"openai", agenttest.NewOpenAIServer, newOpenAI},
+		{"gemini", agenttest.NewGeminiServer, newGemini},
+		{"anthropic", agenttest.NewAnthropicServer, newAnthropic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(level slog.Level) string {
+				srv := tt.server(toolThenAnswer()...)
+				defer srv.Close()
+				var buf bytes.Buffer
+				config := srv.AgentConfig()
+				config.APIKey = "secret-api-key"
+				config.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level}))
+				a, err := tt.create(config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				a.RegisterTool(echoTool, echo)
+				if _, _, err := a.StreamRunConversationEvents(context.Background(), "m", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, nil); err != nil {
+					t.Fatal(err)
+				}
+				return buf.String()
+			}
+
+			// Debug级别带结构化字段，不记录请求体
+			out := run(slog.LevelDebug)
+			for _, want := range []string{`"provider":"` + tt.name + `"`, `"msg":"执行工具"`, `"tool":"echo"`} {
+				if !strings.Contains(out, want) {
+					t.Errorf("Debug日志缺少%s: %s", want, out)
+				}
+			}
+			if strings.Contains(out, "调用echo") {
+				t.Errorf("Debug级别不应记录请求体: %s", out)
+			}
+
+			// LevelTrace记录脱敏后的请求体和响应体
+			out = run(agent.LevelTrace)
+			if !strings.Contains(out, `"msg":"HTTP请求"`) || !strings.Contains(out, "调用echo") || !strings.Contains(out, `"msg":"HTTP响应"`) {
+				t.Errorf("Trace级别应记录请求体和响应体: %s", out)
+			}
+			if strings.Contains(out, "secret-api-key") {
+				t.Errorf("日志中不应包含API密钥: %s", out)
+			}
+		})
+	}
+}
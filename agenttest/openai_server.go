@@ -0,0 +1,101 @@
+package agenttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NewOpenAIServer 启动兼容OpenAI chat completions流式接口的本地服务
+// 使用AgentConfig()或把BaseURL设置为服务地址即可让OpenAIAgent访问本服务
+func NewOpenAIServer(turns ...Turn) *Server {
+	return newServer(openAIProtocol{}, turns)
+}
+
+type openAIProtocol struct{}
+
+func (openAIProtocol) match(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/chat/completions")
+}
+
+func (openAIProtocol) writeStream(w http.ResponseWriter, turn Turn) {
+	created := time.Now().Unix()
+	chunk := func(choices []map[string]any) map[string]any {
+		return map[string]any{
+			"id":      "chatcmpl-agenttest",
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   "agenttest",
+			"choices": choices,
+		}
+	}
+	delta := func(d map[string]any) map[string]any {
+		return chunk([]map[string]any{{"index": 0, "delta": d}})
+	}
+
+	writeEvent(w, delta(map[string]any{"role": "assistant", "content": ""}))
+	for _, text := range turn.Thinking {
+		writeEvent(w, delta(map[string]any{"reasoning_content": text}))
+	}
+	for _, text := range turn.Text {
+		writeEvent(w, delta(map[string]any{"content": text}))
+	}
+	for i, call := range turn.ToolCalls {
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("call_%d", i+1)
+		}
+		arguments := call.RawArgs
+		if arguments == "" && call.Args != nil {
+			data, _ := json.Marshal(call.Args)
+			arguments = string(data)
+		}
+		writeEvent(w, delta(map[string]any{"tool_calls": []map[string]any{{
+			"index":    i,
+			"id":       id,
+			"type":     "function",
+			"function": map[string]any{"name": call.Name, "arguments": arguments},
+		}}}))
+	}
+
+	finishReason := turn.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+		if len(turn.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
+	}
+	writeEvent(w, chunk([]map[string]any{{"index": 0, "delta": map[string]any{}, "finish_reason": finishReason}}))
+
+	if u := turn.Usage; u != nil {
+		usage := chunk([]map[string]any{})
+		usage["usage"] = map[string]any{
+			"prompt_tokens":             u.PromptTokens,
+			"completion_tokens":         u.CompletionTokens,
+			"total_tokens":              u.TotalTokens,
+			"prompt_tokens_details":     map[string]any{"cached_tokens": u.CacheTokens},
+			"completion_tokens_details": map[string]any{"reasoning_tokens": u.ReasoningTokens},
+		}
+		writeEvent(w, usage)
+	}
+	io.WriteString(w, "data: [DONE]\n\n")
+}
+
+func (openAIProtocol) writeError(w http.ResponseWriter, status int, turn Turn) {
+	code := turn.ErrorCode
+	if code == "" {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	message := turn.ErrorMessage
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	writeJSON(w, status, map[string]any{"error": map[string]any{
+		"message": message,
+		"type":    "agenttest_error",
+		"code":    code,
+	}})
+}
@@ -0,0 +1,142 @@
+package agenttest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/562589540/agent-go/agent"
+)
+
+// Turn 供应商服务端预设的一次流式响应
+type Turn struct {
+	Text         []string             // 回答文本增量，每段一个数据块
+	Thinking     []string             // 思考内容增量，在回答之前推送
+	ToolCalls    []agent.FunctionCall // 工具调用，RawArgs不为空时原样作为OpenAI和Anthropic的参数
+	Usage        *agent.TokenUsage    // token消耗，在最后一个数据块中返回
+	FinishReason string               // 供应商原始的结束原因，为空时按协议填充默认值
+
+	StatusCode   int    // 非0且不是200时返回错误响应，忽略上面的字段
+	ErrorCode    string // 错误码，OpenAI的error.code、Gemini的error.status或Anthropic的error.type，为空时按状态码填充
+	ErrorMessage string // 错误信息
+}
+
+// RecordedRequest 服务端收到的请求
+type RecordedRequest struct {
+	Method string      // 请求方法
+	Path   string      // 请求路径
+	Header http.Header // 请求头
+	Body   []byte      // 请求体
+}
+
+// Decode 把请求体解码到v
+func (r RecordedRequest) Decode(v any) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Server 按顺序回放预设响应的本地供应商服务，每个请求使用一个Turn
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	turns    []Turn
+	requests []RecordedRequest
+	protocol protocol
+}
+
+// protocol 供应商协议
+type protocol interface {
+	match(r *http.Request) bool                              // 是否为支持的接口
+	writeStream(w http.ResponseWriter, turn Turn)            // 写入流式响应
+	writeError(w http.ResponseWriter, status int, turn Turn) // 写入错误响应
+}
+
+func newServer(p protocol, turns []Turn) *Server {
+	s := &Server{turns: turns, protocol: p}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Enqueue 追加预设响应
+func (s *Server) Enqueue(turns ...Turn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns = append(s.turns, turns...)
+}
+
+// Remaining 尚未使用的预设响应数量
+func (s *Server) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.turns)
+}
+
+// Requests 按顺序返回收到的所有请求
+func (s *Server) Requests() []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedRequest(nil), s.requests...)
+}
+
+// AgentConfig 返回指向本服务的agent配置
+func (s *Server) AgentConfig() agent.AgentConfig {
+	return agent.AgentConfig{
+		APIKey:  "test-key",
+		BaseURL: s.URL,
+		Client:  s.Client(),
+	}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !s.protocol.match(r) {
+		s.protocol.writeError(w, http.StatusNotFound, Turn{ErrorMessage: "agenttest: 不支持的接口 " + r.URL.Path})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	if len(s.turns) == 0 {
+		s.mu.Unlock()
+		s.protocol.writeError(w, http.StatusInternalServerError, Turn{ErrorMessage: ErrNoResponse.Error()})
+		return
+	}
+	turn := s.turns[0]
+	s.turns = s.turns[1:]
+	s.mu.Unlock()
+
+	if turn.StatusCode != 0 && turn.StatusCode != http.StatusOK {
+		s.protocol.writeError(w, turn.StatusCode, turn)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	s.protocol.writeStream(w, turn)
+}
+
+// writeEvent 写入一个SSE数据块
+func writeEvent(w http.ResponseWriter, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	io.WriteString(w, "data: "+string(payload)+"\n\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeJSON 写入JSON响应
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
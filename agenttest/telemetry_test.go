@@ -0,0 +1,291 @@
+package agenttest_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/562589540/agent-go/agent"
+	"github.com/562589540/agent-go/agenttest"
+)
+
+// spanRecorder 记录所有span的TracerProvider
+type spanRecorder struct {
+	tracenoop.TracerProvider
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{r: r}
+}
+
+func (r *spanRecorder) named(name string) []*recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var spans []*recordedSpan
+	for _, s := range r.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+type recordingTracer struct {
+	tracenoop.Tracer
+	r *spanRecorder
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	span := &recordedSpan{name: name, attrs: map[attribute.Key]attribute.Value{}}
+	if parent, ok := trace.SpanFromContext(ctx).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	span.SetAttributes(config.Attributes()...)
+	t.r.mu.Lock()
+	t.r.spans = append(t.r.spans, span)
+	t.r.mu.Unlock()
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordedSpan struct {
+	tracenoop.Span
+	mu     sync.Mutex
+	name   string
+	parent string
+	attrs  map[attribute.Key]attribute.Value
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+func (s *recordedSpan) attr(key string) attribute.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attrs[attribute.Key(key)]
+}
+
+// metricRecorder 记录所有指标数据点的MeterProvider
+type metricRecorder struct {
+	metricnoop.MeterProvider
+	mu     sync.Mutex
+	points map[string][]metricPoint
+}
+
+type metricPoint struct {
+	value float64
+	attrs attribute.Set
+}
+
+func (r *metricRecorder) Meter(string, ...metric.MeterOption) metric.Meter {
+	return recordingMeter{r: r}
+}
+
+func (r *metricRecorder) record(name string, value float64, attrs attribute.Set) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.points == nil {
+		r.points = map[string][]metricPoint{}
+	}
+	r.points[name] = append(r.points[name], metricPoint{value: value, attrs: attrs})
+}
+
+func (r *metricRecorder) get(name string) []metricPoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.points[name]
+}
+
+type recordingMeter struct {
+	metricnoop.Meter
+	r *metricRecorder
+}
+
+func (m recordingMeter) Float64Histogram(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	return float64Histogram{r: m.r, name: name}, nil
+}
+
+func (m recordingMeter) Int64Histogram(name string, _ ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	return int64Histogram{r: m.r, name: name}, nil
+}
+
+func (m recordingMeter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return int64Counter{r: m.r, name: name}, nil
+}
+
+type float64Histogram struct {
+	metricnoop.Float64Histogram
+	r    *metricRecorder
+	name string
+}
+
+func (h float64Histogram) Record(_ context.Context, v float64, opts ...metric.RecordOption) {
+	h.r.record(h.name, v, metric.NewRecordConfig(opts).Attributes())
+}
+
+type int64Histogram struct {
+	metricnoop.Int64Histogram
+	r    *metricRecorder
+	name string
+}
+
+func (h int64Histogram) Record(_ context.Context, v int64, opts ...metric.RecordOption) {
+	h.r.record(h.name, float64(v), metric.NewRecordConfig(opts).Attributes())
+}
+
+type int64Counter struct {
+	metricnoop.Int64Counter
+	r    *metricRecorder
+	name string
+}
+
+func (c int64Counter) Add(_ context.Context, v int64, opts ...metric.AddOption) {
+	c.r.record(c.name, float64(v), metric.NewAddConfig(opts).Attributes())
+}
+
+func attrValue(set attribute.Set, key string) string {
+	v, ok := set.Value(attribute.Key(key))
+	if !ok {
+		return ""
+	}
+	return v.Emit()
+}
+
+func TestAgentTelemetry(t *testing.T) {
+	turns := toolThenAnswer()
+	// 第一轮额外调用一个未注册的工具，统计为工具错误
+	turns[0].ToolCalls = append(turns[0].ToolCalls, agent.FunctionCall{ID: "call_2", Name: "missing", Args: map[string]any{}})
+	srv := agenttest.NewOpenAIServer(turns...)
+	defer srv.Close()
+
+	spans := &spanRecorder{}
+	metrics := &metricRecorder{}
+	config := srv.AgentConfig()
+	config.Telemetry = &agent.Telemetry{TracerProvider: spans, MeterProvider: metrics}
+	oa, err := agent.NewOpenAIAgent(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oa.RegisterTool(echoTool, echo)
+
+	if _, _, err := oa.StreamRunConversationEvents(context.Background(), "gpt-test", []agent.ChatMessage{{Role: "user", Content: "调用echo"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation := spans.named("invoke_agent gpt-test")
+	if len(conversation) != 1 {
+		t.Fatalf("应有1个对话span，实际%d个", len(conversation))
+	}
+	conv := conversation[0]
+	if !conv.ended || conv.attr("gen_ai.usage.input_tokens").AsInt64() != 30 || conv.attr("gen_ai.usage.output_tokens").AsInt64() != 15 || conv.attr("agent.loops").AsInt64() != 2 {
+		t.Errorf("对话span属性错误: %+v", conv.attrs)
+	}
+
+	for _, name := range []string{"loop 1", "loop 2"} {
+		if loops := spans.named(name); len(loops) != 1 || loops[0].parent != conv.name || !loops[0].ended {
+			t.Errorf("%s应为对话span的子span: %+v", name, loops)
+		}
+	}
+
+	requests := spans.named("chat gpt-test")
+	if len(requests) != 2 {
+		t.Fatalf("应有2个请求span，实际%d个", len(requests))
+	}
+	if r := requests[0]; r.parent != "loop 1" || r.attr("gen_ai.system").AsString() != "openai" ||
+		r.attr("gen_ai.usage.input_tokens").AsInt64() != 10 || r.attr("gen_ai.response.finish_reasons").AsStringSlice()[0] != "tool_calls" {
+		t.Errorf("第一次请求span属性错误: parent=%s %+v", r.parent, r.attrs)
+	}
+	if r := requests[1]; r.parent != "loop 2" || r.attr("gen_ai.usage.output_tokens").AsInt64() != 10 || r.attr("gen_ai.response.finish_reasons").AsStringSlice()[0] != "stop" {
+		t.Errorf("第二次请求span属性错误: parent=%s %+v", r.parent, r.attrs)
+	}
+
+	tools := spans.named("execute_tool echo")
+	if len(tools) != 1 || tools[0].parent != "loop 1" || tools[0].attr("gen_ai.tool.call.id").AsString() != "call_1" || tools[0].status == codes.Error {
+		t.Errorf("工具span错误: %+v", tools)
+	}
+
+	// 两次请求和一次对话的耗时
+	if n := len(metrics.get("gen_ai.client.operation.duration")); n != 3 {
+		t.Errorf("应记录3次耗时，实际%d次", n)
+	}
+	var input, output float64
+	for _, p := range metrics.get("gen_ai.client.token.usage") {
+		switch attrValue(p.attrs, "gen_ai.token.type") {
+		case "input":
+			input += p.value
+		case "output":
+			output += p.value
+		}
+	}
+	if input != 30 || output != 15 {
+		t.Errorf("token指标错误: input=%v output=%v", input, output)
+	}
+
+	calls := map[string]string{}
+	for _, p := range metrics.get("agent.tool.calls") {
+		calls[attrValue(p.attrs, "gen_ai.tool.name")] = attrValue(p.attrs, "error.type")
+	}
+	if len(calls) != 2 || calls["echo"] != "" || calls["missing"] != "tool_not_found" {
+		t.Errorf("工具调用指标错误: %v", calls)
+	}
+	if n := len(metrics.get("agent.tool.duration")); n != 1 {
+		t.Errorf("只有执行的工具记录耗时，实际%d次", n)
+	}
+}
+
+func TestAgentTelemetryError(t *testing.T) {
+	srv := agenttest.NewGeminiServer(agenttest.Turn{StatusCode: 429, ErrorMessage: "quota"})
+	defer srv.Close()
+
+	spans := &spanRecorder{}
+	metrics := &metricRecorder{}
+	config := srv.AgentConfig()
+	config.Telemetry = &agent.Telemetry{TracerProvider: spans, MeterProvider: metrics}
+	ga, err := agent.NewGeminiAgent(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ga.StreamRunConversationEvents(context.Background(), "gemini-test", []agent.ChatMessage{{Role: "user", Content: "你好"}}, nil); err == nil {
+		t.Fatal("应返回限流错误")
+	}
+
+	for _, name := range []string{"invoke_agent gemini-test", "chat gemini-test"} {
+		s := spans.named(name)
+		if len(s) != 1 || s[0].status != codes.Error || s[0].attr("error.type").AsString() != "rate_limited" {
+			t.Errorf("%s应记录限流错误: %+v", name, s)
+		}
+	}
+	for _, p := range metrics.get("gen_ai.client.operation.duration") {
+		if attrValue(p.attrs, "error.type") != "rate_limited" {
+			t.Errorf("耗时指标应带error.type: %v", p.attrs)
+		}
+	}
+}
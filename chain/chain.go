@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+
+	"github.com/562589540/agent-go/pkg/logging"
 )
 
 // ChainInput 定义链的输入类型
@@ -32,6 +35,16 @@ type BaseChain struct {
 	OutputKeys []string
 	// 链的内存组件(可选)
 	Memory Memory
+	// 结构化日志(可选)，为空时不输出
+	Logger *slog.Logger
+}
+
+// logger 返回链使用的日志记录器，未设置时丢弃所有日志
+func (c *BaseChain) logger() *slog.Logger {
+	if c.Logger == nil {
+		return logging.Discard
+	}
+	return c.Logger.With("chain", c.Name)
 }
 
 // GetInputKeys 返回链所需的输入键
@@ -141,9 +154,12 @@ func (c *SequentialChain) Run(ctx context.Context, input ChainInput) (ChainOutpu
 
 	// 当前输入，初始为传入的输入
 	currentInput := input
+	logger := c.logger()
 
 	// 顺序执行每个链
 	for i, chain := range c.Chains {
+		logger.DebugContext(ctx, "执行子链", "step", i, "total", len(c.Chains))
+
 		// 如果有记忆组件，从记忆中加载数据
 		if c.Memory != nil {
 			var err error
@@ -155,6 +171,7 @@ func (c *SequentialChain) Run(ctx context.Context, input ChainInput) (ChainOutpu
 
 		output, err := chain.Run(ctx, currentInput)
 		if err != nil {
+			logger.ErrorContext(ctx, "子链执行失败", "step", i, "error", err)
 			return nil, fmt.Errorf("执行链 %d 失败: %w", i, err)
 		}
 
@@ -67,6 +67,10 @@ func (c *LLMChain) Run(ctx context.Context, input ChainInput) (ChainOutput, erro
 		prompt = fmt.Sprintf("%s\n\n%s", prompt, formatInstr)
 	}
 
+	logger := c.logger()
+	logger.DebugContext(ctx, "调用LLM", "agent", c.AgentName, "model", c.ModelName)
+	logger.Log(ctx, agent.LevelTrace, "提示词", "prompt", prompt)
+
 	// 4. 调用LLM
 	var llmResponse string
 	var capturedResponse string
@@ -93,14 +97,17 @@ func (c *LLMChain) Run(ctx context.Context, input ChainInput) (ChainOutput, erro
 		streamHandler,
 	)
 	if err != nil {
+		logger.ErrorContext(ctx, "调用LLM失败", "agent", c.AgentName, "error", err)
 		return nil, fmt.Errorf("调用LLM失败: %w", err)
 	}
 
 	llmResponse = capturedResponse
+	logger.Log(ctx, agent.LevelTrace, "LLM响应", "response", llmResponse)
 
 	// 5. 解析LLM响应
 	parsedResponse, err := c.OutputParser.Parse(llmResponse)
 	if err != nil {
+		logger.WarnContext(ctx, "解析LLM响应失败", "error", err)
 		return nil, fmt.Errorf("解析响应失败: %w", err)
 	}
 
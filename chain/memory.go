@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"sync"
+
+	"github.com/562589540/agent-go/agent"
 )
 
 // 内存存储的键值对类型
@@ -108,6 +110,15 @@ type ConversationMemory struct {
 
 	// 最大保存的对话轮数，0表示不限制
 	MaxTurns int
+
+	// 对话历史存储，为空时只保存在内存中
+	Store agent.HistoryStore
+
+	// 存储中的对话ID
+	ConversationID string
+
+	// 是否已从存储加载历史
+	loaded bool
 }
 
 // NewConversationMemory 创建对话历史内存
@@ -127,11 +138,70 @@ func NewConversationMemory(humanKey, aiKey string, maxTurns int) *ConversationMe
 	return memory
 }
 
+// NewStoredConversationMemory 创建使用存储持久化的对话历史内存，首次加载时从存储读取历史
+func NewStoredConversationMemory(humanKey, aiKey string, maxTurns int, store agent.HistoryStore, conversationID string) *ConversationMemory {
+	memory := NewConversationMemory(humanKey, aiKey, maxTurns)
+	memory.Store = store
+	memory.ConversationID = conversationID
+	return memory
+}
+
+// LoadMemory 加载对话历史到输入，设置了存储时首次调用从存储读取
+func (m *ConversationMemory) LoadMemory(ctx context.Context, input ChainInput) (ChainInput, error) {
+	if err := m.loadFromStore(ctx); err != nil {
+		return nil, err
+	}
+	return m.SimpleMemory.LoadMemory(ctx, input)
+}
+
+// loadFromStore 从存储读取历史，用户消息和随后的助手回答组成一轮
+func (m *ConversationMemory) loadFromStore(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Store == nil || m.loaded {
+		return nil
+	}
+
+	messages, err := m.Store.Load(ctx, m.ConversationID)
+	if err != nil {
+		return fmt.Errorf("读取对话历史错误: %w", err)
+	}
+
+	var history []map[string]string
+	for _, msg := range messages {
+		switch msg.Role {
+		case "user":
+			history = append(history, map[string]string{m.HumanMessageKey: msg.Content})
+		case "assistant":
+			if msg.Content == "" {
+				continue
+			}
+			if len(history) == 0 || history[len(history)-1][m.AIMessageKey] != "" {
+				history = append(history, map[string]string{})
+			}
+			history[len(history)-1][m.AIMessageKey] = msg.Content
+		}
+	}
+	if m.MaxTurns > 0 && len(history) > m.MaxTurns {
+		history = history[len(history)-m.MaxTurns:]
+	}
+
+	m.History = history
+	m.Variables["conversation_history"] = m.formatHistory()
+	m.loaded = true
+	return nil
+}
+
 // GetConversationHistory 获取格式化的对话历史
 func (m *ConversationMemory) GetConversationHistory() string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return m.formatHistory()
+}
 
+// formatHistory 格式化对话历史，调用方需持有锁
+func (m *ConversationMemory) formatHistory() string {
 	var history string
 	for _, turn := range m.History {
 		if human, exists := turn[m.HumanMessageKey]; exists {
@@ -145,8 +215,12 @@ func (m *ConversationMemory) GetConversationHistory() string {
 	return history
 }
 
-// SaveContext 保存对话到历史中
+// SaveContext 保存对话到历史中，设置了存储时同时追加到存储
 func (m *ConversationMemory) SaveContext(ctx context.Context, input ChainInput, output ChainOutput) error {
+	if err := m.loadFromStore(ctx); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -178,7 +252,21 @@ func (m *ConversationMemory) SaveContext(ctx context.Context, input ChainInput,
 	}
 
 	// 将格式化的对话历史添加到变量中
-	m.Variables["conversation_history"] = m.GetConversationHistory()
+	m.Variables["conversation_history"] = m.formatHistory()
+
+	// 追加到存储
+	if m.Store != nil && len(turn) > 0 {
+		var messages []agent.ChatMessage
+		if human, exists := turn[m.HumanMessageKey]; exists {
+			messages = append(messages, agent.ChatMessage{Role: "user", Content: human})
+		}
+		if ai, exists := turn[m.AIMessageKey]; exists {
+			messages = append(messages, agent.ChatMessage{Role: "assistant", Content: ai})
+		}
+		if err := m.Store.Append(ctx, m.ConversationID, messages...); err != nil {
+			return fmt.Errorf("保存对话历史错误: %w", err)
+		}
+	}
 
 	return nil
 }
@@ -191,5 +279,13 @@ func (m *ConversationMemory) Clear() error {
 	m.History = []map[string]string{}
 	m.Variables = make(MemoryVariables)
 
+	// 同时删除存储中的对话
+	if m.Store != nil {
+		m.loaded = true
+		if err := m.Store.Delete(context.Background(), m.ConversationID); err != nil {
+			return fmt.Errorf("删除对话历史错误: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,61 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+)
+
+// 测试对话历史保存到存储后，新的内存组件能按轮次读取
+func TestStoredConversationMemory(t *testing.T) {
+	ctx := context.Background()
+	store := agent.NewMemoryHistoryStore()
+
+	memory := NewStoredConversationMemory("question", "answer", 2, store, "c1")
+	for _, turn := range [][2]string{{"问题1", "回答1"}, {"问题2", "回答2"}, {"问题3", "回答3"}} {
+		if err := memory.SaveContext(ctx, ChainInput{"question": turn[0]}, ChainOutput{"answer": turn[1]}); err != nil {
+			t.Fatalf("保存失败: %v", err)
+		}
+	}
+	if messages, _ := store.Load(ctx, "c1"); len(messages) != 6 || messages[0].Role != "user" || messages[1].Role != "assistant" {
+		t.Fatalf("存储的消息错误: %+v", messages)
+	}
+
+	// 新实例首次加载时读取存储，只保留最近MaxTurns轮
+	reloaded := NewStoredConversationMemory("question", "answer", 2, store, "c1")
+	input, err := reloaded.LoadMemory(ctx, ChainInput{"question": "问题4"})
+	if err != nil {
+		t.Fatalf("加载失败: %v", err)
+	}
+	want := "人类: 问题2\nAI: 回答2\n人类: 问题3\nAI: 回答3\n"
+	if input["conversation_history"] != want || input["question"] != "问题4" {
+		t.Errorf("加载的输入错误: %+v", input)
+	}
+	if len(reloaded.History) != 2 || reloaded.History[1]["question"] != "问题3" || reloaded.History[1]["answer"] != "回答3" {
+		t.Errorf("用户消息和回答应组成一轮: %+v", reloaded.History)
+	}
+
+	// 加载后继续保存，存储追加新的一轮
+	if err := reloaded.SaveContext(ctx, ChainInput{"question": "问题4"}, ChainOutput{"answer": "回答4"}); err != nil {
+		t.Fatalf("保存失败: %v", err)
+	}
+	if messages, _ := store.Load(ctx, "c1"); len(messages) != 8 || messages[7].Content != "回答4" {
+		t.Errorf("存储的消息错误: %+v", messages)
+	}
+
+	// 清除时删除存储中的对话
+	if err := reloaded.Clear(); err != nil {
+		t.Fatalf("清除失败: %v", err)
+	}
+	if messages, _ := store.Load(ctx, "c1"); len(messages) != 0 {
+		t.Errorf("清除后存储中仍有对话: %+v", messages)
+	}
+	if reloaded.GetConversationHistory() != "" {
+		t.Errorf("清除后内存中仍有历史: %q", reloaded.GetConversationHistory())
+	}
+	empty := NewStoredConversationMemory("question", "answer", 2, store, "c1")
+	if input, err := empty.LoadMemory(ctx, ChainInput{}); err != nil || input["conversation_history"] != "" {
+		t.Errorf("清除后新实例应读取到空历史: %+v, %v", input, err)
+	}
+}
@@ -9,12 +9,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/562589540/agent-go/pkg/logging"
 )
 
 const (
@@ -35,6 +38,16 @@ type AuthConfig struct {
 	AllowedIPs []string
 	// 签名过期时间（秒），默认5分钟
 	ExpireSeconds int
+	// 结构化日志（可选），为空时使用slog.Default()
+	Logger *slog.Logger
+}
+
+// logger 返回认证使用的日志记录器
+func (c AuthConfig) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
 }
 
 // 客户端：生成请求签名头
@@ -42,7 +55,7 @@ func GenerateAuthHeaders(config AuthConfig, params map[string]string) map[string
 	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
 	nonce := generateNonce(16)
 
-	signature := generateSignature(config.APIKey, timestamp, nonce, params)
+	signature := generateSignature(config.logger(), config.APIKey, timestamp, nonce, params)
 
 	return map[string]string{
 		HeaderAuthSignature: signature,
@@ -86,11 +99,13 @@ func VerifySignature(r *http.Request, config AuthConfig) error {
 		}
 	}
 
+	logger := config.logger()
+
 	// 从请求获取参数
-	params := extractRequestParams(r)
+	params := extractRequestParams(r, logger)
 
 	// 计算签名
-	expectedSignature := generateSignature(config.APIKey, timestamp, nonce, params)
+	expectedSignature := generateSignature(logger, config.APIKey, timestamp, nonce, params)
 
 	// 比较签名
 	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
@@ -101,7 +116,7 @@ func VerifySignature(r *http.Request, config AuthConfig) error {
 }
 
 // 生成签名
-func generateSignature(apiKey, timestamp, nonce string, params map[string]string) string {
+func generateSignature(logger *slog.Logger, apiKey, timestamp, nonce string, params map[string]string) string {
 	// 对参数按键排序
 	keys := make([]string, 0, len(params))
 	for k := range params {
@@ -128,15 +143,14 @@ func generateSignature(apiKey, timestamp, nonce string, params map[string]string
 	sb.WriteString("&key=")
 	sb.WriteString(apiKey)
 
-	// 打印签名前的完整字符串用于调试
+	// 签名字符串包含apiKey，不输出到日志
 	signString := sb.String()
-	fmt.Printf("签名字符串: %s\n", signString)
 
 	// 计算SHA256（不使用HMAC）
 	h := sha256.New()
 	h.Write([]byte(signString))
 	signature := hex.EncodeToString(h.Sum(nil))
-	fmt.Printf("计算签名: %s\n", signature)
+	logger.Debug("计算签名", "timestamp", timestamp, "nonce", nonce, "signature", signature)
 
 	return signature
 }
@@ -183,7 +197,7 @@ func isIPAllowed(ip string, allowedIPs []string) bool {
 }
 
 // 从请求中提取参数
-func extractRequestParams(r *http.Request) map[string]string {
+func extractRequestParams(r *http.Request, logger *slog.Logger) map[string]string {
 	params := make(map[string]string)
 
 	// 处理URL查询参数
@@ -228,10 +242,8 @@ func extractRequestParams(r *http.Request) map[string]string {
 						}
 					}
 
-					// 打印提取的JSON参数用于调试
-					fmt.Printf("提取的JSON参数: %v\n", params)
 				} else {
-					fmt.Printf("JSON解析错误: %v\n", err)
+					logger.Warn("解析JSON请求参数错误", "error", err)
 				}
 
 				// 由于已经读取了请求体，需要重置它以便后续处理
@@ -252,8 +264,8 @@ func extractRequestParams(r *http.Request) map[string]string {
 		}
 	}
 
-	// 打印最终提取的所有参数
-	fmt.Printf("提取的所有参数: %v\n", params)
+	// 参数可能包含请求体内容，只在LevelTrace输出
+	logger.Log(r.Context(), logging.LevelTrace, "提取的请求参数", "params", params)
 
 	return params
 }
@@ -0,0 +1,50 @@
+// Package logging 各包共用的日志级别和日志记录器适配
+package logging
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// LevelTrace 比Debug更详细的日志级别，启用后记录脱敏的请求体、响应体和请求Dump
+const LevelTrace = slog.LevelDebug - 4
+
+// Discard 丢弃所有日志的记录器，未设置日志记录器时使用
+var Discard = slog.New(discardHandler{})
+
+// discardHandler 丢弃所有日志
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// FromLogger 把标准库log.Logger适配为slog.Logger，为空时返回Discard
+// 以key=value格式输出Debug及以上级别的日志，时间和前缀由log.Logger添加
+func FromLogger(logger *log.Logger) *slog.Logger {
+	if logger == nil {
+		return Discard
+	}
+	return slog.New(slog.NewTextHandler(logWriter{logger}, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+// logWriter 把每条日志写入log.Logger
+type logWriter struct {
+	logger *log.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.logger.Print(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -7,7 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
@@ -53,7 +53,10 @@ type Authenticator interface {
 // --- QueryParamAuthenticator ---
 
 // QueryParamAuthenticator 是一个基于请求头的认证器
-type QueryParamAuthenticator struct{}
+type QueryParamAuthenticator struct {
+	// 结构化日志（可选），为空时使用slog.Default()
+	Logger *slog.Logger
+}
 
 // NewQueryParamAuthenticator 创建一个新的请求头认证器
 func NewQueryParamAuthenticator() *QueryParamAuthenticator {
@@ -63,8 +66,12 @@ func NewQueryParamAuthenticator() *QueryParamAuthenticator {
 // Authenticate 验证请求中的 x-goog-api-key 头
 func (a *QueryParamAuthenticator) Authenticate(r *http.Request) (bool, error) {
 	// 从请求头获取 x-goog-api-key
+	logger := a.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
 	clientKey := r.Header.Get("x-goog-api-key")
-	log.Printf("clientKey: %s", clientKey)
+	logger.Debug("收到客户端密钥", "key", maskKey(clientKey))
 	if clientKey == "" {
 		// 密钥缺失，视为认证失败，但不返回错误
 		return false, nil
@@ -72,7 +79,7 @@ func (a *QueryParamAuthenticator) Authenticate(r *http.Request) (bool, error) {
 
 	// 调用主服务器验证 key
 	// checkKeyInDatabase 现在可能返回 *AuthServerError
-	isValid, err := checkKeyInDatabase(clientKey)
+	isValid, err := checkKeyInDatabase(clientKey, logger)
 	if err != nil {
 		// 如果是 AuthServerError，直接返回
 		var authErr *AuthServerError
@@ -99,14 +106,14 @@ func (na *NilAuthenticator) Authenticate(r *http.Request) (bool, error) {
 
 // --- 修改数据库检查函数，使用主服务器认证 ---
 // checkKeyInDatabase 现在在主服务器返回非200时返回 *AuthServerError
-func checkKeyInDatabase(key string) (bool, error) {
+func checkKeyInDatabase(key string, logger *slog.Logger) (bool, error) {
 	var originalKey string
 
 	// 尝试将key当作临时token解密
 	decodedKey, err := DecodeToken(key)
 	if err != nil {
 		// 解密失败，继续使用原始key
-		fmt.Println("解密失败 DecodeToken", err)
+		logger.Warn("解密客户端临时token失败", "error", err)
 		return false, errors.New("通讯失败，请同步北京时间")
 	}
 
@@ -133,6 +140,7 @@ func checkKeyInDatabase(key string) (bool, error) {
 	authConfig := apiauth.AuthConfig{
 		APIKey:        apiKey,
 		ExpireSeconds: 300, // 5分钟过期
+		Logger:        logger,
 	}
 
 	// 生成认证头
@@ -192,7 +200,7 @@ func checkKeyInDatabase(key string) (bool, error) {
 		bodyBytes, readErr := io.ReadAll(resp.Body)
 		var errMsg string
 		if readErr != nil {
-			log.Printf("读取主服务器错误响应体失败: %v", readErr)
+			logger.Warn("读取主服务器错误响应体失败", "error", readErr)
 			errMsg = fmt.Sprintf("无法读取错误响应体 (状态码: %d)", resp.StatusCode)
 		} else {
 			errMsg = string(bodyBytes)
@@ -204,7 +212,7 @@ func checkKeyInDatabase(key string) (bool, error) {
 				errMsg = errorResp.Message // 使用 JSON 中的 message
 			}
 		}
-		log.Printf("主服务器返回错误 (状态码: %d): %s", resp.StatusCode, errMsg)
+		logger.Warn("主服务器返回错误", "status", resp.StatusCode, "message", errMsg)
 		// 返回自定义错误，包含原始状态码和消息
 		return false, &AuthServerError{
 			StatusCode: resp.StatusCode,
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/562589540/agent-go/pkg/logging"
 )
 
 // 限速相关常量
@@ -58,7 +61,7 @@ type DefaultDefenseSystem struct {
 	enableLogSuppression bool
 
 	// 日志记录器
-	logger *log.Logger
+	logger *slog.Logger
 
 	// 清理任务的上下文和取消函数
 	ctx    context.Context
@@ -153,8 +156,13 @@ func (ls *LogSuppressor) ClearExpiredRecords() {
 	}
 }
 
-// 创建新的防御系统
+// 创建新的防御系统，日志通过标准库log.Logger输出，为空时不输出
 func NewDefenseSystem(ctx context.Context, logger *log.Logger) *DefaultDefenseSystem {
+	return NewDefenseSystemWithLogger(ctx, logging.FromLogger(logger))
+}
+
+// NewDefenseSystemWithLogger 创建使用结构化日志的防御系统，日志记录器为空时不输出
+func NewDefenseSystemWithLogger(ctx context.Context, logger *slog.Logger) *DefaultDefenseSystem {
 	// 创建上下文和取消函数用于清理任务
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -186,7 +194,7 @@ func NewDefenseSystem(ctx context.Context, logger *log.Logger) *DefaultDefenseSy
 	domainBlocker := NewDomainBlocker(knownAttackerDomains)
 
 	if logger != nil {
-		logger.Printf("已预设 %d 个域名黑名单", len(knownAttackerDomains))
+		logger.Info("已预设域名黑名单", "domains", len(knownAttackerDomains))
 	}
 
 	return &DefaultDefenseSystem{
@@ -207,7 +215,7 @@ func (d *DefaultDefenseSystem) CheckRequest(clientIP, hostname string) (bool, st
 	// 检查域名黑名单
 	if d.enableDomainBlock && hostname != "" && d.domainBlocker.IsBlocked(hostname) {
 		if d.logger != nil && d.shouldLog(clientIP, true) {
-			d.logger.Printf("拒绝访问黑名单域名: %s 来自 %s", hostname, clientIP)
+			d.logger.Warn("拒绝访问黑名单域名", "host", hostname, "client", clientIP)
 		}
 		return false, fmt.Sprintf("域名 %s 已被禁止访问", hostname)
 	}
@@ -217,7 +225,7 @@ func (d *DefaultDefenseSystem) CheckRequest(clientIP, hostname string) (bool, st
 		allowed, message := d.rateLimiter.CheckAndUpdateLimit(clientIP)
 		if !allowed {
 			if d.logger != nil && d.shouldLog(clientIP, true) {
-				d.logger.Printf("拒绝来自 %s 的请求: %s", clientIP, message)
+				d.logger.Warn("触发速率限制，拒绝请求", "client", clientIP, "reason", message)
 			}
 			return false, message
 		}
@@ -238,7 +246,7 @@ func (d *DefaultDefenseSystem) shouldLog(ip string, isError bool) bool {
 func (d *DefaultDefenseSystem) EnableLogSuppression(enable bool) {
 	d.enableLogSuppression = enable
 	if d.logger != nil {
-		d.logger.Printf("日志抑制功能已%s", ifThenElse(enable, "启用", "禁用"))
+		d.logger.Info("日志抑制功能已" + ifThenElse(enable, "启用", "禁用"))
 	}
 }
 
@@ -246,7 +254,7 @@ func (d *DefaultDefenseSystem) EnableLogSuppression(enable bool) {
 func (d *DefaultDefenseSystem) SetLogSuppressionConfig(window, threshold int) {
 	d.logSuppressor = NewLogSuppressor(window, threshold)
 	if d.logger != nil {
-		d.logger.Printf("已更新日志抑制配置: 窗口=%d秒, 阈值=%d次", window, threshold)
+		d.logger.Info("已更新日志抑制配置", "window_seconds", window, "threshold", threshold)
 	}
 }
 
@@ -256,7 +264,7 @@ func (d *DefaultDefenseSystem) Shutdown() error {
 		d.cancel()
 	}
 	if d.logger != nil {
-		d.logger.Println("防御系统已安全关闭")
+		d.logger.Info("防御系统已安全关闭")
 	}
 	return nil
 }
@@ -265,7 +273,7 @@ func (d *DefaultDefenseSystem) Shutdown() error {
 func (d *DefaultDefenseSystem) EnableRateLimit(enable bool) {
 	d.enableRateLimit = enable
 	if d.logger != nil {
-		d.logger.Printf("速率限制功能已%s", ifThenElse(enable, "启用", "禁用"))
+		d.logger.Info("速率限制功能已" + ifThenElse(enable, "启用", "禁用"))
 	}
 }
 
@@ -281,8 +289,8 @@ func (d *DefaultDefenseSystem) SetRateLimitConfig(window, maxRequests, blacklist
 	go d.rateLimiter.StartCleanupTask(d.ctx)
 
 	if d.logger != nil {
-		d.logger.Printf("已更新速率限制配置: 窗口=%d秒, 最大请求=%d, 黑名单超时=%d分钟",
-			window, maxRequests, blacklistTimeout)
+		d.logger.Info("已更新速率限制配置",
+			"window_seconds", window, "max_requests", maxRequests, "blacklist_minutes", blacklistTimeout)
 	}
 }
 
@@ -290,7 +298,7 @@ func (d *DefaultDefenseSystem) SetRateLimitConfig(window, maxRequests, blacklist
 func (d *DefaultDefenseSystem) EnableDomainBlock(enable bool) {
 	d.enableDomainBlock = enable
 	if d.logger != nil {
-		d.logger.Printf("域名黑名单功能已%s", ifThenElse(enable, "启用", "禁用"))
+		d.logger.Info("域名黑名单功能已" + ifThenElse(enable, "启用", "禁用"))
 	}
 }
 
@@ -298,7 +306,7 @@ func (d *DefaultDefenseSystem) EnableDomainBlock(enable bool) {
 func (d *DefaultDefenseSystem) BlockDomain(domain string) {
 	d.domainBlocker.BlockDomain(domain)
 	if d.logger != nil {
-		d.logger.Printf("已将域名 %s 添加到黑名单", domain)
+		d.logger.Info("已将域名添加到黑名单", "domain", domain)
 	}
 }
 
@@ -306,7 +314,7 @@ func (d *DefaultDefenseSystem) BlockDomain(domain string) {
 func (d *DefaultDefenseSystem) UnblockDomain(domain string) {
 	d.domainBlocker.UnblockDomain(domain)
 	if d.logger != nil {
-		d.logger.Printf("已将域名 %s 从黑名单中移除", domain)
+		d.logger.Info("已将域名从黑名单中移除", "domain", domain)
 	}
 }
 
@@ -322,20 +330,17 @@ func (d *DefaultDefenseSystem) PrintStatus() {
 	}
 
 	if d.enableRateLimit {
-		d.logger.Printf("已启用速率限制: 每%d秒最多%d个请求, 超限封禁%d分钟",
-			d.rateLimiter.window, d.rateLimiter.maxRequests, d.rateLimiter.blacklistTimeout)
+		d.logger.Info("已启用速率限制",
+			"window_seconds", d.rateLimiter.window, "max_requests", d.rateLimiter.maxRequests, "blacklist_minutes", d.rateLimiter.blacklistTimeout)
 	} else {
-		d.logger.Println("速率限制: 已禁用")
+		d.logger.Info("速率限制已禁用")
 	}
 
 	if d.enableDomainBlock {
 		blockedDomains := d.domainBlocker.ListBlockedDomains()
-		d.logger.Printf("已启用域名黑名单，当前包含 %d 个域名", len(blockedDomains))
-		if len(blockedDomains) > 0 {
-			d.logger.Printf("黑名单域名: %s", strings.Join(blockedDomains, ", "))
-		}
+		d.logger.Info("已启用域名黑名单", "count", len(blockedDomains), "domains", strings.Join(blockedDomains, ", "))
 	} else {
-		d.logger.Println("域名黑名单: 已禁用")
+		d.logger.Info("域名黑名单已禁用")
 	}
 }
 
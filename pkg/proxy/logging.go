@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// redactHeaders 日志中脱敏的请求头
+var redactHeaders = []string{"Authorization", "Proxy-Authorization", "X-Goog-Api-Key", "Cookie"}
+
+// redactQueryParams 日志中脱敏的URL查询参数
+var redactQueryParams = []string{"key", "api_key"}
+
+// redactHeader 复制请求头并对密钥做脱敏处理
+func redactHeader(header http.Header) http.Header {
+	clone := header.Clone()
+	for _, name := range redactHeaders {
+		if value := clone.Get(name); value != "" {
+			clone.Set(name, maskKey(value))
+		}
+	}
+	return clone
+}
+
+// redactQuery 复制查询参数并对密钥做脱敏处理
+func redactQuery(query url.Values) url.Values {
+	clone := url.Values{}
+	for name, values := range query {
+		clone[name] = append([]string(nil), values...)
+	}
+	for _, name := range redactQueryParams {
+		if value := clone.Get(name); value != "" {
+			clone.Set(name, maskKey(value))
+		}
+	}
+	return clone
+}
+
+// redactURL 返回密钥参数脱敏后的URL
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	clone := *u
+	if clone.RawQuery != "" {
+		clone.RawQuery = redactQuery(u.Query()).Encode()
+	}
+	return clone.String()
+}
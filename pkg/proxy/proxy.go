@@ -12,7 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math/big"
 	"net"
 	"net/http"
@@ -24,6 +24,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/562589540/agent-go/pkg/logging"
 )
 
 // 全局 CA 操作锁
@@ -47,7 +49,7 @@ type ProxyServer struct {
 	listenAddr       string
 	apiKeys          []string
 	upstreamProxyURL string
-	logger           *log.Logger
+	logger           *slog.Logger
 	server           *http.Server
 	httpClient       *http.Client
 	authenticator    Authenticator
@@ -60,19 +62,28 @@ type ProxyServer struct {
 	defense DefenseSystem
 }
 
-// NewProxy 创建一个新的 HTTP 代理服务器实例
+// NewProxy 创建一个新的 HTTP 代理服务器实例，日志输出到slog.Default()
 func NewProxy(ctx context.Context, listenAddr string, apiKeys []string, upstreamProxyURL string, authenticator Authenticator) (*ProxyServer, error) {
-	logger := log.New(log.Writer(), "[Proxy] ", log.LstdFlags) // 使用 [Proxy] 作为日志前缀
+	return NewProxyWithLogger(ctx, listenAddr, apiKeys, upstreamProxyURL, authenticator, nil)
+}
+
+// NewProxyWithLogger 创建使用指定日志记录器的代理服务器，logger为空时使用slog.Default()
+// 启用LevelTrace时记录脱敏后的请求Dump
+func NewProxyWithLogger(ctx context.Context, listenAddr string, apiKeys []string, upstreamProxyURL string, authenticator Authenticator, logger *slog.Logger) (*ProxyServer, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("component", "proxy")
 
 	// 检查 API 密钥池是否为空
 	if len(apiKeys) == 0 {
 		return nil, errors.New("API 密钥池不能为空")
 	}
-	logger.Printf("已加载 %d 个 API 密钥到池中", len(apiKeys))
+	logger.Info("已加载API密钥池", "keys", len(apiKeys))
 
 	// 确保 authenticator 不为 nil，如果为 nil，则使用 NilAuthenticator
 	if authenticator == nil {
-		logger.Println("警告: 未提供认证器，将允许所有请求。")
+		logger.Warn("未提供认证器，将允许所有请求")
 		authenticator = &NilAuthenticator{}
 	}
 
@@ -97,10 +108,10 @@ func NewProxy(ctx context.Context, listenAddr string, apiKeys []string, upstream
 	if upstreamProxyURL != "" {
 		proxyURL, err := url.Parse(upstreamProxyURL)
 		if err != nil {
-			logger.Printf("警告: 解析上游代理URL失败 '%s': %v, 将直接连接", upstreamProxyURL, err)
+			logger.Warn("解析上游代理URL失败，将直接连接", "upstream", upstreamProxyURL, "error", err)
 		} else {
 			transport.Proxy = http.ProxyURL(proxyURL)
-			logger.Printf("已配置 HTTP Client 使用上游代理: %s", upstreamProxyURL)
+			logger.Info("HTTP Client已配置上游代理", "upstream", upstreamProxyURL)
 		}
 	}
 
@@ -110,7 +121,7 @@ func NewProxy(ctx context.Context, listenAddr string, apiKeys []string, upstream
 	}
 
 	// 创建防御系统
-	defense := NewDefenseSystem(ctx, logger)
+	defense := NewDefenseSystemWithLogger(ctx, logger)
 
 	return &ProxyServer{
 		listenAddr:       listenAddr,
@@ -131,16 +142,13 @@ func (p *ProxyServer) getNextAPIKey() string {
 
 	key := p.apiKeys[p.keyIndex]
 	p.keyIndex = (p.keyIndex + 1) % len(p.apiKeys) // 循环索引
-	p.logger.Printf("使用 API 密钥池中的密钥 (索引 %d): %s", (p.keyIndex-1+len(p.apiKeys))%len(p.apiKeys), maskKey(key))
+	p.logger.Debug("使用API密钥池中的密钥", "index", (p.keyIndex-1+len(p.apiKeys))%len(p.apiKeys), "key", maskKey(key))
 	return key
 }
 
 // Start 启动代理服务器
 func (p *ProxyServer) Start() error {
-	p.logger.Printf("启动 HTTP 代理服务器在 %s", p.listenAddr) // 更新日志消息
-	if p.upstreamProxyURL != "" {
-		p.logger.Printf("使用上游代理: %s", p.upstreamProxyURL)
-	}
+	p.logger.Info("启动HTTP代理服务器", "addr", p.listenAddr, "upstream", p.upstreamProxyURL)
 
 	// 输出防御系统状态
 	if defenseSystem, ok := p.defense.(*DefaultDefenseSystem); ok {
@@ -160,7 +168,7 @@ func (p *ProxyServer) Start() error {
 
 // Stop 停止代理服务器
 func (p *ProxyServer) Stop() error {
-	p.logger.Println("停止代理服务器...")
+	p.logger.Info("停止代理服务器")
 
 	// 关闭防御系统
 	if p.defense != nil {
@@ -217,7 +225,7 @@ func (p *ProxyServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	p.logger.Printf("收到请求: %s %s from %s", r.Method, r.URL, clientIP)
+	p.logger.Info("收到请求", "method", r.Method, "url", redactURL(r.URL), "client", clientIP)
 
 	//不在这里认证
 	if r.Method == http.MethodConnect {
@@ -259,8 +267,8 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		// 如果分割失败，假定没有端口
 		hostname = targetHost
 	}
-	p.logger.Printf("处理CONNECT请求: %s (主机名: %s)", targetHost, hostname)
-	p.logger.Printf("请求头: %v", r.Header)
+	p.logger.Debug("处理CONNECT请求", "target", targetHost, "host", hostname)
+	p.logger.Log(r.Context(), logging.LevelTrace, "CONNECT请求头", "header", redactHeader(r.Header))
 
 	// 使用防御系统再次检查CONNECT请求主机名
 	// 这里使用getClientIPFromRequest获取IP，确保和handleRequest中的检查方式一致
@@ -272,18 +280,18 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 
 	// === Google API 的 MITM (中间人攻击) 逻辑 ===
 	if hostname == "generativelanguage.googleapis.com" {
-		p.logger.Printf("检测到 Google API 请求，尝试 MITM 拦截...")
+		p.logger.Debug("检测到Google API请求，尝试MITM拦截", "target", targetHost)
 
 		// 1. 在发送 200 OK 之前劫持客户端连接
 		hijacker, ok := w.(http.Hijacker)
 		if !ok {
-			p.logger.Printf("错误: 不支持连接劫持(Hijacking)")
+			p.logger.Error("不支持连接劫持(Hijacking)")
 			http.Error(w, "不支持连接劫持(Hijacking)", http.StatusInternalServerError)
 			return
 		}
 		clientConn, _, err := hijacker.Hijack()
 		if err != nil {
-			p.logger.Printf("劫持连接失败: %v", err)
+			p.logger.Error("劫持连接失败", "error", err)
 			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
@@ -292,7 +300,7 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		// 2. 为目标主机生成证书
 		hostCert, err := signHost(hostname)
 		if err != nil {
-			p.logger.Printf("为 '%s' 生成证书失败: %v", hostname, err)
+			p.logger.Error("生成主机证书失败", "host", hostname, "error", err)
 			http.Error(w, "生成服务器证书失败", http.StatusInternalServerError)
 			clientConn.Close()
 			return
@@ -302,11 +310,11 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		// 必须在开始 TLS 握手之前写入 200 OK 响应
 		_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
 		if err != nil {
-			p.logger.Printf("向客户端发送 200 OK 失败: %v", err)
+			p.logger.Warn("向客户端发送200 OK失败", "error", err)
 			clientConn.Close()
 			return
 		}
-		p.logger.Printf("已向客户端发送 200 OK (用于 MITM)")
+		p.logger.Debug("已向客户端发送200 OK(MITM)")
 
 		// 4. 与客户端执行 TLS 握手 (假装是 Google)
 		tlsConfigServer := &tls.Config{
@@ -315,25 +323,25 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		}
 		clientTlsConn := tls.Server(clientConn, tlsConfigServer)
 		if err := clientTlsConn.Handshake(); err != nil {
-			p.logger.Printf("与客户端 TLS 握手失败: %v", err)
+			p.logger.Warn("与客户端TLS握手失败", "error", err)
 			clientTlsConn.Close() // 这也会关闭底层的 clientConn
 			return
 		}
 		defer clientTlsConn.Close()
-		p.logger.Printf("与客户端 TLS 握手成功")
+		p.logger.Debug("与客户端TLS握手成功")
 
 		// 5. 连接到实际的目标服务器 (如果配置了上游代理，则通过上游代理连接)
 		var destConn net.Conn
 		if p.upstreamProxyURL != "" {
-			p.logger.Printf("通过上游代理 %s 连接到目标: %s", p.upstreamProxyURL, targetHost)
+			p.logger.Debug("通过上游代理连接目标", "upstream", p.upstreamProxyURL, "target", targetHost)
 			dialer := &net.Dialer{Timeout: 15 * time.Second, KeepAlive: 30 * time.Second}
 			destConn, err = dialViaProxy(r.Context(), dialer, p.upstreamProxyURL, targetHost) // 使用 dialViaProxy 替代 dialViaProxySimple
 		} else {
-			p.logger.Printf("直接连接到目标: %s", targetHost)
+			p.logger.Debug("直接连接目标", "target", targetHost)
 			destConn, err = net.DialTimeout("tcp", targetHost, 10*time.Second)
 		}
 		if err != nil {
-			p.logger.Printf("连接真实目标服务器失败: %v", err)
+			p.logger.Error("连接目标服务器失败", "target", targetHost, "error", err)
 			// 通知客户端？也许直接关闭。
 			return // clientTlsConn 的 defer 会关闭客户端侧
 		}
@@ -348,33 +356,31 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		}
 		serverTlsConn := tls.Client(destConn, tlsConfigClient)
 		if err := serverTlsConn.Handshake(); err != nil {
-			p.logger.Printf("与目标服务器 TLS 握手失败: %v", err)
+			p.logger.Error("与目标服务器TLS握手失败", "target", targetHost, "error", err)
 			serverTlsConn.Close() // 关闭底层的 destConn
 			return                // clientTlsConn 的 defer 会关闭客户端侧
 		}
 		defer serverTlsConn.Close()
-		p.logger.Printf("与目标服务器 TLS 握手成功")
+		p.logger.Debug("与目标服务器TLS握手成功", "target", targetHost)
 
 		// 7. 开始 MITM 代理 (读取客户端请求，修改，发送到服务器等)
-		p.logger.Printf("开始 MITM 代理循环: %s <-> %s", clientTlsConn.RemoteAddr(), targetHost)
 		p.mitmProxyLoop(clientTlsConn, serverTlsConn, targetHost) // 使用新函数处理
-		p.logger.Printf("MITM 代理循环结束 for %s", targetHost)
-		return // MITM 处理结束
+		return                                                    // MITM 处理结束
 	}
 
 	// === 标准 CONNECT 隧道 (非 Google API) ===
-	p.logger.Printf("非 Google API 请求，执行标准 CONNECT 隧道")
+	p.logger.Debug("非Google API请求，执行标准CONNECT隧道", "target", targetHost)
 	var destConn net.Conn // 为此作用域重新声明
 	if p.upstreamProxyURL != "" {
-		p.logger.Printf("通过上游代理 %s 连接到目标: %s", p.upstreamProxyURL, targetHost)
+		p.logger.Debug("通过上游代理连接目标", "upstream", p.upstreamProxyURL, "target", targetHost)
 		dialer := &net.Dialer{Timeout: 15 * time.Second, KeepAlive: 30 * time.Second}
 		destConn, err = dialViaProxy(r.Context(), dialer, p.upstreamProxyURL, targetHost) // 使用 dialViaProxy 替代 dialViaProxySimple
 	} else {
-		p.logger.Printf("直接连接到目标: %s", targetHost)
+		p.logger.Debug("直接连接目标", "target", targetHost)
 		destConn, err = net.DialTimeout("tcp", targetHost, 10*time.Second)
 	}
 	if err != nil {
-		p.logger.Printf("连接失败: %v", err)
+		p.logger.Error("连接目标失败", "target", targetHost, "error", err)
 		if strings.Contains(err.Error(), "refused") {
 			http.Error(w, "目标连接被拒绝", http.StatusBadGateway)
 		} else if strings.Contains(err.Error(), "timeout") {
@@ -385,39 +391,37 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer destConn.Close()
-	p.logger.Printf("成功连接到: %s (通过%s)", r.Host, ifelse(p.upstreamProxyURL != "", "上游代理", "直接连接"))
+	p.logger.Debug("成功连接目标", "target", r.Host, "via", ifelse(p.upstreamProxyURL != "", "上游代理", "直接连接"))
 
 	// 响应客户端，表示连接已建立
 	w.WriteHeader(http.StatusOK)
-	p.logger.Printf("返回 200 OK 给客户端 (标准隧道)")
 
 	// 劫持连接
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
-		p.logger.Printf("错误: 不支持连接劫持(Hijacking)")
+		p.logger.Error("不支持连接劫持(Hijacking)")
 		http.Error(w, "不支持连接劫持(Hijacking)", http.StatusInternalServerError)
 		return
 	}
 
 	clientConn, _, err := hijacker.Hijack()
 	if err != nil {
-		p.logger.Printf("劫持连接失败: %v", err)
+		p.logger.Error("劫持连接失败", "error", err)
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 	defer clientConn.Close()
-	p.logger.Printf("成功劫持连接 (标准隧道)")
 
 	// 双向复制数据
-	p.logger.Printf("开始双向转发数据 (标准隧道): %s <-> %s", r.RemoteAddr, r.Host)
+	p.logger.Debug("开始双向转发数据(标准隧道)", "client", r.RemoteAddr, "target", r.Host)
 
 	// 客户端 -> 目标
 	go func() {
 		n, err := io.Copy(destConn, clientConn)
 		if err != nil && !strings.Contains(err.Error(), "use of closed network connection") && err != io.EOF {
-			p.logger.Printf("客户端->目标 复制错误 (标准隧道): %v", err)
+			p.logger.Warn("客户端->目标复制错误(标准隧道)", "error", err)
 		}
-		p.logger.Printf("客户端->目标 复制完成 (标准隧道), 传输 %d 字节", n)
+		p.logger.Debug("客户端->目标复制完成(标准隧道)", "bytes", n)
 		// 关闭一个连接以通知另一个 goroutine 结束
 		clientConn.Close()
 		destConn.Close()
@@ -426,13 +430,13 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 	// 目标 -> 客户端
 	n, err := io.Copy(clientConn, destConn)
 	if err != nil && !strings.Contains(err.Error(), "use of closed network connection") && err != io.EOF {
-		p.logger.Printf("目标->客户端 复制错误 (标准隧道): %v", err)
+		p.logger.Warn("目标->客户端复制错误(标准隧道)", "error", err)
 	}
-	p.logger.Printf("目标->客户端 复制完成 (标准隧道), 传输 %d 字节", n)
+	p.logger.Debug("目标->客户端复制完成(标准隧道)", "bytes", n)
 
 	clientConn.Close()
 	destConn.Close()
-	p.logger.Printf("CONNECT会话结束 (标准隧道): %s", r.Host)
+	p.logger.Debug("CONNECT会话结束(标准隧道)", "target", r.Host)
 }
 
 // singleConnListener 是一个只接受单个连接的 net.Listener
@@ -482,7 +486,8 @@ func (l *singleConnListener) Addr() net.Addr {
 
 // mitmProxyLoop 使用 http.Serve 处理 MITM 连接的数据传输
 func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Conn, targetHost string) {
-	p.logger.Printf("开始 MITM 代理循环 (http.Serve): %s <-> %s", clientTlsConn.RemoteAddr(), targetHost)
+	logger := p.logger.With("client", clientTlsConn.RemoteAddr().String(), "target", targetHost)
+	logger.Debug("开始MITM代理循环")
 
 	// 创建一个 ReverseProxy 实例
 	dummyTargetUrl := &url.URL{Scheme: "https", Host: targetHost}
@@ -491,7 +496,7 @@ func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Co
 	// 自定义 transport，用于向现有的服务器 TLS 连接写入/读取数据
 	reverseProxy.Transport = &mitmTransport{
 		Conn:   serverTlsConn,
-		Logger: p.logger,
+		Logger: logger,
 	}
 
 	// 自定义 Director 用于在发送请求前修改请求
@@ -499,7 +504,7 @@ func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Co
 	reverseProxy.Director = func(req *http.Request) {
 		// 设置基本字段
 		originalDirector(req)
-		p.logger.Printf("MITM Director: 正在处理请求 %s", req.URL.String())
+		logger.Debug("MITM正在处理请求", "method", req.Method, "url", redactURL(req.URL))
 
 		// 在替换 API Key 之前进行认证
 		isValid, err := p.authenticator.Authenticate(req)
@@ -508,7 +513,7 @@ func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Co
 		authMessage := ""
 
 		if err != nil {
-			p.logger.Printf("认证过程发生错误: %v", err)
+			logger.Error("认证过程发生错误", "error", err)
 			var authErr *AuthServerError
 			if errors.As(err, &authErr) {
 				// 是主认证服务器返回的错误
@@ -526,7 +531,7 @@ func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Co
 		}
 
 		if !isValid {
-			p.logger.Printf("认证失败 (密钥无效或缺失): %s %s", req.Method, req.URL)
+			logger.Warn("认证失败(密钥无效或缺失)", "method", req.Method, "url", redactURL(req.URL))
 			authStatusCode = http.StatusUnauthorized
 			authMessage = "认证失败"
 			// 设置 Header 标记认证失败
@@ -536,24 +541,24 @@ func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Co
 		}
 
 		// 认证成功，继续执行
-		p.logger.Printf("MITM Director: 认证成功")
+		logger.Debug("MITM认证成功")
 
 		// --- 修改 API Key ---
 		originalAPIKey := req.Header.Get("x-goog-api-key")
 		nextKey := p.getNextAPIKey() // 获取下一个轮换密钥
 		if originalAPIKey != "" {
 			req.Header.Set("x-goog-api-key", nextKey)
-			p.logger.Printf("MITM Director: 已替换 API Key Header: %s -> %s", maskKey(originalAPIKey), maskKey(nextKey))
+			logger.Debug("已替换API Key请求头", "from", maskKey(originalAPIKey), "to", maskKey(nextKey))
 		} else {
 			q := req.URL.Query()
 			urlKey := q.Get("key")
 			if urlKey != "" {
 				q.Set("key", nextKey)
 				req.URL.RawQuery = q.Encode()
-				p.logger.Printf("MITM Director: 已替换 URL API Key: %s -> %s", maskKey(urlKey), maskKey(nextKey))
+				logger.Debug("已替换URL中的API Key", "from", maskKey(urlKey), "to", maskKey(nextKey))
 			} else {
 				req.Header.Set("x-goog-api-key", nextKey)
-				p.logger.Printf("MITM Director: 添加了 API Key Header: %s", maskKey(nextKey))
+				logger.Debug("已添加API Key请求头", "key", maskKey(nextKey))
 			}
 		}
 		// --- 结束 API Key 修改 ---
@@ -561,29 +566,28 @@ func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Co
 		req.Header.Del("Proxy-Connection")
 		req.Header.Del("Proxy-Authorization")
 		req.Host = targetHost
-		p.logger.Printf("MITM Director: 最终路径: %s, 主机: %s", req.URL.Path, req.Host)
+		logger.Debug("MITM请求已改写", "path", req.URL.Path, "host", req.Host)
 	}
 
 	// 自定义 ModifyResponse (可选, 用于日志记录)
 	reverseProxy.ModifyResponse = func(resp *http.Response) error {
-		p.logger.Printf("MITM ModifyResponse: 从目标收到状态 %d", resp.StatusCode)
+		logger.Info("MITM收到目标响应", "status", resp.StatusCode)
 		return nil
 	}
 
 	// 自定义 ErrorHandler
 	reverseProxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-		p.logger.Printf("MITM ReverseProxy ErrorHandler: 错误: %v", err)
+		logger.Error("MITM代理错误", "error", err)
 		// 检查 header 是否已发送或连接是否已劫持
 		if !responseWriterWrittenOrHijacked(rw) {
 			http.Error(rw, fmt.Sprintf("MITM 代理错误: %v", err), http.StatusBadGateway)
 		} else {
-			p.logger.Printf("MITM ErrorHandler: Header 已发送或连接已劫持，无法向客户端写入错误。")
+			logger.Warn("响应头已发送或连接已劫持，无法向客户端写入错误")
 		}
 	}
 
 	// 禁用流式响应（如 SSE）的缓冲
 	reverseProxy.FlushInterval = -1
-	p.logger.Printf("MITM: 为 ReverseProxy 设置 FlushInterval = -1")
 
 	// 创建一个使用 reverse proxy 作为 handler 的 HTTP 服务器
 	server := &http.Server{
@@ -596,17 +600,14 @@ func (p *ProxyServer) mitmProxyLoop(clientTlsConn net.Conn, serverTlsConn net.Co
 
 	// 在单连接 listener 上处理 HTTP 请求
 	// 此调用将阻塞，直到 listener 返回错误 (例如，在单个连接关闭后)
-	p.logger.Printf("MITM: 在单连接 listener 上为 %s 调用 http.Serve", clientTlsConn.RemoteAddr())
 	err := server.Serve(listener)
 
 	if err != nil && err != io.EOF && !strings.Contains(err.Error(), "closed") && err != http.ErrServerClosed {
 		// 记录意外错误，忽略 EOF/closed (这些在单连接结束后是预期的)
-		p.logger.Printf("MITM: 单连接 listener 上的 http.Serve 错误: %v", err)
-	} else {
-		p.logger.Printf("MITM: 为 %s 干净地完成 http.Serve", clientTlsConn.RemoteAddr())
+		logger.Warn("MITM单连接http.Serve错误", "error", err)
 	}
 
-	p.logger.Printf("MITM: 退出 mitmProxyLoop (http.Serve) for %s", clientTlsConn.RemoteAddr())
+	logger.Debug("MITM代理循环结束")
 	// 连接 (clientTlsConn, serverTlsConn) 应由 handleConnect 中的 defer 关闭
 }
 
@@ -634,7 +635,7 @@ func responseWriterWrittenOrHijacked(rw http.ResponseWriter) bool {
 // mitmTransport 是一个自定义的 http.RoundTripper，它向现有的连接写入数据
 type mitmTransport struct {
 	Conn   net.Conn
-	Logger *log.Logger
+	Logger *slog.Logger
 }
 
 func (t *mitmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -651,7 +652,7 @@ func (t *mitmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		statusCode, err := strconv.Atoi(authCodeStr)
 		if err != nil {
 			// 如果状态码解析失败，则默认为 500
-			t.Logger.Printf("mitmTransport: 无法解析认证结果状态码 '%s', 使用 500", authCodeStr)
+			t.Logger.Warn("无法解析认证结果状态码，使用500", "code", authCodeStr)
 			statusCode = http.StatusInternalServerError
 		}
 		if authMsg == "" {
@@ -661,7 +662,7 @@ func (t *mitmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 			}
 		}
 
-		t.Logger.Printf("mitmTransport: 检测到认证结果: 状态码 %d, 消息: %s", statusCode, authMsg)
+		t.Logger.Debug("返回认证结果", "status", statusCode, "message", authMsg)
 		// 返回包含原始状态码和消息的响应
 		return &http.Response{
 			StatusCode: statusCode,
@@ -677,23 +678,28 @@ func (t *mitmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	// 认证已在 Director 处理且成功，这里正常继续
 	// 记录发送到实际服务器的请求
-	t.Logger.Printf("mitmTransport: 正在向目标发送请求: %s %s", req.Method, req.URL)
-
-	// === 启用请求 Dump ===
-	reqDump, errDump := httputil.DumpRequestOut(req, true)
-	if errDump != nil {
-		t.Logger.Printf("mitmTransport: Dump 输出请求错误: %v", errDump)
-	} else {
-		t.Logger.Printf("mitmTransport: 输出请求 Dump:\n%s", string(reqDump))
+	t.Logger.Debug("正在向目标发送请求", "method", req.Method, "url", redactURL(req.URL))
+
+	// 启用LevelTrace时输出脱敏后的请求Dump
+	if ctx := req.Context(); t.Logger.Enabled(ctx, logging.LevelTrace) {
+		dumpReq := req.Clone(ctx)
+		dumpReq.Header = redactHeader(req.Header)
+		dumpReq.URL.RawQuery = redactQuery(req.URL.Query()).Encode()
+		reqDump, errDump := httputil.DumpRequestOut(dumpReq, true)
+		// DumpRequestOut读取后会重新设置请求体
+		req.Body = dumpReq.Body
+		if errDump != nil {
+			t.Logger.Warn("输出请求Dump错误", "error", errDump)
+		} else {
+			t.Logger.Log(ctx, logging.LevelTrace, "请求Dump", "dump", string(reqDump))
+		}
 	}
-	// === 结束请求 Dump ===
 
 	// 将请求写入现有的服务器连接
 	if err := req.Write(t.Conn); err != nil {
 		t.Conn.Close()
 		return nil, fmt.Errorf("mitmTransport: 写入请求失败: %w", err)
 	}
-	t.Logger.Printf("mitmTransport: 请求已写入目标连接。")
 
 	// 从同一连接读取响应
 	resp, err := http.ReadResponse(bufio.NewReader(t.Conn), req)
@@ -701,14 +707,13 @@ func (t *mitmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		t.Conn.Close()
 		return nil, fmt.Errorf("mitmTransport: 读取响应失败: %w", err)
 	}
-	t.Logger.Printf("mitmTransport: 从目标连接读取响应, 状态: %d", resp.StatusCode)
+	t.Logger.Debug("从目标连接读取响应", "status", resp.StatusCode)
 	return resp, nil
 }
 
 // handleHTTP 处理普通的 HTTP 请求 (非 CONNECT)
 func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
-	p.logger.Printf("处理HTTP请求: %s %s", r.Method, r.URL)
-	p.logger.Printf("目标URL: %s", r.URL.String())
+	p.logger.Debug("处理HTTP请求", "method", r.Method, "url", redactURL(r.URL))
 
 	outReq := r.Clone(r.Context())
 	outReq.RequestURI = ""
@@ -720,19 +725,19 @@ func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if outReq.URL.Host == "generativelanguage.googleapis.com" {
 		nextKey := p.getNextAPIKey() // 获取下一个轮换密钥
-		p.logger.Printf("检测到Gemini API请求，使用密钥池中的密钥替换API密钥: %s", maskKey(nextKey))
+		p.logger.Debug("检测到Gemini API请求，使用密钥池中的密钥替换API密钥", "key", maskKey(nextKey))
 		outReq.Header.Set("x-goog-api-key", nextKey)
 	}
 
 	resp, err := p.httpClient.Do(outReq)
 	if err != nil {
-		p.logger.Printf("请求失败: %v", err)
+		p.logger.Error("转发请求失败", "url", redactURL(r.URL), "error", err)
 		http.Error(w, "转发请求失败: "+err.Error(), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
 
-	p.logger.Printf("收到目标响应: %d", resp.StatusCode)
+	p.logger.Debug("收到目标响应", "status", resp.StatusCode)
 	// 复制响应头
 	for name, values := range resp.Header {
 		// 跳过分块传输编码和内容长度，因为 Go http server 会自动处理
@@ -756,10 +761,9 @@ func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	// 复制响应体
 	n, err := io.Copy(w, resp.Body)
 	if err != nil {
-		p.logger.Printf("复制响应体错误: %v", err)
+		p.logger.Warn("复制响应体错误", "error", err)
 	}
-	p.logger.Printf("复制响应体完成，传输 %d 字节", n)
-	p.logger.Printf("HTTP请求处理完成: %s %s -> %d", r.Method, r.URL.String(), resp.StatusCode)
+	p.logger.Info("HTTP请求处理完成", "method", r.Method, "url", redactURL(r.URL), "status", resp.StatusCode, "bytes", n)
 }
 
 // dialViaProxy 通过上游代理建立到目标地址的 TCP 连接
@@ -823,13 +827,13 @@ func maskKey(key string) string {
 func StartProxy(ctx context.Context, addr string, apiKeys []string, upstreamProxyURL string, authenticator Authenticator) error {
 	proxy, err := NewProxy(ctx, addr, apiKeys, upstreamProxyURL, authenticator)
 	if err != nil {
-		log.Fatalf("创建代理失败: %v", err)
+		return fmt.Errorf("创建代理失败: %w", err)
 	}
 	return proxy.Start()
 }
 
 // loadOrGenerateCA 从磁盘加载 CA 证书和密钥，如果找不到则生成新的。
-func loadOrGenerateCA(logger *log.Logger) (*tls.Certificate, error) {
+func loadOrGenerateCA(logger *slog.Logger) (*tls.Certificate, error) {
 	caLock.Lock()
 	defer caLock.Unlock()
 
@@ -844,7 +848,7 @@ func loadOrGenerateCA(logger *log.Logger) (*tls.Certificate, error) {
 	// 检查文件是否存在
 	if _, err := os.Stat(certPath); err == nil {
 		if _, err := os.Stat(keyPath); err == nil {
-			logger.Printf("加载已存在的 CA 证书从: %s 和 %s", certPath, keyPath)
+			logger.Info("加载已存在的CA证书", "cert", certPath, "key", keyPath)
 			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
 			if err != nil {
 				return nil, fmt.Errorf("加载 CA key pair 失败: %w", err)
@@ -863,7 +867,7 @@ func loadOrGenerateCA(logger *log.Logger) (*tls.Certificate, error) {
 		}
 	}
 
-	logger.Printf("CA 证书未找到, 正在生成新的 CA...")
+	logger.Info("CA证书未找到，正在生成新的CA")
 
 	// 如果目录不存在则创建
 	if err := os.MkdirAll(caDir, 0700); err != nil {
@@ -911,7 +915,7 @@ func loadOrGenerateCA(logger *log.Logger) (*tls.Certificate, error) {
 	}
 	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	certOut.Close()
-	logger.Printf("CA 证书已保存到: %s", certPath)
+	logger.Info("CA证书已保存", "path", certPath)
 
 	// 将私钥写入 PEM 文件
 	keyOut, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
@@ -920,8 +924,8 @@ func loadOrGenerateCA(logger *log.Logger) (*tls.Certificate, error) {
 	}
 	pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
 	keyOut.Close()
-	logger.Printf("CA 私钥已保存到: %s", keyPath)
-	logger.Printf("!!! 重要: 请将 CA 证书 '%s' 添加到您系统的信任存储中以允许 MITM !!!", certPath)
+	logger.Info("CA私钥已保存", "path", keyPath)
+	logger.Warn("请将CA证书添加到系统的信任存储中以允许MITM", "cert", certPath)
 
 	// 加载生成的密钥对
 	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
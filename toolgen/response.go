@@ -0,0 +1,52 @@
+package toolgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/562589540/agent-go/agent"
+)
+
+// ResponseSchemaFor 从结构体生成结构化输出配置，字段规则与工具参数相同
+// 没有omitempty的字段为必填，description和enum标签写入Schema
+func ResponseSchemaFor[T any](name string, description string) *agent.ResponseSchema {
+	var value T
+	return &agent.ResponseSchema{
+		Name:        name,
+		Description: description,
+		Schema:      structToJSONSchema(reflect.TypeOf(value)),
+	}
+}
+
+// ParseResponse 把对话的最终回答解析为结构体，history为对话返回的历史，取最后一条有内容的助手消息
+func ParseResponse[T any](history []agent.ChatMessage) (T, error) {
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		if msg.Role == "assistant" && strings.TrimSpace(msg.Content) != "" {
+			return UnmarshalResponse[T](msg.Content)
+		}
+	}
+
+	var result T
+	return result, fmt.Errorf("对话历史中没有助手回答")
+}
+
+// UnmarshalResponse 把结构化输出的JSON文本解析为结构体，兼容用```json代码块包裹的回答
+func UnmarshalResponse[T any](text string) (T, error) {
+	var result T
+
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(text, "```")
+		text = strings.TrimSpace(text)
+	}
+
+	if err := json.Unmarshal([]byte(text), &result); err != nil {
+		return result, fmt.Errorf("解析结构化输出错误: %w", err)
+	}
+	return result, nil
+}
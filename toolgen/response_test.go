@@ -0,0 +1,61 @@
+package toolgen
+
+import (
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+)
+
+type testWeatherReport struct {
+	City    string   `json:"city" description:"城市"`
+	Level   string   `json:"level" enum:"low,high"`
+	Hours   []int    `json:"hours,omitempty"`
+	Details struct { // 嵌套结构
+		Wind string `json:"wind"`
+	} `json:"details"`
+}
+
+// 测试从结构体生成的结构化输出Schema
+func TestResponseSchemaFor(t *testing.T) {
+	rs := ResponseSchemaFor[testWeatherReport]("weather", "天气报告")
+	if rs.Name != "weather" || rs.Description != "天气报告" {
+		t.Fatalf("名称或描述错误: %+v", rs)
+	}
+
+	properties := rs.Schema["properties"].(map[string]interface{})
+	for _, name := range []string{"city", "level", "hours", "details"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("缺少字段 %s", name)
+		}
+	}
+	required := rs.Schema["required"].([]interface{})
+	if len(required) != 3 {
+		t.Errorf("必填字段错误: %v", required)
+	}
+	if enum := properties["level"].(map[string]interface{})["enum"]; len(enum.([]interface{})) != 2 {
+		t.Errorf("枚举值错误: %v", enum)
+	}
+}
+
+// 测试从对话历史中解析最终回答
+func TestParseResponse(t *testing.T) {
+	history := []agent.ChatMessage{
+		{Role: "user", Content: "北京天气"},
+		{Role: "assistant", Content: "```json\n{\"city\":\"北京\",\"level\":\"high\",\"details\":{\"wind\":\"北风\"}}\n```"},
+	}
+
+	report, err := ParseResponse[testWeatherReport](history)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if report.City != "北京" || report.Level != "high" || report.Details.Wind != "北风" {
+		t.Errorf("解析结果错误: %+v", report)
+	}
+
+	if _, err := ParseResponse[testWeatherReport](history[:1]); err == nil {
+		t.Error("没有助手回答时应返回错误")
+	}
+	if _, err := UnmarshalResponse[testWeatherReport]("city: 北京"); err == nil {
+		t.Error("非JSON回答应返回错误")
+	}
+}
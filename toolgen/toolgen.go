@@ -1,6 +1,7 @@
 package toolgen
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -26,12 +27,29 @@ func NewToolRegistry(agent agent.Agent) *ToolRegistry {
 // RegisterToolFunc 注册工具函数类型，支持泛型输入输出
 type RegisterToolFunc[T any, R any] func(input T) (R, error)
 
+// RegisterContextToolFunc 带上下文的工具函数类型，支持泛型输入输出
+type RegisterContextToolFunc[T any, R any] func(ctx context.Context, input T) (R, error)
+
 // RegisterTool 注册带输入输出的工具
 func RegisterTool[T any, R any](
 	registry *ToolRegistry,
 	name string,
 	description string,
 	handler RegisterToolFunc[T, R],
+) error {
+	contextHandler := func(_ context.Context, input T) (R, error) {
+		return handler(input)
+	}
+	return RegisterContextTool(registry, name, description, contextHandler, agent.ToolOptions{})
+}
+
+// RegisterContextTool 注册带上下文的工具，处理函数可感知对话取消和超时，options可设置执行超时
+func RegisterContextTool[T any, R any](
+	registry *ToolRegistry,
+	name string,
+	description string,
+	handler RegisterContextToolFunc[T, R],
+	options agent.ToolOptions,
 ) error {
 	// 1. 通过反射获取参数结构体信息
 	var paramType T
@@ -45,7 +63,7 @@ func RegisterTool[T any, R any](
 	}
 
 	// 3. 创建适配器处理函数，处理类型转换
-	wrapperHandler := func(args map[string]interface{}) (string, error) {
+	wrapperHandler := func(ctx context.Context, args map[string]interface{}) (string, error) {
 		// 将map转换为强类型结构体
 		var input T
 		data, err := json.Marshal(args)
@@ -58,7 +76,7 @@ func RegisterTool[T any, R any](
 		}
 
 		// 调用实际处理函数
-		result, err := handler(input)
+		result, err := handler(ctx, input)
 		if err != nil {
 			return "", err
 		}
@@ -73,7 +91,7 @@ func RegisterTool[T any, R any](
 	}
 
 	// 4. 注册工具到Agent
-	return registry.agent.RegisterTool(def, wrapperHandler)
+	return registry.agent.RegisterContextTool(def, wrapperHandler, options)
 }
 
 // RegisterSimpleTool 注册简单工具，只返回字符串
@@ -0,0 +1,44 @@
+package toolgen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/562589540/agent-go/agent"
+)
+
+// 测试toolgen生成的参数Schema可直接用于校验模型给出的参数
+func TestStructSchemaValidation(t *testing.T) {
+	schema := structToJSONSchema(reflect.TypeOf(testWeatherReport{}))
+
+	valid := map[string]interface{}{
+		"city":    "北京",
+		"level":   "low",
+		"hours":   []interface{}{float64(8), float64(9)},
+		"details": map[string]interface{}{"wind": "北风"},
+	}
+	if err := agent.ValidateToolArgs(schema, valid); err != nil {
+		t.Fatalf("合法参数校验失败: %v", err)
+	}
+
+	invalid := map[string]interface{}{
+		"level":   "medium",
+		"hours":   []interface{}{"8"},
+		"details": map[string]interface{}{},
+	}
+	err := agent.ValidateToolArgs(schema, invalid)
+	if err == nil {
+		t.Fatal("不合法参数应返回错误")
+	}
+	for _, want := range []string{"缺少必填参数 city", "参数 level 的值", "参数 hours[0] 应为integer类型", "缺少必填参数 details.wind"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("错误信息缺少 %q: %v", want, err)
+		}
+	}
+
+	// 无参数工具的占位参数不是必填
+	if err := agent.ValidateToolArgs(structToJSONSchema(reflect.TypeOf(EmptyParams{})), nil); err != nil {
+		t.Errorf("无参数工具校验失败: %v", err)
+	}
+}